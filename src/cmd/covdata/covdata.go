@@ -83,18 +83,37 @@ merge       merge data files together
 subtract    subtract one set of data files from another set
 intersect   generate intersection of two sets of data files
 debugdump   dump data in human-readable format for debugging purposes
+pods        list coverage pods (meta-data files and their counter files)
+diff        report functions covered in one set of data files but not another
+validate    check data files for problems without merging them
 `)
 	fmt.Fprintf(os.Stderr, "\nFor help on a specific subcommand, try:\n")
 	fmt.Fprintf(os.Stderr, "\ngo tool covdata <cmd> -help\n")
 	Exit(2)
 }
 
-type covOperation interface {
-	cov.CovDataVisitor
+// covCommand is the part of a subcommand concerned with setup and
+// command line flags.
+type covCommand interface {
 	Setup()
 	Usage(string)
 }
 
+// covOperation is a subcommand that visits coverage data using a
+// cov.CovDataReader.
+type covOperation interface {
+	cov.CovDataVisitor
+	covCommand
+}
+
+// covRunner is a subcommand that does its own reading of the input
+// directories, instead of visiting coverage data with a
+// cov.CovDataReader.
+type covRunner interface {
+	covCommand
+	Run(indirs []string) error
+}
+
 // Modes of operation.
 const (
 	funcMode      = "func"
@@ -105,8 +124,24 @@ const (
 	pkglistMode   = "pkglist"
 	textfmtMode   = "textfmt"
 	debugDumpMode = "debugdump"
+	podsMode      = "pods"
+	diffMode      = "diff"
+	validateMode  = "validate"
 )
 
+// readerFlags returns the flags to use when creating a
+// cov.CovDataReader, based on command line flag settings.
+func readerFlags() cov.CovDataReaderFlags {
+	var flags cov.CovDataReaderFlags
+	if *hflag {
+		flags |= cov.PanicOnError
+	}
+	if *hwflag {
+		flags |= cov.PanicOnWarning
+	}
+	return flags
+}
+
 func main() {
 	// First argument should be mode/subcommand.
 	if len(os.Args) < 2 {
@@ -114,7 +149,7 @@ func main() {
 	}
 
 	// Select mode
-	var op covOperation
+	var op covCommand
 	cmd := os.Args[1]
 	switch cmd {
 	case mergeMode:
@@ -133,6 +168,12 @@ func main() {
 		op = makeSubtractIntersectOp(subtractMode)
 	case intersectMode:
 		op = makeSubtractIntersectOp(intersectMode)
+	case podsMode:
+		op = makePodsOp()
+	case diffMode:
+		op = makeDiffOp()
+	case validateMode:
+		op = makeValidateOp()
 	default:
 		usage(fmt.Sprintf("unknown command selector %q", cmd))
 	}
@@ -205,15 +246,17 @@ func main() {
 	dbgtrace(1, "starting perform")
 
 	indirs := strings.Split(*indirsflag, ",")
-	vis := cov.CovDataVisitor(op)
-	var flags cov.CovDataReaderFlags
-	if *hflag {
-		flags |= cov.PanicOnError
-	}
-	if *hwflag {
-		flags |= cov.PanicOnWarning
+	if r, ok := op.(covRunner); ok {
+		st := 0
+		if err := r.Run(indirs); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			st = 1
+		}
+		dbgtrace(1, "leaving main")
+		Exit(st)
 	}
-	reader := cov.MakeCovDataReader(vis, indirs, *verbflag, flags, matchpkg)
+	vis := op.(cov.CovDataVisitor)
+	reader := cov.MakeCovDataReader(vis, indirs, *verbflag, readerFlags(), matchpkg)
 	st := 0
 	if err := reader.Visit(); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
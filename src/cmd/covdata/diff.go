@@ -0,0 +1,183 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file contains functions and apis to support the "diff"
+// subcommand of "go tool covdata".
+
+import (
+	"cmd/internal/cov"
+	"flag"
+	"fmt"
+	"internal/coverage"
+	"internal/coverage/decodecounter"
+	"internal/coverage/decodemeta"
+	"internal/coverage/pods"
+	"os"
+	"sort"
+	"strings"
+)
+
+var baseindirsflag *string
+
+func makeDiffOp() covRunner {
+	baseindirsflag = flag.String("b", "", "Input dirs to compare against (comma separated)")
+	return &diffState{
+		fns: make(map[diffKey]*diffFunc),
+	}
+}
+
+// diffState implements the "diff" subcommand, which reports the
+// functions that are covered in one set of input directories (-i)
+// but not in another (-b). Each set is read with a CovDataReader,
+// using diffState as the visitor; since function and package indices
+// are specific to a given meta-data file, functions are matched up
+// across the two sets by package path, source file and name.
+type diffState struct {
+	// Which set of directories we're reading: 0 for the dirs
+	// selected with -i, 1 for those selected with -b.
+	set int
+
+	// Functions with nonzero counters in the current pod.
+	podCovered map[pkfunc]bool
+
+	// Import path of the package being visited.
+	pkgPath string
+
+	// All functions seen so far, in either set.
+	fns map[diffKey]*diffFunc
+}
+
+// diffKey identifies a function independently of any particular
+// meta-data file. 'line' is only set for function literals, whose
+// names are not unique within a source file.
+type diffKey struct {
+	pkg, file, name string
+	line            uint32
+}
+
+type diffFunc struct {
+	line    uint32
+	covered [2]bool
+}
+
+func (d *diffState) Usage(msg string) {
+	if len(msg) > 0 {
+		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
+	}
+	fmt.Fprintf(os.Stderr, "usage: go tool covdata diff -i=<directories> -b=<directories>\n\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nExamples:\n\n")
+	fmt.Fprintf(os.Stderr, "  go tool covdata diff -i=dir1,dir2 -b=dir3\n\n")
+	fmt.Fprintf(os.Stderr, "  \treports functions with coverage in dir1+dir2\n")
+	fmt.Fprintf(os.Stderr, "  \tthat have no coverage in dir3.\n")
+	Exit(2)
+}
+
+// Setup is called once at program startup time to vet flag values
+// and do any necessary setup operations.
+func (d *diffState) Setup() {
+	if *indirsflag == "" {
+		d.Usage("select input directories with '-i' option")
+	}
+	if *baseindirsflag == "" {
+		d.Usage("select directories to compare against with '-b' option")
+	}
+}
+
+// Run reads the coverage data in 'indirs' and in the -b directories,
+// then reports the functions covered in the former but not the latter.
+func (d *diffState) Run(indirs []string) error {
+	for set, dirs := range [2][]string{indirs, strings.Split(*baseindirsflag, ",")} {
+		d.set = set
+		reader := cov.MakeCovDataReader(d, dirs, *verbflag, readerFlags(), matchpkg)
+		if err := reader.Visit(); err != nil {
+			return err
+		}
+	}
+
+	var keys []diffKey
+	for k, f := range d.fns {
+		if f.covered[0] && !f.covered[1] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ki.file != kj.file {
+			return ki.file < kj.file
+		}
+		if li, lj := d.fns[ki].line, d.fns[kj].line; li != lj {
+			return li < lj
+		}
+		return ki.name < kj.name
+	})
+	for _, k := range keys {
+		fmt.Printf("%s:%d:\t%s\n", k.file, d.fns[k].line, k.name)
+	}
+	return nil
+}
+
+func (d *diffState) BeginPod(p pods.Pod) {
+	d.podCovered = make(map[pkfunc]bool)
+}
+
+func (d *diffState) EndPod(p pods.Pod) {
+}
+
+func (d *diffState) VisitMetaDataFile(mdf string, mfr *decodemeta.CoverageMetaFileReader) {
+}
+
+func (d *diffState) BeginCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader, dirIdx int) {
+	dbgtrace(2, "visit counter data file %s dirIdx %d", cdf, dirIdx)
+}
+
+func (d *diffState) EndCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader, dirIdx int) {
+}
+
+func (d *diffState) VisitFuncCounterData(data decodecounter.FuncPayload) {
+	for _, c := range data.Counters {
+		if c != 0 {
+			d.podCovered[pkfunc{pk: data.PkgIdx, fcn: data.FuncIdx}] = true
+			return
+		}
+	}
+}
+
+func (d *diffState) EndCounters() {
+}
+
+func (d *diffState) BeginPackage(pd *decodemeta.CoverageMetaDataDecoder, pkgIdx uint32) {
+	d.pkgPath = pd.PackagePath()
+}
+
+func (d *diffState) EndPackage(pd *decodemeta.CoverageMetaDataDecoder, pkgIdx uint32) {
+}
+
+func (d *diffState) VisitFunc(pkgIdx uint32, fnIdx uint32, fd *coverage.FuncDesc) {
+	k := diffKey{pkg: d.pkgPath, file: fd.Srcfile, name: fd.Funcname}
+	// Units aren't necessarily in source order; use the
+	// smallest starting line.
+	var line uint32
+	for i, u := range fd.Units {
+		if i == 0 || u.StLine < line {
+			line = u.StLine
+		}
+	}
+	if fd.Lit {
+		k.line = line
+	}
+	f := d.fns[k]
+	if f == nil {
+		f = &diffFunc{line: line}
+		d.fns[k] = f
+	}
+	if d.podCovered[pkfunc{pk: pkgIdx, fcn: fnIdx}] {
+		f.covered[d.set] = true
+	}
+}
+
+func (d *diffState) Finish() {
+}
@@ -63,6 +63,13 @@
 //		$ go tool covdata merge -i=indir1,indir2 -o=outdir -modpaths=github.com/go-delve/delve
 //      $
 //
+//    The output directory uses the same layout as a GOCOVERDIR
+//    directory: for each program, one meta-data file and one merged
+//    counter data file. With -pcombine, data from distinct programs
+//    is combined into a single meta-data file and counter data file.
+//    With -par=N, the counters read from each counter data file are
+//    merged by N goroutines (-par=-1 uses GOMAXPROCS goroutines).
+//
 // 6. Subtract one profile from another
 //
 //		$ go tool covdata subtract -i=indir1,indir2 -o=outdir
@@ -79,6 +86,31 @@
 //      <human readable output>
 //      $
 //
+// 9. List the pods (meta-data files and their counter data files)
+//    in a set of directories, without decoding them:
+//
+//		$ go tool covdata pods -i=profiledir
+//		pod profiledir/covmeta.cce1b350af34b6d0fb59cc1725f0ee27
+//		  meta hash: cce1b350af34b6d0fb59cc1725f0ee27
+//		  counter data files: 1
+//		  origins:
+//		    profiledir
+//      $
+//
+// 10. Report functions covered in one profile but not in another:
+//
+//		$ go tool covdata diff -i=newdir -b=olddir
+//		cov-example/p/p.go:47:	Medium
+//      $
+//
+// 11. Check a set of data files for problems (orphaned, malformed or
+//     truncated files) before merging them; the exit status is
+//     nonzero if any problems are found:
+//
+//		$ go tool covdata validate -i=profiledir
+//		checked 1 pods, 1 counter data files: PASS
+//      $
+//
 */
 
 package main
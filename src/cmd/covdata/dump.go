@@ -64,6 +64,10 @@ type dstate struct {
 	// instrumented application.
 	mm map[pkfunc]decodecounter.FuncPayload
 
+	// Meta-data file of the pod being visited, and the counter data
+	// file being read, for error messages.
+	mdf, cdf string
+
 	// pkm maps package ID to the number of functions in the package
 	// with that ID. It is used to report inconsistencies in counter
 	// data (for example, a counter data entry with pkgid=N funcid=10
@@ -163,6 +167,7 @@ func (d *dstate) Setup() {
 
 func (d *dstate) BeginPod(p pods.Pod) {
 	d.mm = make(map[pkfunc]decodecounter.FuncPayload)
+	d.mdf = p.MetaFile
 }
 
 func (d *dstate) EndPod(p pods.Pod) {
@@ -173,6 +178,7 @@ func (d *dstate) EndPod(p pods.Pod) {
 
 func (d *dstate) BeginCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader, dirIdx int) {
 	dbgtrace(2, "visit counter data file %s dirIdx %d", cdf, dirIdx)
+	d.cdf = cdf
 	if d.cmd == debugDumpMode {
 		fmt.Printf("data file %s", cdf)
 		if cdr.Goos() != "" {
@@ -201,13 +207,14 @@ func (d *dstate) VisitFuncCounterData(data decodecounter.FuncPayload) {
 
 	dbgtrace(5, "ctr visit pk=%d fid=%d found=%v len(val.ctrs)=%d len(data.ctrs)=%d", data.PkgIdx, data.FuncIdx, found, len(val.Counters), len(data.Counters))
 
-	if len(val.Counters) < len(data.Counters) {
-		t := val.Counters
+	if len(val.Counters) == 0 {
 		val.Counters = d.AllocateCounters(len(data.Counters))
-		copy(val.Counters, t)
 	}
-	err, overflow := d.cm.MergeCounters(val.Counters, data.Counters)
+	err, overflow := d.cm.MergeFuncCounters(data.PkgIdx, data.FuncIdx, val.Counters, data.Counters)
 	if err != nil {
+		if me, ok := err.(*cmerge.MismatchError); ok {
+			me.MetaFile, me.CounterFile = d.mdf, d.cdf
+		}
 		fatal("%v", err)
 	}
 	if overflow {
@@ -19,10 +19,12 @@ import (
 
 var outdirflag *string
 var pcombineflag *bool
+var parflag *int
 
 func makeMergeOp() covOperation {
 	outdirflag = flag.String("o", "", "Output directory to write")
 	pcombineflag = flag.Bool("pcombine", false, "Combine profiles derived from distinct program executables")
+	parflag = flag.Int("par", 1, "Number of goroutines used to merge counters (negative for GOMAXPROCS)")
 	m := &mstate{
 		mm: newMetaMerge(),
 	}
@@ -49,7 +51,12 @@ func (m *mstate) Usage(msg string) {
 	fmt.Fprintf(os.Stderr, "\nExamples:\n\n")
 	fmt.Fprintf(os.Stderr, "  go tool covdata merge -i=dir1,dir2,dir3 -o=outdir\n\n")
 	fmt.Fprintf(os.Stderr, "  \tmerges all files in dir1/dir2/dir3\n")
-	fmt.Fprintf(os.Stderr, "  \tinto output dir outdir\n")
+	fmt.Fprintf(os.Stderr, "  \tinto output dir outdir, writing one meta-data\n")
+	fmt.Fprintf(os.Stderr, "  \tfile and one counter data file per program\n\n")
+	fmt.Fprintf(os.Stderr, "  go tool covdata merge -pcombine -i=dir1,dir2 -o=outdir\n\n")
+	fmt.Fprintf(os.Stderr, "  \tmerges all files in dir1/dir2, combining data\n")
+	fmt.Fprintf(os.Stderr, "  \tfrom distinct programs into a single meta-data\n")
+	fmt.Fprintf(os.Stderr, "  \tfile and counter data file in outdir\n")
 	Exit(2)
 }
 
@@ -60,6 +67,7 @@ func (m *mstate) Setup() {
 	if *outdirflag == "" {
 		m.Usage("select output directory with '-o' option")
 	}
+	m.mm.setParallelism(*parflag)
 }
 
 func (m *mstate) BeginPod(p pods.Pod) {
@@ -72,10 +80,11 @@ func (m *mstate) EndPod(p pods.Pod) {
 
 func (m *mstate) BeginCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader, dirIdx int) {
 	dbgtrace(2, "visit counter data file %s dirIdx %d", cdf, dirIdx)
-	m.mm.beginCounterDataFile(cdr)
+	m.mm.beginCounterDataFile(cdf, cdr)
 }
 
 func (m *mstate) EndCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader, dirIdx int) {
+	m.mm.endCounterDataFile()
 }
 
 func (m *mstate) VisitFuncCounterData(data decodecounter.FuncPayload) {
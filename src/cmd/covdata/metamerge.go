@@ -18,10 +18,12 @@ import (
 	"internal/coverage/decodemeta"
 	"internal/coverage/encodecounter"
 	"internal/coverage/encodemeta"
+	"internal/coverage/pods"
 	"internal/coverage/slicewriter"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"time"
 	"unsafe"
@@ -59,6 +61,26 @@ type metaMerge struct {
 	pod *podstate
 	// counter data file osargs/goos/goarch state
 	astate *argstate
+	// merge counters in parallel, see setParallelism
+	parallel bool
+	// counters of the current counter data file waiting to be
+	// merged, by package index (only when merging in parallel)
+	pending map[uint32]*pendingPkg
+	// number of flushPending calls so far
+	flushes int
+	// copies of the counters in 'pending'
+	scratch []uint32
+}
+
+// pendingPkg holds the counters of one package waiting to be merged
+// by flushPending: the counters scratch[lo[i]:hi[i]] of function
+// funcs[i] are to be merged into dst[i]. A function f has counters
+// waiting if stamp[f] is one more than the number of flushes so far.
+type pendingPkg struct {
+	funcs  []uint32
+	dst    [][]uint32
+	lo, hi []int
+	stamp  []int
 }
 
 // pkstate
@@ -76,10 +98,20 @@ type pkstate struct {
 type podstate struct {
 	pmm      map[pkfunc]decodecounter.FuncPayload
 	mdf      string
+	cdf      string // counter data file being visited
 	mfr      *decodemeta.CoverageMetaFileReader
 	fileHash [16]byte
 }
 
+// mismatch fills in the pod's meta-data file and the current counter
+// data file in 'err' if it is a *cmerge.MismatchError, and returns it.
+func (p *podstate) mismatch(err error) error {
+	if me, ok := err.(*cmerge.MismatchError); ok {
+		me.MetaFile, me.CounterFile = p.mdf, p.cdf
+	}
+	return err
+}
+
 type pkfunc struct {
 	pk, fcn uint32
 }
@@ -117,7 +149,21 @@ func (mm *metaMerge) visitMetaDataFile(mdf string, mfr *decodemeta.CoverageMetaF
 	}
 }
 
-func (mm *metaMerge) beginCounterDataFile(cdr *decodecounter.CounterDataReader) {
+// setParallelism sets the number of goroutines used to merge
+// counters, as for cmerge.Merger.SetParallelism. When that is more
+// than one, visitFuncCounterData copies the counters of each function
+// as they are read, and endCounterDataFile merges them a package at
+// a time with MergeFuncTable.
+func (mm *metaMerge) setParallelism(n int) {
+	mm.SetParallelism(n)
+	if n < 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	mm.parallel = n > 1
+}
+
+func (mm *metaMerge) beginCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader) {
+	mm.pod.cdf = cdf
 	state := argvalues{
 		osargs: cdr.OsArgs(),
 		goos:   cdr.Goos(),
@@ -126,6 +172,75 @@ func (mm *metaMerge) beginCounterDataFile(cdr *decodecounter.CounterDataReader)
 	mm.astate.Merge(state)
 }
 
+func (mm *metaMerge) endCounterDataFile() {
+	if mm.pending != nil {
+		mm.flushPending()
+	}
+}
+
+// deferMerge arranges for the counters 'src' of function 'key' to be
+// merged into 'dst' when the current counter data file has been
+// read. 'src' is copied, since the reader reuses it.
+func (mm *metaMerge) deferMerge(key pkfunc, dst, src []uint32) {
+	if mm.pending == nil {
+		mm.pending = make(map[uint32]*pendingPkg)
+	}
+	pp := mm.pending[key.pk]
+	if pp == nil {
+		pp = new(pendingPkg)
+		mm.pending[key.pk] = pp
+	}
+	f := int(key.fcn)
+	if f < len(pp.stamp) && pp.stamp[f] == mm.flushes+1 {
+		// The function appears twice in the file; merge what we
+		// have, so that no two goroutines merge into 'dst' at once.
+		mm.flushPending()
+	}
+	for len(pp.stamp) <= f {
+		pp.stamp = append(pp.stamp, 0)
+	}
+	pp.stamp[f] = mm.flushes + 1
+	pp.funcs = append(pp.funcs, key.fcn)
+	pp.dst = append(pp.dst, dst)
+	pp.lo = append(pp.lo, len(mm.scratch))
+	mm.scratch = append(mm.scratch, src...)
+	pp.hi = append(pp.hi, len(mm.scratch))
+}
+
+// flushPending merges the counters deferred by deferMerge, a package
+// at a time, in increasing order of package index.
+func (mm *metaMerge) flushPending() {
+	pkgs := make([]uint32, 0, len(mm.pending))
+	for pk, pp := range mm.pending {
+		if len(pp.funcs) != 0 {
+			pkgs = append(pkgs, pk)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i] < pkgs[j] })
+	for _, pk := range pkgs {
+		pp := mm.pending[pk]
+		src := make([][]uint32, len(pp.dst))
+		for i := range src {
+			src[i] = mm.scratch[pp.lo[i]:pp.hi[i]]
+		}
+		err, overflow := mm.MergeFuncTable(pk, pp.dst, src)
+		if me, ok := err.(*cmerge.MismatchError); ok {
+			// MergeFuncTable reports the index within the table.
+			me.FuncIdx = pp.funcs[me.FuncIdx]
+		}
+		if err != nil {
+			fatal("%v", mm.pod.mismatch(err))
+		}
+		if overflow {
+			warn("uint32 overflow during counter merge")
+		}
+		pp.funcs, pp.dst = pp.funcs[:0], pp.dst[:0]
+		pp.lo, pp.hi = pp.lo[:0], pp.hi[:0]
+	}
+	mm.flushes++
+	mm.scratch = mm.scratch[:0]
+}
+
 func copyMetaDataFile(inpath, outpath string) {
 	inf, err := os.Open(inpath)
 	if err != nil {
@@ -231,7 +346,7 @@ func (mm *metaMerge) emitMeta(outdir string, pcombine bool) [16]byte {
 	copy(finalHash[:], fhh)
 
 	// Open meta-file for writing.
-	fn := fmt.Sprintf("%s.%x", coverage.MetaFilePref, finalHash)
+	fn := pods.MetaFileName(fmt.Sprintf("%x", finalHash))
 	fpath := filepath.Join(outdir, fn)
 	mf, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
@@ -253,7 +368,7 @@ func (mm *metaMerge) emitCounters(outdir string, metaHash [16]byte) {
 	// consistency (however the process ID is not meaningful here, so
 	// use a value of zero).
 	var dummyPID int
-	fn := fmt.Sprintf(coverage.CounterFileTempl, coverage.CounterFilePref, metaHash, dummyPID, time.Now().UnixNano())
+	fn := pods.CounterFileName(fmt.Sprintf("%x", metaHash), dummyPID, time.Now().UnixNano())
 	fpath := filepath.Join(outdir, fn)
 	cf, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
@@ -348,20 +463,23 @@ func (mm *metaMerge) visitPackage(pd *decodemeta.CoverageMetaDataDecoder, pkgIdx
 func (mm *metaMerge) visitFuncCounterData(data decodecounter.FuncPayload) {
 	key := pkfunc{pk: data.PkgIdx, fcn: data.FuncIdx}
 	val := mm.pod.pmm[key]
-	// FIXME: in theory either A) len(val.Counters) is zero, or B)
-	// the two lengths are equal. Assert if not? Of course, we could
-	// see odd stuff if there is source file skew.
+	// Either this is the first time we've seen the function in this
+	// pod, or the lengths must agree; MergeFuncCounters reports a
+	// mismatch, which indicates a corrupt or misgrouped file.
 	if *verbflag > 4 {
 		fmt.Printf("visit pk=%d fid=%d len(counters)=%d\n", data.PkgIdx, data.FuncIdx, len(data.Counters))
 	}
-	if len(val.Counters) < len(data.Counters) {
-		t := val.Counters
+	if len(val.Counters) == 0 {
 		val.Counters = mm.AllocateCounters(len(data.Counters))
-		copy(val.Counters, t)
 	}
-	err, overflow := mm.MergeCounters(val.Counters, data.Counters)
+	if mm.parallel {
+		mm.deferMerge(key, val.Counters, data.Counters)
+		mm.pod.pmm[key] = val
+		return
+	}
+	err, overflow := mm.MergeFuncCounters(data.PkgIdx, data.FuncIdx, val.Counters, data.Counters)
 	if err != nil {
-		fatal("%v", err)
+		fatal("%v", mm.pod.mismatch(err))
 	}
 	if overflow {
 		warn("uint32 overflow during counter merge")
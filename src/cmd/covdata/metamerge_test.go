@@ -0,0 +1,80 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"internal/coverage"
+	"internal/coverage/decodecounter"
+	"reflect"
+	"testing"
+)
+
+// mkCounterFiles returns the function payloads of 'nfiles' counter
+// data files, each holding 'nfuncs' functions of 'nctrs' counters for
+// each of 'npkgs' packages. The first function of the first file
+// appears a second time at the end of it.
+func mkCounterFiles(nfiles, npkgs, nfuncs, nctrs int) [][]decodecounter.FuncPayload {
+	files := make([][]decodecounter.FuncPayload, nfiles)
+	v := uint32(1)
+	for f := range files {
+		for pk := 0; pk < npkgs; pk++ {
+			for fn := 0; fn < nfuncs; fn++ {
+				ctrs := make([]uint32, nctrs)
+				for i := range ctrs {
+					v = v*1103515245 + 12345
+					ctrs[i] = v >> 28
+				}
+				files[f] = append(files[f], decodecounter.FuncPayload{PkgIdx: uint32(pk), FuncIdx: uint32(fn), Counters: ctrs})
+			}
+		}
+	}
+	files[0] = append(files[0], files[0][0])
+	return files
+}
+
+// mergeCounterFiles merges the counter data files 'files' of a pod
+// with parallelism 'par', passing each payload in a reused buffer as
+// the counter data reader does, and returns the merged counters.
+func mergeCounterFiles(t testing.TB, par int, files [][]decodecounter.FuncPayload) map[pkfunc]decodecounter.FuncPayload {
+	mm := newMetaMerge()
+	mm.setParallelism(par)
+	if err := mm.SetModeAndGranularity("covmeta.x", coverage.CtrModeCount, coverage.CtrGranularityPerBlock); err != nil {
+		t.Fatal(err)
+	}
+	mm.beginPod()
+	var buf []uint32
+	for k, payloads := range files {
+		mm.pod.cdf = fmt.Sprintf("covcounters.x.%d", k)
+		for _, fp := range payloads {
+			buf = append(buf[:0], fp.Counters...)
+			fp.Counters = buf
+			mm.visitFuncCounterData(fp)
+		}
+		mm.endCounterDataFile()
+	}
+	return mm.pod.pmm
+}
+
+func TestParallelMerge(t *testing.T) {
+	files := mkCounterFiles(3, 2, 1000, 40)
+	want := mergeCounterFiles(t, 1, files)
+	for _, par := range []int{2, 4, -1} {
+		if got := mergeCounterFiles(t, par, files); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge with -par=%d differs from sequential merge", par)
+		}
+	}
+}
+
+func BenchmarkMergeCounterFiles(b *testing.B) {
+	files := mkCounterFiles(8, 4, 2000, 32)
+	for _, par := range []int{1, 4, -1} {
+		b.Run(fmt.Sprintf("par=%d", par), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mergeCounterFiles(b, par, files)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file contains functions and apis to support the "pods"
+// subcommand of "go tool covdata", which lists the pods found in a
+// set of input directories without decoding any coverage data.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"internal/coverage/pods"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var podsjsonflag *bool
+
+func makePodsOp() covRunner {
+	podsjsonflag = flag.Bool("json", false, "Emit pod listing in JSON format")
+	return &podsState{}
+}
+
+// podsState implements the "pods" subcommand. Unlike most other
+// subcommands it is a covRunner and not a covOperation, since it
+// only needs to look at file names.
+type podsState struct {
+}
+
+// podsReport is the result of the "pods" subcommand; it is written
+// out directly in JSON mode.
+type podsReport struct {
+	// Dirs lists the input directories; pod origins index into it.
+	Dirs []string
+
+	Pods []podReport
+
+	// Files skipped during pod collection.
+	OrphanCounterFiles    []string `json:",omitempty"`
+	MalformedMetaFiles    []string `json:",omitempty"`
+	MalformedCounterFiles []string `json:",omitempty"`
+}
+
+// podReport describes a single pod.
+type podReport struct {
+	MetaFile        string
+	MetaHash        string
+	NumCounterFiles int
+	// Distinct origins of the pod's counter data files, in
+	// increasing order.
+	Origins []int
+}
+
+func (p *podsState) Usage(msg string) {
+	if len(msg) > 0 {
+		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
+	}
+	fmt.Fprintf(os.Stderr, "usage: go tool covdata pods -i=<directories>\n\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nExamples:\n\n")
+	fmt.Fprintf(os.Stderr, "  go tool covdata pods -i=dir1,dir2\n\n")
+	fmt.Fprintf(os.Stderr, "  \tlists the pods (meta-data files and their\n")
+	fmt.Fprintf(os.Stderr, "  \tcounter data files) found in dir1+dir2, along\n")
+	fmt.Fprintf(os.Stderr, "  \twith any files that had to be skipped.\n")
+	Exit(2)
+}
+
+// Setup is called once at program startup time to vet flag values
+// and do any necessary setup operations.
+func (p *podsState) Setup() {
+	if *indirsflag == "" {
+		p.Usage("select input directories with '-i' option")
+	}
+}
+
+// Run collects the pods in 'indirs' and writes out a listing.
+func (p *podsState) Run(indirs []string) error {
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	podlist, err := cfg.CollectPods(indirs)
+	if err != nil {
+		return fmt.Errorf("reading inputs: %v", err)
+	}
+	r := podsReport{
+		Dirs:                  indirs,
+		Pods:                  []podReport{},
+		OrphanCounterFiles:    st.OrphanCounterFiles,
+		MalformedMetaFiles:    st.MalformedMetaNames,
+		MalformedCounterFiles: st.MalformedCounterNames,
+	}
+	for _, pod := range podlist {
+		_, hash, _, _ := pods.DefaultClassifier.Classify(filepath.Base(pod.MetaFile))
+		r.Pods = append(r.Pods, podReport{
+			MetaFile:        pod.MetaFile,
+			MetaHash:        hash,
+			NumCounterFiles: len(pod.CounterDataFiles),
+			Origins:         distinctOrigins(pod.Origins),
+		})
+	}
+	if *podsjsonflag {
+		b, err := json.MarshalIndent(r, "", "\t")
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	p.emitListing(r)
+	return nil
+}
+
+func (p *podsState) emitListing(r podsReport) {
+	if len(r.Pods) == 0 {
+		fmt.Printf("no pods found\n")
+	}
+	for _, pr := range r.Pods {
+		fmt.Printf("pod %s\n", pr.MetaFile)
+		fmt.Printf("  meta hash: %s\n", pr.MetaHash)
+		fmt.Printf("  counter data files: %d\n", pr.NumCounterFiles)
+		if len(pr.Origins) != 0 {
+			fmt.Printf("  origins:\n")
+			for _, o := range pr.Origins {
+				fmt.Printf("    %s\n", r.Dirs[o])
+			}
+		}
+	}
+	for _, f := range r.OrphanCounterFiles {
+		fmt.Printf("orphaned counter data file: %s\n", f)
+	}
+	for _, f := range r.MalformedMetaFiles {
+		fmt.Printf("malformed meta-data file name: %s\n", f)
+	}
+	for _, f := range r.MalformedCounterFiles {
+		fmt.Printf("malformed counter data file name: %s\n", f)
+	}
+}
+
+// distinctOrigins returns the distinct values in 'origins' in
+// increasing order.
+func distinctOrigins(origins []int) []int {
+	res := []int{}
+	seen := make(map[int]bool)
+	for _, o := range origins {
+		if !seen[o] {
+			seen[o] = true
+			res = append(res, o)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
@@ -11,6 +11,7 @@ import (
 	"flag"
 	"fmt"
 	"internal/coverage"
+	"internal/coverage/cmerge"
 	"internal/coverage/decodecounter"
 	"internal/coverage/decodemeta"
 	"internal/coverage/pods"
@@ -112,6 +113,7 @@ func (s *sstate) pruneCounters() {
 
 func (s *sstate) BeginCounterDataFile(cdf string, cdr *decodecounter.CounterDataReader, dirIdx int) {
 	dbgtrace(2, "visiting counter data file %s diridx %d", cdf, dirIdx)
+	s.mm.pod.cdf = cdf
 	if s.inidx != dirIdx {
 		if s.inidx > dirIdx {
 			// We're relying on having data files presented in
@@ -158,6 +160,14 @@ func (s *sstate) VisitFuncCounterData(data decodecounter.FuncPayload) {
 	// If we're looking at counter data from a dir other than
 	// the first, then perform the intersect/subtract.
 	if val, ok := s.mm.pod.pmm[key]; ok {
+		if len(val.Counters) != len(data.Counters) {
+			fatal("%v", s.mm.pod.mismatch(&cmerge.MismatchError{
+				PkgIdx:  data.PkgIdx,
+				FuncIdx: data.FuncIdx,
+				DstLen:  len(val.Counters),
+				SrcLen:  len(data.Counters),
+			}))
+		}
 		if s.mode == subtractMode {
 			for i := 0; i < len(data.Counters); i++ {
 				if data.Counters[i] != 0 {
@@ -6,6 +6,7 @@ package main_test
 
 import (
 	cmdcovdata "cmd/covdata"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"internal/coverage/pods"
@@ -276,6 +277,18 @@ func TestCovTool(t *testing.T) {
 		t.Parallel()
 		testEmpty(t, s)
 	})
+	t.Run("Pods", func(t *testing.T) {
+		t.Parallel()
+		testPods(t, s)
+	})
+	t.Run("Diff", func(t *testing.T) {
+		t.Parallel()
+		testDiff(t, s)
+	})
+	t.Run("Validate", func(t *testing.T) {
+		t.Parallel()
+		testValidate(t, s)
+	})
 	t.Run("TestCommandLineErrors", func(t *testing.T) {
 		t.Parallel()
 		testCommandLineErrors(t, s, s.outdirs[0])
@@ -405,6 +418,123 @@ func testPkgList(t *testing.T, s state) {
 	}
 }
 
+func testPods(t *testing.T, s state) {
+	dargs := []string{"-i=" + s.outdirs[0] + "," + s.outdirs[1]}
+	lines := runToolOp(t, s, "pods", dargs)
+	want := []*regexp.Regexp{
+		regexp.MustCompile(`^pod .*covmeta\.([0-9a-f]+)$`),
+		regexp.MustCompile(`^  meta hash: [0-9a-f]+$`),
+		regexp.MustCompile(`^  counter data files: 3$`),
+		regexp.MustCompile(`^  origins:$`),
+		regexp.MustCompile(`^    ` + regexp.QuoteMeta(s.outdirs[0]) + `$`),
+		regexp.MustCompile(`^    ` + regexp.QuoteMeta(s.outdirs[1]) + `$`),
+	}
+	if len(lines) != len(want) {
+		t.Errorf("pods: got %d lines want %d", len(lines), len(want))
+		dumplines(lines)
+		return
+	}
+	for i, re := range want {
+		if !re.MatchString(lines[i]) {
+			t.Errorf("pods: line %d %q does not match %s", i, lines[i], re)
+		}
+	}
+
+	// Same thing, in JSON form.
+	dargs = append([]string{"-json"}, dargs...)
+	lines = runToolOp(t, s, "pods", dargs)
+	var r struct {
+		Dirs []string
+		Pods []struct {
+			MetaFile        string
+			MetaHash        string
+			NumCounterFiles int
+			Origins         []int
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.Join(lines, "\n")), &r); err != nil {
+		t.Fatalf("pods -json: %v", err)
+	}
+	if len(r.Dirs) != 2 || len(r.Pods) != 1 {
+		t.Fatalf("pods -json: unexpected result %+v", r)
+	}
+	p := r.Pods[0]
+	if !strings.HasSuffix(p.MetaFile, p.MetaHash) || p.NumCounterFiles != 3 || fmt.Sprint(p.Origins) != "[0 1]" {
+		t.Errorf("pods -json: unexpected pod %+v", p)
+	}
+}
+
+func testDiff(t *testing.T, s state) {
+	// Runs with arguments (in outdirs[1]) execute "second" and
+	// "third", runs without (in outdirs[0]) execute "first" and
+	// "third"; "main" and the "dep" functions run either way.
+	for _, tc := range []struct {
+		a, b string
+		want []string
+	}{
+		{s.outdirs[1], s.outdirs[0], []string{"second"}},
+		{s.outdirs[0], s.outdirs[1], []string{"first"}},
+		{s.outdirs[0], s.outdirs[0], nil},
+	} {
+		dargs := []string{"-i=" + tc.a, "-b=" + tc.b}
+		lines := runToolOp(t, s, "diff", dargs)
+		var got []string
+		for _, line := range lines {
+			f := strings.Split(line, "\t")
+			if len(f) != 2 || !strings.HasSuffix(f[0], ":") {
+				t.Errorf("diff %v: malformed line %q", dargs, line)
+				continue
+			}
+			got = append(got, f[1])
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("diff %v: got %v want %v", dargs, got, tc.want)
+			dumplines(lines)
+		}
+	}
+}
+
+func testValidate(t *testing.T, s state) {
+	dargs := []string{"-i=" + s.outdirs[0] + "," + s.outdirs[1]}
+	lines := runToolOp(t, s, "validate", dargs)
+	want := "checked 1 pods, 3 counter data files: PASS"
+	if len(lines) != 1 || lines[0] != want {
+		t.Errorf("validate: got %q want %q", lines, want)
+	}
+
+	// Copy outdirs[0], cutting its counter data file short.
+	bad := filepath.Join(s.dir, "validateBad")
+	if err := os.Mkdir(bad, 0777); err != nil {
+		t.Fatalf("can't create dir %s: %v", bad, err)
+	}
+	ents, err := os.ReadDir(s.outdirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		b, err := os.ReadFile(filepath.Join(s.outdirs[0], e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(e.Name(), "covcounters.") {
+			b = b[:len(b)-4]
+		}
+		if err := os.WriteFile(filepath.Join(bad, e.Name()), b, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(s.tool, "validate", "-i="+bad)
+	b, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("validate of truncated data passed unexpectedly:\n%s", b)
+	}
+	for _, want := range []string{"is truncated", "FAIL (1 problems)"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("validate of truncated data: wanted %q in output:\n%s", want, b)
+		}
+	}
+}
+
 func testTextfmt(t *testing.T, s state) {
 	outf := s.dir + "/" + "t.txt"
 	dargs := []string{"-pkg=main", "-i=" + s.outdirs[0] + "," + s.outdirs[1],
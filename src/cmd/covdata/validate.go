@@ -0,0 +1,166 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file contains functions and apis to support the "validate"
+// subcommand of "go tool covdata", which checks a set of input
+// directories for problems (orphaned or malformed files, truncated
+// or corrupt data) without merging or reporting on their contents.
+
+import (
+	"flag"
+	"fmt"
+	"internal/coverage/decodecounter"
+	"internal/coverage/decodemeta"
+	"internal/coverage/pods"
+	"os"
+	"path/filepath"
+)
+
+func makeValidateOp() covRunner {
+	return &validateState{}
+}
+
+// validateState implements the "validate" subcommand. Like "pods",
+// it is a covRunner, since it reads each file directly rather than
+// visiting the coverage data with a cov.CovDataReader.
+type validateState struct {
+	problems int
+}
+
+func (v *validateState) Usage(msg string) {
+	if len(msg) > 0 {
+		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
+	}
+	fmt.Fprintf(os.Stderr, "usage: go tool covdata validate -i=<directories>\n\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nExamples:\n\n")
+	fmt.Fprintf(os.Stderr, "  go tool covdata validate -i=dir1,dir2\n\n")
+	fmt.Fprintf(os.Stderr, "  \tchecks that every counter data file in dir1+dir2\n")
+	fmt.Fprintf(os.Stderr, "  \thas a meta-data file and that no file is malformed\n")
+	fmt.Fprintf(os.Stderr, "  \tor truncated, exiting with a nonzero status if\n")
+	fmt.Fprintf(os.Stderr, "  \tany problems are found.\n")
+	Exit(2)
+}
+
+// Setup is called once at program startup time to vet flag values
+// and do any necessary setup operations.
+func (v *validateState) Setup() {
+	if *indirsflag == "" {
+		v.Usage("select input directories with '-i' option")
+	}
+}
+
+// Run collects the pods in 'indirs', then reads each meta-data file
+// and counter data file in full, printing a line for each problem
+// found and a summary at the end. An error is returned if there were
+// any problems.
+func (v *validateState) Run(indirs []string) error {
+	var st pods.Stats
+	cfg := pods.Config{
+		Stats:               &st,
+		StrictCounterHashes: true,
+		VerifyMetaFiles:     true,
+	}
+	podlist, err := cfg.CollectPods(indirs)
+	if err != nil {
+		return fmt.Errorf("reading inputs: %v", err)
+	}
+	for _, f := range st.OrphanCounterFiles {
+		v.problem(f, "no meta-data file found")
+	}
+	for _, f := range st.MalformedMetaNames {
+		v.problem(f, "malformed meta-data file name")
+	}
+	for _, f := range st.MalformedCounterNames {
+		v.problem(f, "malformed counter data file name")
+	}
+	for _, f := range st.MismatchedMetaFiles {
+		v.problem(f, "contents differ from other meta-data file with the same hash")
+	}
+
+	for _, p := range podlist {
+		npkgs, ok := v.checkMetaFile(p.MetaFile)
+		for _, cdf := range p.CounterDataFiles {
+			v.checkCounterFile(cdf, npkgs, ok)
+		}
+	}
+
+	fmt.Printf("checked %d pods, %d counter data files: ", len(podlist), pods.TotalCounterFiles(podlist))
+	if v.problems != 0 {
+		fmt.Printf("FAIL (%d problems)\n", v.problems)
+		return fmt.Errorf("validation failed")
+	}
+	fmt.Printf("PASS\n")
+	return nil
+}
+
+// problem reports a problem with file 'f'.
+func (v *validateState) problem(f string, s string, a ...interface{}) {
+	v.problems++
+	fmt.Printf("%s: %s\n", f, fmt.Sprintf(s, a...))
+}
+
+// checkMetaFile reads and checks the meta-data file 'mdf', returning
+// the number of packages it holds and whether it was readable.
+func (v *validateState) checkMetaFile(mdf string) (uint64, bool) {
+	f, err := os.Open(mdf)
+	if err != nil {
+		v.problem(mdf, "%v", err)
+		return 0, false
+	}
+	defer f.Close()
+	mfr, err := decodemeta.NewCoverageMetaFileReader(f, nil)
+	if err != nil {
+		v.problem(mdf, "reading meta-data file: %v", err)
+		return 0, false
+	}
+	_, hash, _, _ := pods.DefaultClassifier.Classify(filepath.Base(mdf))
+	if got := fmt.Sprintf("%x", mfr.FileHash()); got != hash {
+		v.problem(mdf, "file hash %s does not match name", got)
+	}
+	var payload []byte
+	for pkIdx := uint32(0); uint64(pkIdx) < mfr.NumPackages(); pkIdx++ {
+		var pd *decodemeta.CoverageMetaDataDecoder
+		pd, payload, err = mfr.GetPackageDecoder(pkIdx, payload)
+		if err == nil {
+			err = pd.Validate()
+		}
+		if err != nil {
+			v.problem(mdf, "package %d: %v", pkIdx, err)
+			return 0, false
+		}
+	}
+	return mfr.NumPackages(), true
+}
+
+// checkCounterFile reads and checks the counter data file 'cdf',
+// whose meta-data file holds 'npkgs' packages; if 'metaOK' is false,
+// the meta-data file couldn't be read, and package indices are not
+// checked.
+func (v *validateState) checkCounterFile(cdf string, npkgs uint64, metaOK bool) {
+	f, err := os.Open(cdf)
+	if err != nil {
+		v.problem(cdf, "%v", err)
+		return
+	}
+	defer f.Close()
+	cdr, err := decodecounter.NewCounterDataReader(cdf, f)
+	if err != nil {
+		v.problem(cdf, "%v", err)
+		return
+	}
+	bad := false
+	err = cdr.VisitFuncs(func(pkgIdx, funcIdx uint32, counters []uint32) bool {
+		if metaOK && uint64(pkgIdx) >= npkgs {
+			v.problem(cdf, "function %d refers to package %d, but meta-data file has %d packages", funcIdx, pkgIdx, npkgs)
+			bad = true
+		}
+		return !bad
+	})
+	if err != nil {
+		v.problem(cdf, "%v", err)
+	}
+}
@@ -884,6 +884,12 @@ func runtime_coverage_processCoverTestDir(dir string, cfile string, cmode string
 //go:linkname testing_registerCover2 testing.registerCover2
 func testing_registerCover2(mode string, tearDown func(coverprofile string, gocoverdir string) (string, error))
 
+//go:linkname runtime_coverage_snapshotCoverTestDir runtime/coverage.snapshotCoverTestDir
+func runtime_coverage_snapshotCoverTestDir(dir string) error
+
+//go:linkname testing_registerCoverSnapshot testing.registerCoverSnapshot
+func testing_registerCoverSnapshot(snapshot func(dir string) error)
+
 //go:linkname runtime_coverage_markProfileEmitted runtime/coverage.markProfileEmitted
 func runtime_coverage_markProfileEmitted(val bool)
 
@@ -908,6 +914,7 @@ func coverTearDown(coverprofile string, gocoverdir string) (string, error) {
 func main() {
 {{if .Cover}}
 	testing_registerCover2({{printf "%q" .Cover.Mode}}, coverTearDown)
+	testing_registerCoverSnapshot(runtime_coverage_snapshotCoverTestDir)
 {{end}}
 	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, fuzzTargets, examples)
 {{with .TestMain}}
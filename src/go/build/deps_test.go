@@ -559,7 +559,8 @@ var depsRules = `
     FMT, math, internal/coverage
     < internal/coverage/cmerge;
 
-    FMT, math, internal/coverage, internal/coverage/cmerge, text/tabwriter
+    FMT, html, math, internal/coverage, internal/coverage/cmerge,
+    text/tabwriter
     < internal/coverage/cformat;
 
     FMT, io, internal/coverage/slicereader, internal/coverage/uleb128
@@ -573,18 +574,23 @@ var depsRules = `
     internal/coverage/stringtab, internal/coverage/slicewriter, os, unsafe
     < internal/coverage/encodecounter;
 
-    FMT, encoding/binary, internal/coverage, io, os,
-    internal/coverage/slicereader, internal/coverage/stringtab
+    FMT, encoding/binary, internal/coverage, internal/coverage/cmerge,
+    io, os, internal/coverage/slicereader, internal/coverage/stringtab
     < internal/coverage/decodecounter;
 
     FMT, encoding/binary, internal/coverage, io, os,
-    crypto/md5, internal/coverage/stringtab
+    crypto/md5, internal/coverage/stringtab, syscall
     < internal/coverage/decodemeta;
 
-    FMT, internal/coverage, os,
-    path/filepath, regexp, sort, strconv
+    FMT, internal/coverage, io, os, path, path/filepath, regexp, sort,
+    strconv, strings
     < internal/coverage/pods;
 
+    FMT, archive/zip, compress/gzip, encoding/binary, encoding/json,
+    internal/coverage, internal/coverage/decodemeta,
+    internal/coverage/pods, io, os, path, path/filepath, sort, strings
+    < internal/coverage/pods/podsx;
+
     FMT, bufio, crypto/md5, encoding/binary, runtime/debug,
     internal/coverage, internal/coverage/cmerge,
     internal/coverage/cformat, internal/coverage/calloc,
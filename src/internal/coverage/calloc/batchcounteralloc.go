@@ -12,6 +12,8 @@ package calloc
 
 type BatchCounterAlloc struct {
 	pool []uint32
+	buf  []uint32 // chunk that 'pool' was carved from
+	used int      // counters allocated since the last Reset
 }
 
 func (ca *BatchCounterAlloc) AllocateCounters(n int) []uint32 {
@@ -21,9 +23,33 @@ func (ca *BatchCounterAlloc) AllocateCounters(n int) []uint32 {
 		if n > chunk {
 			siz = n
 		}
-		ca.pool = make([]uint32, siz)
+		ca.buf = make([]uint32, siz)
+		ca.pool = ca.buf
 	}
 	rv := ca.pool[:n]
 	ca.pool = ca.pool[n:]
+	ca.used += n
 	return rv
 }
+
+// Reset makes the storage handed out by the allocator available for
+// reuse, so that a single allocator can be used for a series of pods
+// (or other units of work) without allocating fresh storage for
+// each. After a call to Reset, the counter slices returned by earlier
+// calls to AllocateCounters must no longer be used. Counter slices
+// returned after Reset are zeroed, as for a new allocator. If the
+// counters allocated since the previous Reset did not fit in a single
+// chunk, Reset replaces the chunk with one large enough to hold them
+// all, so that repeating the same allocations does not allocate.
+func (ca *BatchCounterAlloc) Reset() {
+	if ca.used > len(ca.buf) {
+		ca.buf = make([]uint32, ca.used)
+	} else {
+		used := ca.buf[:len(ca.buf)-len(ca.pool)]
+		for i := range used {
+			used[i] = 0
+		}
+	}
+	ca.pool = ca.buf
+	ca.used = 0
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package calloc
+
+import "testing"
+
+func TestReset(t *testing.T) {
+	var ca BatchCounterAlloc
+	for round := 0; round < 3; round++ {
+		for _, n := range []int{10, 5000, 9000, 3} {
+			c := ca.AllocateCounters(n)
+			if len(c) != n {
+				t.Fatalf("round %d: AllocateCounters(%d) returned %d counters", round, n, len(c))
+			}
+			for i, v := range c {
+				if v != 0 {
+					t.Fatalf("round %d: AllocateCounters(%d) counter %d is %d, want 0", round, n, i, v)
+				}
+				c[i] = 1
+			}
+		}
+		ca.Reset()
+	}
+
+	// After a Reset, the same allocations need no new storage.
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, n := range []int{10, 5000, 9000, 3} {
+			ca.AllocateCounters(n)
+		}
+		ca.Reset()
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs per round after Reset, want 0", allocs)
+	}
+}
+
+// BenchmarkReset allocates counters for a series of pods, reusing a
+// single allocator across pods with Reset, or using a new allocator
+// for each pod.
+func BenchmarkReset(b *testing.B) {
+	const npods = 100
+	pod := func(ca *BatchCounterAlloc) {
+		for f := 0; f < 500; f++ {
+			ca.AllocateCounters(20)
+		}
+	}
+	b.Run("reset", func(b *testing.B) {
+		b.ReportAllocs()
+		var ca BatchCounterAlloc
+		for i := 0; i < b.N; i++ {
+			for p := 0; p < npods; p++ {
+				pod(&ca)
+				ca.Reset()
+			}
+		}
+	})
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for p := 0; p < npods; p++ {
+				var ca BatchCounterAlloc
+				pod(&ca)
+			}
+		}
+	})
+}
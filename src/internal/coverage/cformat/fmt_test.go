@@ -7,8 +7,11 @@ package cformat_test
 import (
 	"internal/coverage"
 	"internal/coverage/cformat"
+	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestBasics(t *testing.T) {
@@ -90,3 +93,172 @@ total		(statements)	62.5%`)
 		t.Logf("funcs is %s\n", b3.String())
 	}
 }
+
+func TestSnapshot(t *testing.T) {
+	fm := cformat.NewFormatter(coverage.CtrModeSet)
+	mku := func(stl, enl, nx uint32) coverage.CoverableUnit {
+		return coverage.CoverableUnit{StLine: stl, EnLine: enl, NxStmts: nx}
+	}
+	fm.SetPackage("my/pack2")
+	fm.AddUnit("r.go", "g", false, mku(5, 6, 4), 1)
+	fm.SetPackage("my/pack")
+	fm.AddUnit("p.go", "f", false, mku(10, 11, 2), 1)
+	fm.AddUnit("p.go", "f", false, mku(15, 16, 3), 0)
+	fm.AddUnit("p.go", "f.func1", true, mku(12, 13, 1), 0)
+
+	r := fm.Snapshot()
+	want := cformat.Report{
+		Mode: coverage.CtrModeSet,
+		Packages: []cformat.PackageReport{
+			{
+				ImportPath: "my/pack",
+				Funcs: []cformat.FuncReport{
+					{File: "p.go", Name: "f", Line: 10, Stmts: 5, CoveredStmts: 2},
+					{File: "p.go", Name: "f.func1", Literal: true, Line: 12, Stmts: 1},
+				},
+				Units: []cformat.UnitReport{
+					{CoverableUnit: mku(10, 11, 2), Func: 0, Count: 1},
+					{CoverableUnit: mku(12, 13, 1), Func: 1, Count: 0},
+					{CoverableUnit: mku(15, 16, 3), Func: 0, Count: 0},
+				},
+			},
+			{
+				ImportPath: "my/pack2",
+				Funcs: []cformat.FuncReport{
+					{File: "r.go", Name: "g", Line: 5, Stmts: 4, CoveredStmts: 4},
+				},
+				Units: []cformat.UnitReport{
+					{CoverableUnit: mku(5, 6, 4), Func: 0, Count: 1},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("Snapshot:\ngot  %+v\nwant %+v", r, want)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	type unitRec struct {
+		pkg, file, fname string
+		lit              bool
+		unit             coverage.CoverableUnit
+		count            uint32
+	}
+	mku := func(stl, enl, nx uint32) coverage.CoverableUnit {
+		return coverage.CoverableUnit{StLine: stl, EnLine: enl, NxStmts: nx}
+	}
+	recs := []unitRec{
+		{"my/b", "b.go", "init", false, mku(3, 4, 1), 1},
+		{"my/b", "c.go", "init", false, mku(3, 4, 1), 0},
+		{"my/a", "a.go", "f", false, mku(10, 12, 2), 3},
+		{"my/a", "a.go", "f", false, mku(13, 14, 1), 0},
+		{"my/a", "a.go", "f.func1", true, mku(11, 11, 1), 2},
+		{"my/a", "a.go", "g", false, mku(20, 21, 1), 1},
+		// Two functions with a unit at the same position (possible
+		// with generated code or line directives).
+		{"my/a", "gen.go", "h1", false, mku(1, 1, 1), 1},
+		{"my/a", "gen.go", "h2", false, mku(1, 1, 1), 0},
+	}
+
+	emit := func(order []int) string {
+		fm := cformat.NewFormatter(coverage.CtrModeCount)
+		for _, k := range order {
+			r := recs[k]
+			fm.SetPackage(r.pkg)
+			fm.AddUnit(r.file, r.fname, r.lit, r.unit, r.count)
+		}
+		var b strings.Builder
+		if err := fm.EmitTextual(&b); err != nil {
+			t.Fatalf("EmitTextual returned %v", err)
+		}
+		if err := fm.EmitFuncs(&b); err != nil {
+			t.Fatalf("EmitFuncs returned %v", err)
+		}
+		return b.String()
+	}
+
+	order := make([]int, len(recs))
+	for i := range order {
+		order[i] = i
+	}
+	want := emit(order)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		r.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		if got := emit(order); got != want {
+			t.Fatalf("insertion order %v: got:\n%s\nwant:\n%s\n", order, got, want)
+		}
+	}
+
+	wantText := strings.TrimSpace(`
+mode: count
+a.go:10.0,12.0 2 3
+a.go:11.0,11.0 1 2
+a.go:13.0,14.0 1 0
+a.go:20.0,21.0 1 1
+gen.go:1.0,1.0 1 1
+gen.go:1.0,1.0 1 0
+b.go:3.0,4.0 1 1
+c.go:3.0,4.0 1 0
+a.go:10:	f		100.0%
+a.go:13:	f		0.0%
+a.go:20:	g		100.0%
+gen.go:1:	h1		100.0%
+gen.go:1:	h2		0.0%
+b.go:3:		init		100.0%
+c.go:3:		init		0.0%
+total		(statements)	66.7%`)
+	if got := strings.TrimSpace(want); got != wantText {
+		t.Errorf("got:\n%s\nwant:\n%s\n", got, wantText)
+	}
+}
+
+func TestEmitHTML(t *testing.T) {
+	fm := cformat.NewFormatter(coverage.CtrModeSet)
+	fm.SetPackage("my/pack")
+	mku := func(stl, enl, nx uint32) coverage.CoverableUnit {
+		return coverage.CoverableUnit{StLine: stl, EnLine: enl, NxStmts: nx}
+	}
+	fm.AddUnit("my/pack/p.go", "f1", false, mku(3, 4, 2), 1)
+	fm.AddUnit("my/pack/p.go", "f1", false, mku(4, 5, 1), 0)
+	fm.AddUnit("my/pack/p.go", "f1", false, mku(6, 6, 1), 0)
+	fm.AddUnit("my/pack/q.go", "f2", false, mku(10, 12, 3), 1)
+
+	srcFS := fstest.MapFS{
+		"my/pack/p.go": {Data: []byte("package p\n\nfunc f1() {\n\tx := a < b\n}\n\tf()\n")},
+	}
+	var b strings.Builder
+	if err := fm.EmitHTML(&b, srcFS); err != nil {
+		t.Fatalf("EmitHTML returned %v", err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		"<h1>my/pack</h1>",
+		"<h2>my/pack/p.go: 50.0% of statements</h2>",
+		`<span class="ln">    1</span> package p` + "\n",
+		`<span class="ln">    3</span> <span class="cov">func f1() {</span>`,
+		`<span class="ln">    4</span> <span class="partial">` + "\tx := a &lt; b</span>",
+		`<span class="ln">    5</span> <span class="uncov">}</span>`,
+		`<span class="ln">    6</span> <span class="uncov">` + "\tf()</span>",
+		"<h2>my/pack/q.go: 100.0% of statements</h2>\n<p class=\"nosrc\">source not available</p>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("EmitHTML output missing %q; got:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "p.go:") > strings.Index(got, "q.go:") {
+		t.Errorf("EmitHTML files out of order:\n%s", got)
+	}
+
+	// With no source files at all, every file gets a summary.
+	b.Reset()
+	if err := fm.EmitHTML(&b, nil); err != nil {
+		t.Fatalf("EmitHTML(nil) returned %v", err)
+	}
+	if n := strings.Count(b.String(), "source not available"); n != 2 {
+		t.Errorf("EmitHTML(nil): got %d files without source, want 2:\n%s", n, b.String())
+	}
+}
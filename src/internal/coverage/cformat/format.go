@@ -28,7 +28,8 @@ package cformat
 //
 // These apis are linked into tests that are built with "-cover", and
 // called at the end of test execution to produce text output or
-// emit coverage percentages.
+// emit coverage percentages. Callers that need some other output
+// format can obtain the accumulated data with Snapshot.
 
 import (
 	"fmt"
@@ -133,8 +134,11 @@ func (fm *Formatter) AddUnit(file string, fname string, isfnlit bool, unit cover
 
 // sortUnits sorts a slice of extcu objects in a package according to
 // source position information (e.g. file and line). Note that we don't
-// include function name as part of the sorting criteria, the thinking
+// use function name as a primary sorting criterion, the thinking
 // being that is better to provide things in the original source order.
+// Function name and literal flag are used only to break ties between
+// units with identical positions, so that the resulting order never
+// depends on the order in which units were added to the formatter.
 func (p *pstate) sortUnits(units []extcu) {
 	sort.Slice(units, func(i, j int) bool {
 		ui := units[i]
@@ -144,8 +148,6 @@ func (p *pstate) sortUnits(units []extcu) {
 		if ifile != jfile {
 			return ifile < jfile
 		}
-		// NB: not taking function literal flag into account here (no
-		// need, since other fields are guaranteed to be distinct).
 		if units[i].StLine != units[j].StLine {
 			return units[i].StLine < units[j].StLine
 		}
@@ -158,7 +160,15 @@ func (p *pstate) sortUnits(units []extcu) {
 		if units[i].EnCol != units[j].EnCol {
 			return units[i].EnCol < units[j].EnCol
 		}
-		return units[i].NxStmts < units[j].NxStmts
+		if units[i].NxStmts != units[j].NxStmts {
+			return units[i].NxStmts < units[j].NxStmts
+		}
+		ifn := p.funcs[ui.fnfid]
+		jfn := p.funcs[uj.fnfid]
+		if ifn.fname != jfn.fname {
+			return ifn.fname < jfn.fname
+		}
+		return !ifn.lit && jfn.lit
 	})
 }
 
@@ -166,32 +176,23 @@ func (p *pstate) sortUnits(units []extcu) {
 // cmd/cover text format to the writer 'w'. We sort the data items by
 // importpath, source file, and line number before emitting (this sorting
 // is not explicitly mandated by the format, but seems like a good idea
-// for repeatable/deterministic dumps).
+// for repeatable/deterministic dumps). The output depends only on the
+// data accumulated, not on the order of the SetPackage/AddUnit calls,
+// so two runs over the same data produce byte-identical output.
 func (fm *Formatter) EmitTextual(w io.Writer) error {
 	if fm.cm == coverage.CtrModeInvalid {
 		panic("internal error, counter mode unset")
 	}
-	if _, err := fmt.Fprintf(w, "mode: %s\n", fm.cm.String()); err != nil {
+	r := fm.Snapshot()
+	if _, err := fmt.Fprintf(w, "mode: %s\n", r.Mode.String()); err != nil {
 		return err
 	}
-	pkgs := make([]string, 0, len(fm.pm))
-	for importpath := range fm.pm {
-		pkgs = append(pkgs, importpath)
-	}
-	sort.Strings(pkgs)
-	for _, importpath := range pkgs {
-		p := fm.pm[importpath]
-		units := make([]extcu, 0, len(p.unitTable))
-		for u := range p.unitTable {
-			units = append(units, u)
-		}
-		p.sortUnits(units)
-		for _, u := range units {
-			count := p.unitTable[u]
-			file := p.funcs[u.fnfid].file
+	for _, p := range r.Packages {
+		for _, u := range p.Units {
+			file := p.Funcs[u.Func].File
 			if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n",
 				file, u.StLine, u.StCol,
-				u.EnLine, u.EnCol, u.NxStmts, count); err != nil {
+				u.EnLine, u.EnCol, u.NxStmts, u.Count); err != nil {
 				return err
 			}
 		}
@@ -315,7 +316,7 @@ func (fm *Formatter) EmitFuncs(w io.Writer) error {
 			if k == 0 {
 				captureFuncStart(u)
 			} else {
-				if fname != p.funcs[u.fnfid].fname {
+				if fname != p.funcs[u.fnfid].fname || ffile != p.funcs[u.fnfid].file {
 					// New function; emit entry for previous one.
 					if err := emitFunc(u); err != nil {
 						return err
@@ -0,0 +1,165 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cformat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"internal/coverage"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// EmitHTML writes the accumulated coverage data to 'w' as an HTML
+// page with a section for each package (sorted by import path), in
+// which each source file is listed with its coverage percentage and
+// its source text, with lines covered by executed units, by
+// unexecuted units, or by both highlighted differently. Source files
+// are read from 'srcFS', looked up by the file names recorded in the
+// coverage data with any leading '/' removed. A file that can't be
+// found in 'srcFS' (or all files, if 'srcFS' is nil) is listed with
+// its coverage percentage only.
+func (fm *Formatter) EmitHTML(w io.Writer, srcFS fs.FS) error {
+	if fm.cm == coverage.CtrModeInvalid {
+		panic("internal error, counter mode unset")
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(htmlHeader)
+	pkgs := make([]string, 0, len(fm.pm))
+	for importpath := range fm.pm {
+		pkgs = append(pkgs, importpath)
+	}
+	sort.Strings(pkgs)
+	for _, importpath := range pkgs {
+		p := fm.pm[importpath]
+		fmt.Fprintf(bw, "<h1>%s</h1>\n", html.EscapeString(importpath))
+
+		// Group the package's units by source file.
+		fileUnits := make(map[string][]extcu)
+		for u := range p.unitTable {
+			file := p.funcs[u.fnfid].file
+			fileUnits[file] = append(fileUnits[file], u)
+		}
+		files := make([]string, 0, len(fileUnits))
+		for file := range fileUnits {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			units := fileUnits[file]
+			p.sortUnits(units)
+			if err := p.emitHTMLFile(bw, srcFS, file, units); err != nil {
+				return err
+			}
+		}
+	}
+	bw.WriteString(htmlFooter)
+	return bw.Flush()
+}
+
+// emitHTMLFile writes the section of an HTML report for source file
+// 'file', whose coverable units are 'units'.
+func (p *pstate) emitHTMLFile(w *bufio.Writer, srcFS fs.FS, file string, units []extcu) error {
+	var totalStmts, coveredStmts uint64
+	for _, u := range units {
+		totalStmts += uint64(u.NxStmts)
+		if p.unitTable[u] != 0 {
+			coveredStmts += uint64(u.NxStmts)
+		}
+	}
+	fmt.Fprintf(w, "<h2>%s: ", html.EscapeString(file))
+	if totalStmts == 0 {
+		w.WriteString("[no statements]</h2>\n")
+	} else {
+		fmt.Fprintf(w, "%.1f%% of statements</h2>\n", 100*float64(coveredStmts)/float64(totalStmts))
+	}
+
+	src, err := readSource(srcFS, file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteString("<p class=\"nosrc\">source not available</p>\n")
+			return nil
+		}
+		return err
+	}
+
+	// Record, for each line, whether it is spanned by any executed
+	// and by any unexecuted units.
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	if n := len(lines); n > 0 && len(lines[n-1]) == 0 {
+		lines = lines[:n-1]
+	}
+	executed := make([]bool, len(lines)+1)
+	unexecuted := make([]bool, len(lines)+1)
+	for _, u := range units {
+		mark := unexecuted
+		if p.unitTable[u] != 0 {
+			mark = executed
+		}
+		for l := u.StLine; l <= u.EnLine && int(l) < len(mark); l++ {
+			mark[l] = true
+		}
+	}
+
+	w.WriteString("<pre>")
+	for i, line := range lines {
+		l := i + 1
+		class := ""
+		switch {
+		case executed[l] && unexecuted[l]:
+			class = "partial"
+		case executed[l]:
+			class = "cov"
+		case unexecuted[l]:
+			class = "uncov"
+		}
+		text := html.EscapeString(strings.TrimRight(string(line), "\r\n"))
+		if class == "" {
+			fmt.Fprintf(w, "<span class=\"ln\">%5d</span> %s\n", l, text)
+		} else {
+			fmt.Fprintf(w, "<span class=\"ln\">%5d</span> <span class=\"%s\">%s</span>\n", l, class, text)
+		}
+	}
+	w.WriteString("</pre>\n")
+	return nil
+}
+
+// readSource reads source file 'file' from 'srcFS'. It returns an
+// error satisfying errors.Is(err, fs.ErrNotExist) if the file isn't
+// available.
+func readSource(srcFS fs.FS, file string) ([]byte, error) {
+	name := strings.TrimPrefix(file, "/")
+	if srcFS == nil || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: file, Err: fs.ErrNotExist}
+	}
+	return fs.ReadFile(srcFS, name)
+}
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage report</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; }
+.ln { color: rgb(128, 128, 128); }
+.cov { color: rgb(20, 150, 50); }
+.uncov { color: rgb(192, 0, 0); }
+.partial { color: rgb(200, 130, 0); }
+.nosrc { font-style: italic; }
+</style>
+</head>
+<body>
+`
+
+const htmlFooter = `</body>
+</html>
+`
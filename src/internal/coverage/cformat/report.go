@@ -0,0 +1,110 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cformat
+
+import (
+	"internal/coverage"
+	"sort"
+)
+
+// Report is a snapshot of the coverage data accumulated by a
+// Formatter, for use by code that writes coverage data in formats
+// the Formatter doesn't support itself. Like the output of the Emit
+// methods, a Report depends only on the data accumulated, not on the
+// order in which it was added.
+type Report struct {
+	// Mode is the counter mode of the data.
+	Mode coverage.CounterMode
+
+	// Packages holds the data for each package, sorted by import
+	// path.
+	Packages []PackageReport
+}
+
+// PackageReport holds the coverage data for a single package.
+type PackageReport struct {
+	ImportPath string
+
+	// Funcs lists the functions (including function literals) of the
+	// package, in order of their first unit in Units.
+	Funcs []FuncReport
+
+	// Units lists the coverable units of the package, sorted by
+	// source file and position.
+	Units []UnitReport
+}
+
+// FuncReport summarizes the coverage of a single function.
+type FuncReport struct {
+	File    string
+	Name    string
+	Literal bool // function literal
+
+	// Line is the starting line of the function's first unit.
+	Line uint32
+
+	// Stmts is the number of statements in the function, and
+	// CoveredStmts the number of those in units that were executed.
+	Stmts, CoveredStmts uint64
+}
+
+// UnitReport gives the counter value of a single coverable unit.
+type UnitReport struct {
+	coverage.CoverableUnit
+
+	// Func is the index in PackageReport.Funcs of the function
+	// containing the unit.
+	Func int
+
+	Count uint32
+}
+
+// Snapshot returns the coverage data accumulated so far. The result
+// does not share memory with the Formatter.
+func (fm *Formatter) Snapshot() Report {
+	r := Report{Mode: fm.cm, Packages: make([]PackageReport, 0, len(fm.pm))}
+	pkgs := make([]string, 0, len(fm.pm))
+	for importpath := range fm.pm {
+		pkgs = append(pkgs, importpath)
+	}
+	sort.Strings(pkgs)
+	for _, importpath := range pkgs {
+		p := fm.pm[importpath]
+		units := make([]extcu, 0, len(p.unitTable))
+		for u := range p.unitTable {
+			units = append(units, u)
+		}
+		p.sortUnits(units)
+		pr := PackageReport{
+			ImportPath: importpath,
+			Units:      make([]UnitReport, 0, len(units)),
+		}
+		// Maps p.funcs index to pr.Funcs index.
+		fidx := make(map[uint32]int)
+		for _, u := range units {
+			k, ok := fidx[u.fnfid]
+			if !ok {
+				fn := p.funcs[u.fnfid]
+				k = len(pr.Funcs)
+				fidx[u.fnfid] = k
+				pr.Funcs = append(pr.Funcs, FuncReport{
+					File:    fn.file,
+					Name:    fn.fname,
+					Literal: fn.lit,
+					Line:    u.StLine,
+				})
+			}
+			count := p.unitTable[u]
+			f := &pr.Funcs[k]
+			f.Stmts += uint64(u.NxStmts)
+			if count != 0 {
+				f.CoveredStmts += uint64(u.NxStmts)
+			}
+			pr.Units = append(pr.Units, UnitReport{CoverableUnit: u.CoverableUnit, Func: k, Count: count})
+		}
+		r.Packages = append(r.Packages, pr)
+	}
+	return r
+}
@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"internal/coverage"
 	"math"
+	"runtime"
+	"sync"
 )
 
 // Merger provides state and methods to help manage the process of
@@ -21,6 +23,7 @@ type Merger struct {
 	cmode    coverage.CounterMode
 	cgran    coverage.CounterGranularity
 	overflow bool
+	par      int // see SetParallelism
 }
 
 // MergeCounters takes the counter values in 'src' and merges them
@@ -45,6 +48,130 @@ func (m *Merger) MergeCounters(dst, src []uint32) (error, bool) {
 	return nil, ovf
 }
 
+// MismatchError is the error returned by Merger.MergeFuncCounters
+// when the counters of a function can't be merged with those already
+// merged for it because their number differs. Counter data files for
+// the same meta-data file (that is, in the same pod) always agree on
+// the number of counters of each function, so a mismatch indicates a
+// corrupt counter data file, or counter data files grouped with the
+// wrong meta-data file. MergeFuncCounters doesn't know which files
+// the counters came from; callers that do can fill in MetaFile and
+// CounterFile before reporting the error.
+type MismatchError struct {
+	PkgIdx, FuncIdx uint32
+	DstLen, SrcLen  int    // number of counters merged so far, and in the new data
+	MetaFile        string // meta-data file of the pod, if known
+	CounterFile     string // counter data file holding the new data, if known
+}
+
+func (e *MismatchError) Error() string {
+	where := ""
+	if e.CounterFile != "" {
+		where = " in counter data file " + e.CounterFile
+	}
+	if e.MetaFile != "" {
+		where += " (meta-data file " + e.MetaFile + ")"
+	}
+	return fmt.Sprintf("merging counters: function %d of package %d has %d counters%s, but previously merged data has %d", e.FuncIdx, e.PkgIdx, e.SrcLen, where, e.DstLen)
+}
+
+// MergeFuncCounters is similar to MergeCounters, but merges the
+// counters of function 'funcIdx' in package 'pkgIdx', and reports
+// counter slices of different lengths with a *MismatchError
+// identifying the function.
+func (m *Merger) MergeFuncCounters(pkgIdx, funcIdx uint32, dst, src []uint32) (error, bool) {
+	if len(src) != len(dst) {
+		return &MismatchError{PkgIdx: pkgIdx, FuncIdx: funcIdx, DstLen: len(dst), SrcLen: len(src)}, false
+	}
+	return m.MergeCounters(dst, src)
+}
+
+// SetParallelism sets the number of goroutines among which
+// MergeFuncTable divides its work. A value of 0 or 1 (the default)
+// merges on the calling goroutine, and a negative value uses
+// runtime.GOMAXPROCS(0) goroutines. Small tables are always merged on
+// the calling goroutine.
+func (m *Merger) SetParallelism(n int) {
+	m.par = n
+}
+
+// minCountersPerWorker is the smallest number of counters worth
+// handing to a goroutine of its own in MergeFuncTable.
+const minCountersPerWorker = 1 << 14
+
+// MergeFuncTable merges the counters of each function of package
+// 'pkgIdx' in 'src' into the same function in 'dst', where element i
+// of each holds the counters of function i. As with
+// MergeFuncCounters, a function whose counter slices differ in length
+// yields a *MismatchError; all lengths are checked before anything is
+// merged, so 'dst' is unchanged on error. Functions are merged
+// independently, so when the work is divided among goroutines (see
+// SetParallelism) the result is identical to a sequential merge.
+func (m *Merger) MergeFuncTable(pkgIdx uint32, dst, src [][]uint32) (error, bool) {
+	if len(src) != len(dst) {
+		return fmt.Errorf("merging function tables: len(dst)=%d len(src)=%d", len(dst), len(src)), false
+	}
+	total := 0
+	for i := range src {
+		if len(src[i]) != len(dst[i]) {
+			return &MismatchError{PkgIdx: pkgIdx, FuncIdx: uint32(i), DstLen: len(dst[i]), SrcLen: len(src[i])}, false
+		}
+		total += len(src[i])
+	}
+	n := m.par
+	if n < 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if max := total / minCountersPerWorker; n > max {
+		n = max
+	}
+	ovf := m.overflow
+	m.overflow = false
+	if n <= 1 {
+		return nil, m.mergeFuncRange(dst, src) || ovf
+	}
+	ovfs := make([]bool, n)
+	var wg sync.WaitGroup
+	for k := 0; k < n; k++ {
+		lo, hi := k*len(src)/n, (k+1)*len(src)/n
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			ovfs[k] = m.mergeFuncRange(dst[lo:hi], src[lo:hi])
+		}(k)
+	}
+	wg.Wait()
+	for _, o := range ovfs {
+		ovf = ovf || o
+	}
+	return nil, ovf
+}
+
+// mergeFuncRange merges the counters of each function in 'src' into
+// 'dst', whose lengths have been checked, reporting whether any
+// counter overflowed. It doesn't modify 'm', so it may be called
+// from several goroutines at once.
+func (m *Merger) mergeFuncRange(dst, src [][]uint32) bool {
+	ovf := false
+	for i, s := range src {
+		d := dst[i]
+		if m.cmode == coverage.CtrModeSet {
+			for j := range s {
+				if s[j] != 0 {
+					d[j] = 1
+				}
+			}
+			continue
+		}
+		for j := range s {
+			var o bool
+			d[j], o = SaturatingAdd(d[j], s[j])
+			ovf = ovf || o
+		}
+	}
+	return ovf
+}
+
 // Saturating add does a saturating addition of 'dst' and 'src',
 // returning added value or math.MaxUint32 if there is an overflow.
 // Overflows are recorded in case the client needs to track them.
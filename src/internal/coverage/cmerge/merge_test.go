@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"internal/coverage"
 	"internal/coverage/cmerge"
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -103,3 +105,131 @@ func TestBasic(t *testing.T) {
 		}
 	}
 }
+
+func TestMergeFuncCountersMismatch(t *testing.T) {
+	m := &cmerge.Merger{}
+	if err := m.SetModeAndGranularity("mdf1.data", coverage.CtrModeCount, coverage.CtrGranularityPerBlock); err != nil {
+		t.Fatalf("unexpected clash: %v", err)
+	}
+	dst := []uint32{1, 2}
+	if err, _ := m.MergeFuncCounters(3, 4, dst, []uint32{1, 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst[0] != 2 || dst[1] != 3 {
+		t.Errorf("merged counters = %v, want [2 3]", dst)
+	}
+
+	err, _ := m.MergeFuncCounters(3, 4, dst, []uint32{1, 1, 1})
+	me, ok := err.(*cmerge.MismatchError)
+	if !ok {
+		t.Fatalf("got error %v, want *MismatchError", err)
+	}
+	want := cmerge.MismatchError{PkgIdx: 3, FuncIdx: 4, DstLen: 2, SrcLen: 3}
+	if *me != want {
+		t.Errorf("got %+v, want %+v", *me, want)
+	}
+	me.MetaFile, me.CounterFile = "covmeta.x", "covcounters.x.1.2"
+	wantMsg := "merging counters: function 4 of package 3 has 3 counters in counter data file covcounters.x.1.2 (meta-data file covmeta.x), but previously merged data has 2"
+	if got := me.Error(); got != wantMsg {
+		t.Errorf("Error() = %q, want %q", got, wantMsg)
+	}
+	if dst[0] != 2 || dst[1] != 3 {
+		t.Errorf("counters changed by failed merge: %v", dst)
+	}
+}
+
+// mkFuncTable returns a synthetic table of 'nfuncs' functions with
+// varying numbers of counters, filled from 'seed'. Some counters are
+// large enough that adding two tables overflows them.
+func mkFuncTable(nfuncs int, seed uint32) [][]uint32 {
+	tab := make([][]uint32, nfuncs)
+	x := seed
+	for i := range tab {
+		tab[i] = make([]uint32, 1+i%50)
+		for j := range tab[i] {
+			x = x*1664525 + 1013904223
+			switch x % 4 {
+			case 0:
+				tab[i][j] = 0
+			case 1:
+				tab[i][j] = math.MaxUint32 - x%8
+			default:
+				tab[i][j] = x % 1000
+			}
+		}
+	}
+	return tab
+}
+
+func TestMergeFuncTable(t *testing.T) {
+	for _, mode := range []coverage.CounterMode{coverage.CtrModeCount, coverage.CtrModeSet} {
+		src := mkFuncTable(5000, 1)
+		var want [][]uint32
+		var wantOvf bool
+		for _, par := range []int{0, 1, 4, -1} {
+			m := &cmerge.Merger{}
+			if err := m.SetModeAndGranularity("mdf1.data", mode, coverage.CtrGranularityPerBlock); err != nil {
+				t.Fatal(err)
+			}
+			m.SetParallelism(par)
+			dst := mkFuncTable(5000, 2)
+			err, ovf := m.MergeFuncTable(0, dst, src)
+			if err != nil {
+				t.Fatalf("mode %s, parallelism %d: %v", mode, par, err)
+			}
+			if want == nil {
+				want, wantOvf = dst, ovf
+				if mode == coverage.CtrModeCount && !ovf {
+					t.Errorf("mode %s: expected overflow", mode)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(dst, want) || ovf != wantOvf {
+				t.Errorf("mode %s, parallelism %d: result differs from sequential merge (overflow %v, want %v)", mode, par, ovf, wantOvf)
+			}
+		}
+	}
+}
+
+func TestMergeFuncTableMismatch(t *testing.T) {
+	m := &cmerge.Merger{}
+	if err := m.SetModeAndGranularity("mdf1.data", coverage.CtrModeCount, coverage.CtrGranularityPerBlock); err != nil {
+		t.Fatal(err)
+	}
+	m.SetParallelism(4)
+	dst := [][]uint32{{1, 2}, {3}, {4, 5}}
+	src := [][]uint32{{1, 1}, {1}, {1}}
+	err, _ := m.MergeFuncTable(7, dst, src)
+	me, ok := err.(*cmerge.MismatchError)
+	if !ok {
+		t.Fatalf("got error %v, want *MismatchError", err)
+	}
+	if want := (cmerge.MismatchError{PkgIdx: 7, FuncIdx: 2, DstLen: 2, SrcLen: 1}); *me != want {
+		t.Errorf("got %+v, want %+v", *me, want)
+	}
+	if fmt.Sprint(dst) != "[[1 2] [3] [4 5]]" {
+		t.Errorf("counters changed by failed merge: %v", dst)
+	}
+	if err, _ := m.MergeFuncTable(7, dst, src[:2]); err == nil {
+		t.Errorf("expected error merging tables of different lengths")
+	}
+}
+
+func BenchmarkMergeFuncTable(b *testing.B) {
+	src := mkFuncTable(100000, 1)
+	dst := mkFuncTable(100000, 2)
+	for _, par := range []int{1, 2, 4, -1} {
+		b.Run(fmt.Sprintf("par=%d", par), func(b *testing.B) {
+			m := &cmerge.Merger{}
+			if err := m.SetModeAndGranularity("mdf1.data", coverage.CtrModeCount, coverage.CtrGranularityPerBlock); err != nil {
+				b.Fatal(err)
+			}
+			m.SetParallelism(par)
+			for i := 0; i < b.N; i++ {
+				if err, _ := m.MergeFuncTable(0, dst, src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
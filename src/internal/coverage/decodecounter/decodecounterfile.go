@@ -5,9 +5,11 @@
 package decodecounter
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"internal/coverage"
+	"internal/coverage/cmerge"
 	"internal/coverage/slicereader"
 	"internal/coverage/stringtab"
 	"io"
@@ -20,6 +22,7 @@ import (
 // during the executions of a coverage-instrumented binary.
 
 type CounterDataReader struct {
+	fname    string
 	stab     *stringtab.Reader
 	args     map[string]string
 	osargs   []string
@@ -39,13 +42,14 @@ type CounterDataReader struct {
 
 func NewCounterDataReader(fn string, rs io.ReadSeeker) (*CounterDataReader, error) {
 	cdr := &CounterDataReader{
-		mr:   rs,
-		u32b: make([]byte, 4),
-		u8b:  make([]byte, 1),
+		fname: fn,
+		mr:    rs,
+		u32b:  make([]byte, 4),
+		u8b:   make([]byte, 1),
 	}
 	// Read header
 	if err := binary.Read(rs, binary.LittleEndian, &cdr.hdr); err != nil {
-		return nil, err
+		return nil, cdr.truncated(err)
 	}
 	if cdr.debug {
 		fmt.Fprintf(os.Stderr, "=-= counter file header: %+v\n", cdr.hdr)
@@ -90,19 +94,35 @@ func checkMagic(v [4]byte) bool {
 }
 
 func (cdr *CounterDataReader) readFooter() error {
+	// The header has already been read and has a valid magic string,
+	// so a file that is too short to hold a footer, that doesn't end
+	// with a footer, or whose footer claims more segments than the
+	// remaining bytes can hold, was cut off while being written.
+	hdrSize := int64(unsafe.Sizeof(cdr.hdr))
 	ftrSize := int64(unsafe.Sizeof(cdr.ftr))
+	shdrSize := int64(unsafe.Sizeof(cdr.shdr))
+	size, err := cdr.mr.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size < hdrSize+ftrSize {
+		return &TruncatedFileError{File: cdr.fname, Offset: size}
+	}
 	if _, err := cdr.mr.Seek(-ftrSize, os.SEEK_END); err != nil {
 		return err
 	}
 	if err := binary.Read(cdr.mr, binary.LittleEndian, &cdr.ftr); err != nil {
-		return err
+		return cdr.truncated(err)
 	}
 	if !checkMagic(cdr.ftr.Magic) {
-		return fmt.Errorf("invalid magic string (not a counter data file)")
+		return &TruncatedFileError{File: cdr.fname, Offset: size}
 	}
 	if cdr.ftr.NumSegments == 0 {
 		return fmt.Errorf("invalid counter data file (no segments)")
 	}
+	if int64(cdr.ftr.NumSegments)*shdrSize > size-hdrSize-ftrSize {
+		return &TruncatedFileError{File: cdr.fname, Offset: size}
+	}
 	return nil
 }
 
@@ -111,7 +131,7 @@ func (cdr *CounterDataReader) readFooter() error {
 func (cdr *CounterDataReader) readSegmentPreamble() error {
 	// Read segment header.
 	if err := binary.Read(cdr.mr, binary.LittleEndian, &cdr.shdr); err != nil {
-		return err
+		return cdr.truncated(err)
 	}
 	if cdr.debug {
 		fmt.Fprintf(os.Stderr, "=-= read counter segment header: %+v", cdr.shdr)
@@ -145,10 +165,10 @@ func (cdr *CounterDataReader) readStringTable() error {
 	b := make([]byte, cdr.shdr.StrTabLen)
 	nr, err := cdr.mr.Read(b)
 	if err != nil {
-		return err
+		return cdr.truncated(err)
 	}
 	if nr != int(cdr.shdr.StrTabLen) {
-		return fmt.Errorf("error: short read on string table")
+		return cdr.truncated(io.ErrUnexpectedEOF)
 	}
 	slr := slicereader.NewReader(b, false /* not readonly */)
 	cdr.stab = stringtab.NewReader(slr)
@@ -160,10 +180,10 @@ func (cdr *CounterDataReader) readArgs() error {
 	b := make([]byte, cdr.shdr.ArgsLen)
 	nr, err := cdr.mr.Read(b)
 	if err != nil {
-		return err
+		return cdr.truncated(err)
 	}
 	if nr != int(cdr.shdr.ArgsLen) {
-		return fmt.Errorf("error: short read on args table")
+		return cdr.truncated(io.ErrUnexpectedEOF)
 	}
 	slr := slicereader.NewReader(b, false /* not readonly */)
 	sget := func() (string, error) {
@@ -235,6 +255,32 @@ func (cdr *CounterDataReader) Goarch() string {
 	return cdr.goarch
 }
 
+// TruncatedFileError is the error returned when a counter data file
+// ends before all of the data it should contain has been read, as can
+// happen if a program is killed while writing out counter data.
+type TruncatedFileError struct {
+	File   string // name of the counter data file
+	Offset int64  // offset at which truncation was detected
+}
+
+func (e *TruncatedFileError) Error() string {
+	return fmt.Sprintf("counter data file %s is truncated (detected at offset %d)", e.File, e.Offset)
+}
+
+// truncated converts an EOF error encountered while reading into a
+// TruncatedFileError for the current read offset; other errors are
+// returned unchanged.
+func (cdr *CounterDataReader) truncated(err error) error {
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	off, serr := cdr.mr.Seek(0, io.SeekCurrent)
+	if serr != nil {
+		return err
+	}
+	return &TruncatedFileError{File: cdr.fname, Offset: off}
+}
+
 // FuncPayload encapsulates the counter data payload for a single
 // function as read from a counter data file.
 type FuncPayload struct {
@@ -346,7 +392,7 @@ func (cdr *CounterDataReader) NextFunc(p *FuncPayload) (bool, error) {
 		for {
 			nc, err = rdu32()
 			if err == io.EOF {
-				return false, io.EOF
+				return false, cdr.truncated(err)
 			} else if err != nil {
 				break
 			}
@@ -358,17 +404,17 @@ func (cdr *CounterDataReader) NextFunc(p *FuncPayload) (bool, error) {
 		nc, err = rdu32()
 	}
 	if err != nil {
-		return false, err
+		return false, cdr.truncated(err)
 	}
 
 	// Read package and func indices.
 	p.PkgIdx, err = rdu32()
 	if err != nil {
-		return false, err
+		return false, cdr.truncated(err)
 	}
 	p.FuncIdx, err = rdu32()
 	if err != nil {
-		return false, err
+		return false, cdr.truncated(err)
 	}
 	if cap(p.Counters) < 1024 {
 		p.Counters = make([]uint32, 0, 1024)
@@ -377,9 +423,90 @@ func (cdr *CounterDataReader) NextFunc(p *FuncPayload) (bool, error) {
 	for i := uint32(0); i < nc; i++ {
 		v, err := rdu32()
 		if err != nil {
-			return false, err
+			return false, cdr.truncated(err)
 		}
 		p.Counters = append(p.Counters, v)
 	}
 	return true, nil
 }
+
+// VisitFuncs reads the functions in the currently selected segment
+// and in all segments following it, invoking "visit" on each one.
+// Iteration stops early if "visit" returns FALSE. The counters slice
+// passed to "visit" is reused from call to call, so a visitor that
+// wants to hang on to counter values must make a copy.
+func (cdr *CounterDataReader) VisitFuncs(visit func(pkgIdx, funcIdx uint32, counters []uint32) bool) error {
+	var fp FuncPayload
+	for {
+		for {
+			ok, err := cdr.NextFunc(&fp)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if !visit(fp.PkgIdx, fp.FuncIdx, fp.Counters) {
+				return nil
+			}
+		}
+		// Note: segCount counts segments advanced past, so the
+		// final segment is current once it reaches NumSegments-1.
+		if cdr.segCount+1 >= cdr.ftr.NumSegments {
+			return nil
+		}
+		if ok, err := cdr.BeginNextSegment(); err != nil {
+			return err
+		} else if !ok {
+			return nil
+		}
+	}
+}
+
+// FuncKey identifies a function by its package index and function
+// index within the meta-data file of a pod.
+type FuncKey struct {
+	PkgIdx, FuncIdx uint32
+}
+
+// AddFuncCounts reads the functions in the currently selected segment
+// and in all segments following it, adding the sum of each function's
+// counter values to its entry in "sums". Sums saturate at
+// math.MaxUint32 (see cmerge.SaturatingAdd).
+func (cdr *CounterDataReader) AddFuncCounts(sums map[FuncKey]uint32) error {
+	return cdr.VisitFuncs(func(pkgIdx, funcIdx uint32, counters []uint32) bool {
+		k := FuncKey{PkgIdx: pkgIdx, FuncIdx: funcIdx}
+		sum := sums[k]
+		for _, c := range counters {
+			sum, _ = cmerge.SaturatingAdd(sum, c)
+		}
+		sums[k] = sum
+		return true
+	})
+}
+
+// SumFuncCounts reads the counter data files "files", which should
+// all belong to the same pod, and returns the total execution count
+// of each function: the sum of its counter values over all segments
+// of all of the files, as computed by AddFuncCounts. Files are read
+// one at a time. Functions that never executed (which counter data
+// files omit) have no entry in the result. In "set" mode, where each
+// counter is 0 or 1, the total is the number of blocks executed,
+// summed over files, rather than an execution count.
+func SumFuncCounts(files []string) (map[FuncKey]uint32, error) {
+	sums := make(map[FuncKey]uint32)
+	for _, fn := range files {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		cdr, err := NewCounterDataReader(fn, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("reading counter data file %s: %w", fn, err)
+		}
+		if err := cdr.AddFuncCounts(sums); err != nil {
+			return nil, fmt.Errorf("reading counter data file %s: %w", fn, err)
+		}
+	}
+	return sums, nil
+}
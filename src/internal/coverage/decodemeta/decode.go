@@ -20,6 +20,7 @@ import (
 // See comments in the encodecovmeta package for details on the format.
 
 type CoverageMetaDataDecoder struct {
+	b      []byte
 	r      *slicereader.Reader
 	hdr    coverage.MetaSymbolHeader
 	strtab *stringtab.Reader
@@ -30,15 +31,13 @@ type CoverageMetaDataDecoder struct {
 func NewCoverageMetaDataDecoder(b []byte, readonly bool) (*CoverageMetaDataDecoder, error) {
 	slr := slicereader.NewReader(b, readonly)
 	x := &CoverageMetaDataDecoder{
+		b:   b,
 		r:   slr,
 		tmp: make([]byte, 0, 256),
 	}
 	if err := x.readHeader(); err != nil {
 		return nil, err
 	}
-	if err := x.readStringTable(); err != nil {
-		return nil, err
-	}
 	return x, nil
 }
 
@@ -52,60 +51,70 @@ func (d *CoverageMetaDataDecoder) readHeader() error {
 	return nil
 }
 
-func (d *CoverageMetaDataDecoder) readStringTable() error {
+// stringTable returns the package's string table, reading it on first
+// use. The table is not checked; see Validate.
+func (d *CoverageMetaDataDecoder) stringTable() *stringtab.Reader {
+	if d.strtab != nil {
+		return d.strtab
+	}
 	// Seek to the correct location to read the string table.
-	stringTableLocation := int64(coverage.CovMetaHeaderSize + 4*d.hdr.NumFuncs)
+	stringTableLocation := coverage.CovMetaHeaderSize + 4*int64(d.hdr.NumFuncs)
 	d.r.SeekTo(stringTableLocation)
 
 	// Read the table itself.
 	d.strtab = stringtab.NewReader(d.r)
 	d.strtab.Read()
-	return nil
+	return d.strtab
 }
 
 func (d *CoverageMetaDataDecoder) PackagePath() string {
-	return d.strtab.Get(d.hdr.PkgPath)
+	return d.stringTable().Get(d.hdr.PkgPath)
 }
 
 func (d *CoverageMetaDataDecoder) PackageName() string {
-	return d.strtab.Get(d.hdr.PkgName)
+	return d.stringTable().Get(d.hdr.PkgName)
 }
 
 func (d *CoverageMetaDataDecoder) ModulePath() string {
-	return d.strtab.Get(d.hdr.ModulePath)
+	return d.stringTable().Get(d.hdr.ModulePath)
 }
 
 func (d *CoverageMetaDataDecoder) NumFuncs() uint32 {
 	return d.hdr.NumFuncs
 }
 
-// ReadFunc reads the coverage meta-data for the function with index
-// 'findex', filling it into the FuncDesc pointed to by 'f'.
-func (d *CoverageMetaDataDecoder) ReadFunc(fidx uint32, f *coverage.FuncDesc) error {
-	if fidx >= d.hdr.NumFuncs {
-		return fmt.Errorf("illegal function index")
-	}
-
-	// Seek to the correct location to read the function offset and read it.
-	funcOffsetLocation := int64(coverage.CovMetaHeaderSize + 4*fidx)
-	d.r.SeekTo(funcOffsetLocation)
-	foff := d.r.ReadUint32()
+// NumStrings returns the number of entries in the package's string
+// table.
+func (d *CoverageMetaDataDecoder) NumStrings() int {
+	return d.stringTable().Entries()
+}
 
-	// Check assumptions
-	if foff < uint32(funcOffsetLocation) || foff > d.hdr.Length {
-		return fmt.Errorf("malformed func offset %d", foff)
+// StringByIndex returns entry 'i' in the package's string table
+// (which holds the package path and name, module path, function
+// names and source file names), and a flag indicating whether 'i'
+// is a valid index.
+func (d *CoverageMetaDataDecoder) StringByIndex(i uint32) (string, bool) {
+	strtab := d.stringTable()
+	if int64(i) >= int64(strtab.Entries()) {
+		return "", false
 	}
+	return strtab.Get(i), true
+}
 
-	// Seek to the correct location to read the function.
-	d.r.SeekTo(int64(foff))
-
+// ReadFunc reads the coverage meta-data for the function with index
+// 'findex', filling it into the FuncDesc pointed to by 'f'.
+func (d *CoverageMetaDataDecoder) ReadFunc(fidx uint32, f *coverage.FuncDesc) error {
+	strtab := d.stringTable()
 	// Preamble containing number of units, file, and function.
-	numUnits := uint32(d.r.ReadULEB128())
+	numUnits, err := d.seekFunc(fidx)
+	if err != nil {
+		return err
+	}
 	fnameidx := uint32(d.r.ReadULEB128())
 	fileidx := uint32(d.r.ReadULEB128())
 
-	f.Srcfile = d.strtab.Get(fileidx)
-	f.Funcname = d.strtab.Get(fnameidx)
+	f.Srcfile = strtab.Get(fileidx)
+	f.Funcname = strtab.Get(fnameidx)
 
 	// Now the units
 	f.Units = f.Units[:0]
@@ -128,3 +137,198 @@ func (d *CoverageMetaDataDecoder) ReadFunc(fidx uint32, f *coverage.FuncDesc) er
 	}
 	return nil
 }
+
+// seekFunc positions the reader at the start of the encoded
+// function with index 'fidx', then reads and returns the number of
+// coverable units in the function.
+func (d *CoverageMetaDataDecoder) seekFunc(fidx uint32) (uint32, error) {
+	if fidx >= d.hdr.NumFuncs {
+		return 0, fmt.Errorf("illegal function index")
+	}
+
+	// Seek to the correct location to read the function offset and read it.
+	funcOffsetLocation := int64(coverage.CovMetaHeaderSize + 4*fidx)
+	d.r.SeekTo(funcOffsetLocation)
+	foff := d.r.ReadUint32()
+
+	// Check assumptions
+	if foff < uint32(funcOffsetLocation) || foff > d.hdr.Length {
+		return 0, fmt.Errorf("malformed func offset %d", foff)
+	}
+
+	// Seek to the correct location to read the function.
+	d.r.SeekTo(int64(foff))
+	return uint32(d.r.ReadULEB128()), nil
+}
+
+// FuncSummary describes an instrumented function without its
+// individual coverable units: the function name, source file, the
+// range of lines spanned by its units, and the number of units.
+type FuncSummary struct {
+	Funcname string
+	Srcfile  string
+	StLine   uint32 // smallest start line of any unit
+	EnLine   uint32 // largest end line of any unit
+	NumUnits uint32
+	Lit      bool // true if this is a function literal
+}
+
+// ReadFuncSummary reads summary information for the function with
+// index 'fidx' into 'fs'. Unlike ReadFunc, it does not materialize
+// the function's coverable units, and so does not allocate.
+func (d *CoverageMetaDataDecoder) ReadFuncSummary(fidx uint32, fs *FuncSummary) error {
+	strtab := d.stringTable()
+	numUnits, err := d.seekFunc(fidx)
+	if err != nil {
+		return err
+	}
+	fnameidx := uint32(d.r.ReadULEB128())
+	fileidx := uint32(d.r.ReadULEB128())
+	*fs = FuncSummary{
+		Funcname: strtab.Get(fnameidx),
+		Srcfile:  strtab.Get(fileidx),
+		NumUnits: numUnits,
+	}
+	for k := uint32(0); k < numUnits; k++ {
+		stl := uint32(d.r.ReadULEB128())
+		d.r.ReadULEB128() // start column
+		enl := uint32(d.r.ReadULEB128())
+		d.r.ReadULEB128() // end column
+		d.r.ReadULEB128() // number of statements
+		if k == 0 || stl < fs.StLine {
+			fs.StLine = stl
+		}
+		if enl > fs.EnLine {
+			fs.EnLine = enl
+		}
+	}
+	fs.Lit = d.r.ReadULEB128() != 0
+	return nil
+}
+
+// VisitFuncSummaries invokes 'visit' with summary information for
+// each function in the package in index order, stopping early if
+// 'visit' returns false. The FuncSummary passed to 'visit' is reused
+// from call to call, so only a single function's summary is live at
+// any given point; callers wishing to retain it must make a copy.
+func (d *CoverageMetaDataDecoder) VisitFuncSummaries(visit func(fidx uint32, fs *FuncSummary) bool) error {
+	var fs FuncSummary
+	for fidx := uint32(0); fidx < d.hdr.NumFuncs; fidx++ {
+		if err := d.ReadFuncSummary(fidx, &fs); err != nil {
+			return err
+		}
+		if !visit(fidx, &fs) {
+			break
+		}
+	}
+	return nil
+}
+
+// checkStringTable checks that the string table starting at offset
+// 'off' in 'b' can be decoded without running off the end of 'b',
+// returning the offset just past the table.
+func (d *CoverageMetaDataDecoder) checkStringTable(b []byte, off int64) (int64, error) {
+	if off > int64(len(b)) {
+		return 0, fmt.Errorf("string table offset %d beyond end of payload (%d bytes)", off, len(b))
+	}
+	r := slicereader.NewReader(b, true)
+	r.SeekTo(off)
+	n, err := r.ReadULEB128Checked()
+	if err != nil {
+		return 0, fmt.Errorf("reading string table size: %v", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		slen, err := r.ReadULEB128Checked()
+		if err != nil {
+			return 0, fmt.Errorf("reading length of string %d: %v", i, err)
+		}
+		if slen > uint64(int64(len(b))-r.Offset()) {
+			return 0, fmt.Errorf("string %d (length %d) runs off end of payload", i, slen)
+		}
+		r.SeekTo(r.Offset() + int64(slen))
+	}
+	return r.Offset(), nil
+}
+
+// Validate checks the package's meta-data for internal consistency:
+// that the payload length recorded in the header is in range, that
+// the header's counts agree with the function offset table and string
+// table, that each function offset lies within the function section,
+// and that each encoded function can be decoded without running off
+// the end of the payload and refers only to valid string table
+// entries. It returns a descriptive error for the first inconsistency
+// found. NewCoverageMetaDataDecoder reads only the header, so
+// meta-data from an untrusted source should be validated before
+// calling PackagePath, ReadFunc or other methods that read strings or
+// decode functions, which assume well-formed input.
+func (d *CoverageMetaDataDecoder) Validate() error {
+	if int64(d.hdr.Length) > int64(len(d.b)) {
+		return fmt.Errorf("header length %d exceeds payload size %d", d.hdr.Length, len(d.b))
+	}
+	b := d.b[:d.hdr.Length]
+	tabEnd := coverage.CovMetaHeaderSize + 4*int64(d.hdr.NumFuncs)
+	if tabEnd > int64(len(b)) {
+		return fmt.Errorf("function offset table for %d functions runs off end of payload (%d bytes)", d.hdr.NumFuncs, len(b))
+	}
+	funcStart, err := d.checkStringTable(b, tabEnd)
+	if err != nil {
+		return err
+	}
+	nstrs := uint64(d.stringTable().Entries())
+	if uint64(d.hdr.NumFiles) != nstrs {
+		return fmt.Errorf("header string count %d does not match string table (%d entries)", d.hdr.NumFiles, nstrs)
+	}
+	for _, x := range []struct {
+		what string
+		idx  uint32
+	}{
+		{"package name", d.hdr.PkgName},
+		{"package path", d.hdr.PkgPath},
+		{"module path", d.hdr.ModulePath},
+	} {
+		if uint64(x.idx) >= nstrs {
+			return fmt.Errorf("%s string index %d out of range (%d entries)", x.what, x.idx, nstrs)
+		}
+	}
+
+	r := slicereader.NewReader(b, true)
+	uleb := func(fidx uint32, what string) (uint64, error) {
+		v, err := r.ReadULEB128Checked()
+		if err != nil {
+			return 0, fmt.Errorf("function %d: reading %s: %v", fidx, what, err)
+		}
+		return v, nil
+	}
+	for fidx := uint32(0); fidx < d.hdr.NumFuncs; fidx++ {
+		r.SeekTo(coverage.CovMetaHeaderSize + 4*int64(fidx))
+		foff := int64(r.ReadUint32())
+		if foff < funcStart || foff >= int64(len(b)) {
+			return fmt.Errorf("function %d: offset %d out of range [%d,%d)", fidx, foff, funcStart, len(b))
+		}
+		r.SeekTo(foff)
+		numUnits, err := uleb(fidx, "unit count")
+		if err != nil {
+			return err
+		}
+		for _, what := range []string{"function name", "source file"} {
+			idx, err := uleb(fidx, what)
+			if err != nil {
+				return err
+			}
+			if idx >= nstrs {
+				return fmt.Errorf("function %d: %s string index %d out of range (%d entries)", fidx, what, idx, nstrs)
+			}
+		}
+		for k := uint64(0); k < numUnits; k++ {
+			for j := 0; j < 5; j++ {
+				if _, err := uleb(fidx, "coverable unit"); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := uleb(fidx, "literal flag"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
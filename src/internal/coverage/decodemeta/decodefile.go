@@ -34,6 +34,12 @@ type CoverageMetaFileReader struct {
 	fileRdr    *bufio.Reader
 	fileView   []byte
 	debug      bool
+
+	// Set for readers created by OpenCoverageMetaFile: 'mapped' is
+	// the region backing fileView, if the file was mapped, and
+	// 'owned' indicates that Close should close 'f'.
+	mapped []byte
+	owned  bool
 }
 
 // NewCoverageMetaFileReader returns a new helper object for reading
@@ -55,6 +61,61 @@ func NewCoverageMetaFileReader(f *os.File, fileView []byte) (*CoverageMetaFileRe
 	return r, nil
 }
 
+// OpenCoverageMetaFile opens the meta-data file 'path' and returns
+// a helper object for reading it. Where the platform supports it, the
+// file is mapped read-only into memory and used as the reader's file
+// view, so that package payloads are returned without being copied
+// and the file is never read in full onto the heap; elsewhere (or if
+// the mapping fails) the reader falls back to regular file Read
+// operations. The caller must Close the reader when done, after which
+// payloads and decoders obtained from it, and strings returned by
+// those decoders, must no longer be used.
+func OpenCoverageMetaFile(path string) (*CoverageMetaFileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	mapped := mapFile(f, fi.Size())
+	r, err := NewCoverageMetaFileReader(f, mapped)
+	if err != nil {
+		if mapped != nil {
+			unmapFile(mapped)
+		}
+		f.Close()
+		return nil, err
+	}
+	r.mapped = mapped
+	r.owned = true
+	return r, nil
+}
+
+// Close releases the resources held by a reader created with
+// OpenCoverageMetaFile, unmapping the file if it was mapped and
+// closing it. For a reader created with NewCoverageMetaFileReader,
+// Close does nothing; the caller remains responsible for the file
+// and any file view it passed in.
+func (r *CoverageMetaFileReader) Close() error {
+	if !r.owned {
+		return nil
+	}
+	r.owned = false
+	var err error
+	if r.mapped != nil {
+		err = unmapFile(r.mapped)
+		r.mapped = nil
+		r.fileView = nil
+	}
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func (r *CoverageMetaFileReader) readFileHeader() error {
 	var err error
 
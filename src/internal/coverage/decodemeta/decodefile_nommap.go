@@ -0,0 +1,17 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package decodemeta
+
+import "os"
+
+func mapFile(f *os.File, size int64) []byte {
+	return nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
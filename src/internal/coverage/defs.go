@@ -58,8 +58,10 @@ var CovMetaMagic = [4]byte{'\x00', '\x63', '\x76', '\x6d'}
 // MetaFilePref is a prefix used when emitting meta-data files; these
 // files are of the form "covmeta.<hash>", where hash is a hash
 // computed from the hashes of all the package meta-data symbols in
-// the program.
+// the program. MetaFileTempl describes the format of the file name:
+// prefix followed by meta-file hash.
 const MetaFilePref = "covmeta"
+const MetaFileTempl = "%s.%x"
 
 // MetaFileVersion contains the current (most recent) meta-data file version.
 const MetaFileVersion = 1
@@ -61,6 +61,37 @@ type CounterVisitor interface {
 // coverage counter data.
 type CounterVisitorFn func(pkid uint32, funcid uint32, counters []uint32) error
 
+// FuncCounters holds the counter values for a single function,
+// identified by the index of its package within the meta-data file
+// and the index of the function within the package.
+type FuncCounters struct {
+	PkgIdx   uint32
+	FuncIdx  uint32
+	Counters []uint32
+}
+
+// FuncCounterList is a CounterVisitor that visits an explicit list of
+// functions, in order, so that counters can be written for any
+// subset of a program's functions (for example, only the functions
+// changed by a patch). As with the counter data files written by the
+// runtime, which omit functions that never executed, readers treat
+// functions absent from a counter data file as having zero counts.
+// Each function should appear in the list at most once.
+type FuncCounterList []FuncCounters
+
+func (l FuncCounterList) NumFuncs() (int, error) {
+	return len(l), nil
+}
+
+func (l FuncCounterList) VisitFuncs(f CounterVisitorFn) error {
+	for _, fc := range l {
+		if err := f(fc.PkgIdx, fc.FuncIdx, fc.Counters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Write writes the contents of the count-data file to the writer
 // previously supplied to NewCoverageDataWriter. Returns an error
 // if something went wrong somewhere with the write.
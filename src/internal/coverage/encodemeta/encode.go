@@ -9,7 +9,6 @@ package encodemeta
 // instrumentation is turned on.
 
 import (
-	"bytes"
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
@@ -23,8 +22,9 @@ import (
 
 type CoverageMetaDataBuilder struct {
 	stab    stringtab.Writer
-	funcs   []funcDesc
-	tmp     []byte // temp work slice
+	funcs   []byte   // encoded functions, back to back
+	flens   []uint32 // length of each encoded function within 'funcs'
+	tmp     []byte   // temp work slice
 	h       hash.Hash
 	pkgpath uint32
 	pkgname uint32
@@ -59,49 +59,45 @@ func h32(x uint32, h hash.Hash, tmp []byte) {
 	h.Write(tmp)
 }
 
-type funcDesc struct {
-	encoded []byte
-}
-
-// AddFunc registers a new function with the meta data builder.
+// AddFunc registers a new function with the meta data builder. The
+// function is encoded immediately and appended to the builder's
+// payload; only the encoded form is retained, not 'f' itself.
 func (b *CoverageMetaDataBuilder) AddFunc(f coverage.FuncDesc) uint {
 	hashFuncDesc(b.h, &f, b.tmp)
-	fd := funcDesc{}
-	b.tmp = b.tmp[:0]
-	b.tmp = uleb128.AppendUleb128(b.tmp, uint(len(f.Units)))
-	b.tmp = uleb128.AppendUleb128(b.tmp, uint(b.stab.Lookup(f.Funcname)))
-	b.tmp = uleb128.AppendUleb128(b.tmp, uint(b.stab.Lookup(f.Srcfile)))
+	start := len(b.funcs)
+	b.funcs = uleb128.AppendUleb128(b.funcs, uint(len(f.Units)))
+	b.funcs = uleb128.AppendUleb128(b.funcs, uint(b.stab.Lookup(f.Funcname)))
+	b.funcs = uleb128.AppendUleb128(b.funcs, uint(b.stab.Lookup(f.Srcfile)))
 	for _, u := range f.Units {
-		b.tmp = uleb128.AppendUleb128(b.tmp, uint(u.StLine))
-		b.tmp = uleb128.AppendUleb128(b.tmp, uint(u.StCol))
-		b.tmp = uleb128.AppendUleb128(b.tmp, uint(u.EnLine))
-		b.tmp = uleb128.AppendUleb128(b.tmp, uint(u.EnCol))
-		b.tmp = uleb128.AppendUleb128(b.tmp, uint(u.NxStmts))
+		b.funcs = uleb128.AppendUleb128(b.funcs, uint(u.StLine))
+		b.funcs = uleb128.AppendUleb128(b.funcs, uint(u.StCol))
+		b.funcs = uleb128.AppendUleb128(b.funcs, uint(u.EnLine))
+		b.funcs = uleb128.AppendUleb128(b.funcs, uint(u.EnCol))
+		b.funcs = uleb128.AppendUleb128(b.funcs, uint(u.NxStmts))
 	}
 	lit := uint(0)
 	if f.Lit {
 		lit = 1
 	}
-	b.tmp = uleb128.AppendUleb128(b.tmp, lit)
-	fd.encoded = bytes.Clone(b.tmp)
-	rv := uint(len(b.funcs))
-	b.funcs = append(b.funcs, fd)
+	b.funcs = uleb128.AppendUleb128(b.funcs, lit)
+	rv := uint(len(b.flens))
+	b.flens = append(b.flens, uint32(len(b.funcs)-start))
 	return rv
 }
 
-func (b *CoverageMetaDataBuilder) emitFuncOffsets(w io.WriteSeeker, off int64) int64 {
-	nFuncs := len(b.funcs)
+func (b *CoverageMetaDataBuilder) emitFuncOffsets(w io.Writer, off int64) int64 {
+	nFuncs := len(b.flens)
 	var foff int64 = coverage.CovMetaHeaderSize + int64(b.stab.Size()) + int64(nFuncs)*4
 	for idx := 0; idx < nFuncs; idx++ {
 		b.wrUint32(w, uint32(foff))
-		foff += int64(len(b.funcs[idx].encoded))
+		foff += int64(b.flens[idx])
 	}
-	return off + (int64(len(b.funcs)) * 4)
+	return off + (int64(nFuncs) * 4)
 }
 
-func (b *CoverageMetaDataBuilder) emitFunc(w io.WriteSeeker, off int64, f funcDesc) (int64, error) {
-	ew := len(f.encoded)
-	if nw, err := w.Write(f.encoded); err != nil {
+func (b *CoverageMetaDataBuilder) emitFuncs(w io.Writer, off int64) (int64, error) {
+	ew := len(b.funcs)
+	if nw, err := w.Write(b.funcs); err != nil {
 		return 0, err
 	} else if ew != nw {
 		return 0, fmt.Errorf("short write emitting coverage meta-data")
@@ -110,12 +106,12 @@ func (b *CoverageMetaDataBuilder) emitFunc(w io.WriteSeeker, off int64, f funcDe
 }
 
 func (b *CoverageMetaDataBuilder) reportWriteError(err error) {
-	if b.werr != nil {
+	if b.werr == nil {
 		b.werr = err
 	}
 }
 
-func (b *CoverageMetaDataBuilder) wrUint32(w io.WriteSeeker, v uint32) {
+func (b *CoverageMetaDataBuilder) wrUint32(w io.Writer, v uint32) {
 	b.tmp = b.tmp[:0]
 	b.tmp = append(b.tmp, []byte{0, 0, 0, 0}...)
 	binary.LittleEndian.PutUint32(b.tmp, v)
@@ -131,22 +127,57 @@ func (b *CoverageMetaDataBuilder) wrUint32(w io.WriteSeeker, v uint32) {
 func (b *CoverageMetaDataBuilder) Emit(w io.WriteSeeker) ([16]byte, error) {
 	// Emit header.  Length will initially be zero, we'll
 	// back-patch it later.
+	off, digest, err := b.emit(w, 0)
+	if err != nil {
+		return digest, err
+	}
+
+	// Back-patch the length.
+	totalLength := uint32(off)
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return digest, err
+	}
+	b.wrUint32(w, totalLength)
+	if b.werr != nil {
+		return digest, b.werr
+	}
+	return digest, nil
+}
+
+// Finish writes the meta-data accumulated so far in this builder to
+// 'w', producing exactly the same bytes as Emit. Since the total
+// length of the payload is computed before anything is written,
+// Finish does not need to back-patch the header, meaning that 'w'
+// can be any io.Writer (for example, a pipe or a compressor).
+// Returns a hash of the meta-data payload and an error.
+func (b *CoverageMetaDataBuilder) Finish(w io.Writer) ([16]byte, error) {
+	tlen := coverage.CovMetaHeaderSize + 4*uint32(len(b.flens)) +
+		b.stab.Size() + uint32(len(b.funcs))
+	_, digest, err := b.emit(w, tlen)
+	return digest, err
+}
+
+// emit writes the header (with the specified total length), function
+// offsets, string table, and encoded functions to 'w', returning the
+// number of bytes written, the meta-data hash, and an error.
+func (b *CoverageMetaDataBuilder) emit(w io.Writer, totalLength uint32) (int64, [16]byte, error) {
 	var digest [16]byte
 	copy(digest[:], b.h.Sum(nil))
 	mh := coverage.MetaSymbolHeader{
-		// hash and length initially zero, will be back-patched
+		// length is zero when called from Emit (back-patched later)
+		Length:     totalLength,
 		PkgPath:    uint32(b.pkgpath),
 		PkgName:    uint32(b.pkgname),
 		ModulePath: uint32(b.modpath),
 		NumFiles:   uint32(b.stab.Nentries()),
-		NumFuncs:   uint32(len(b.funcs)),
+		NumFuncs:   uint32(len(b.flens)),
 		MetaHash:   digest,
 	}
 	if b.debug {
 		fmt.Fprintf(os.Stderr, "=-= writing header: %+v\n", mh)
 	}
 	if err := binary.Write(w, binary.LittleEndian, mh); err != nil {
-		return digest, fmt.Errorf("error writing meta-file header: %v\n", err)
+		return 0, digest, fmt.Errorf("error writing meta-file header: %v\n", err)
 	}
 	off := int64(coverage.CovMetaHeaderSize)
 
@@ -155,34 +186,21 @@ func (b *CoverageMetaDataBuilder) Emit(w io.WriteSeeker) ([16]byte, error) {
 
 	// Check for any errors up to this point.
 	if b.werr != nil {
-		return digest, b.werr
+		return 0, digest, b.werr
 	}
 
 	// Write string table.
 	if err := b.stab.Write(w); err != nil {
-		return digest, err
+		return 0, digest, err
 	}
 	off += int64(b.stab.Size())
 
 	// Write functions
-	for _, f := range b.funcs {
-		var err error
-		off, err = b.emitFunc(w, off, f)
-		if err != nil {
-			return digest, err
-		}
+	off, err := b.emitFuncs(w, off)
+	if err != nil {
+		return 0, digest, err
 	}
-
-	// Back-patch the length.
-	totalLength := uint32(off)
-	if _, err := w.Seek(0, io.SeekStart); err != nil {
-		return digest, err
-	}
-	b.wrUint32(w, totalLength)
-	if b.werr != nil {
-		return digest, b.werr
-	}
-	return digest, nil
+	return off, digest, nil
 }
 
 // HashFuncDesc computes an md5 sum of a coverage.FuncDesc and returns
@@ -0,0 +1,357 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"internal/coverage"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// FileKind describes the role a given file plays (if any) in a
+// coverage pod.
+type FileKind int
+
+const (
+	// NonCoverageFile is a file unrelated to coverage.
+	NonCoverageFile FileKind = iota
+	// MetaDataFile is a coverage meta-data file.
+	MetaDataFile
+	// CounterDataFile is a coverage counter data file.
+	CounterDataFile
+	// MalformedMetaDataFile is a file whose name marks it as a
+	// meta-data file, but whose hash portion is not well-formed.
+	MalformedMetaDataFile
+	// MalformedCounterDataFile is a file whose name marks it as a
+	// counter data file, but which lacks one of the required fields
+	// (meta-data hash, process ID and emit sequence value) or has a
+	// field that is not well-formed.
+	MalformedCounterDataFile
+)
+
+func (k FileKind) String() string {
+	switch k {
+	case NonCoverageFile:
+		return "non-coverage"
+	case MetaDataFile:
+		return "meta-data"
+	case CounterDataFile:
+		return "counter-data"
+	case MalformedMetaDataFile:
+		return "malformed meta-data"
+	case MalformedCounterDataFile:
+		return "malformed counter-data"
+	}
+	return "<invalid>"
+}
+
+// base returns MetaDataFile or CounterDataFile for any kind of
+// meta-data or counter data file (malformed or not), and
+// NonCoverageFile otherwise.
+func (k FileKind) base() FileKind {
+	switch k {
+	case MetaDataFile, MalformedMetaDataFile:
+		return MetaDataFile
+	case CounterDataFile, MalformedCounterDataFile:
+		return CounterDataFile
+	}
+	return NonCoverageFile
+}
+
+// Classifier decides, based only on the base name of a file, whether
+// the file is a coverage meta-data file, a coverage counter data
+// file, or something unrelated to coverage. For a meta-data file,
+// 'hash' is the meta-data hash encoded in the name. For a counter
+// data file, 'hash' is the hash of the meta-data file that the
+// counters refer to, 'pid' is the ID of the process that wrote the
+// file, and 'seq' is the emit sequence value (at the moment this is
+// the UnixNano time at which the file was written). Files are
+// grouped into pods by comparing hashes, so a Classifier is free to
+// use any naming scheme it likes as long as the hash reported for a
+// counter data file matches the hash reported for its meta-data file.
+type Classifier interface {
+	Classify(name string) (kind FileKind, hash string, pid int, seq int64)
+}
+
+// PrefixClassifier is a Classifier that recognizes file names of the
+// form "<MetaPrefix>.<hash>" as meta-data files, and file names of
+// the form "<CounterPrefix>.<hash>.<pid>.<seq>" as counter data
+// files (see coverage.CounterFileTempl). The hash in a meta-data file
+// name must be written as lowercase hex digits, with the length
+// produced by formatting a meta-data file hash with "%x"; meta-data
+// file names with any other hash are reported as
+// MalformedMetaDataFile. Likewise, file names starting with
+// "<CounterPrefix>." that don't have all of the fields of a counter
+// data file name are reported as MalformedCounterDataFile; this
+// includes names whose hash field contains white space, control
+// characters or path separators.
+type PrefixClassifier struct {
+	MetaPrefix    string
+	CounterPrefix string
+}
+
+// DefaultClassifier recognizes the file names written by
+// coverage-instrumented Go programs. It is used by CollectPods and
+// CollectPodsFromFiles, and by Config when no classifier is set.
+var DefaultClassifier = PrefixClassifier{
+	MetaPrefix:    coverage.MetaFilePref,
+	CounterPrefix: coverage.CounterFilePref,
+}
+
+// MetaFileName returns the name of the meta-data file for meta-data
+// hash 'hash', which should be formatted as lowercase hex digits (as
+// with "%x"). This is the name written by coverage-instrumented
+// programs and recognized by DefaultClassifier.
+func MetaFileName(hash string) string {
+	return coverage.MetaFilePref + "." + hash
+}
+
+// CounterFileName returns the name of a counter data file for the
+// meta-data hash 'hash' (formatted as for MetaFileName), written by
+// process 'pid' at emit sequence value 'nt'. The name follows
+// coverage.CounterFileTempl, and so is recognized by
+// DefaultClassifier.
+func CounterFileName(hash string, pid int, nt int64) string {
+	return coverage.CounterFilePref + "." + hash + "." + strconv.Itoa(pid) + "." + strconv.FormatInt(nt, 10)
+}
+
+// ExpectedFileNames returns the names of the files of the pod with
+// meta-data hash 'metaHash' (formatted as for MetaFileName), as
+// written by coverage-instrumented programs: the meta-data file name,
+// followed by the name of a counter data file for each process ID
+// pids[i] and emit sequence value seqs[i], in order. Tools can compare
+// the result with the contents of a directory. ExpectedFileNames
+// panics if 'pids' and 'seqs' have different lengths.
+func ExpectedFileNames(metaHash string, pids []int, seqs []int64) []string {
+	if len(pids) != len(seqs) {
+		panic("pods.ExpectedFileNames: pids and seqs differ in length")
+	}
+	names := make([]string, 0, 1+len(pids))
+	names = append(names, MetaFileName(metaHash))
+	for i, pid := range pids {
+		names = append(names, CounterFileName(metaHash, pid, seqs[i]))
+	}
+	return names
+}
+
+// Classify implements the Classifier interface.
+func (pc PrefixClassifier) Classify(name string) (FileKind, string, int, int64) {
+	if rest, ok := trimPrefixDot(name, pc.MetaPrefix); ok {
+		if !validMetaHash(rest) {
+			return MalformedMetaDataFile, "", 0, 0
+		}
+		return MetaDataFile, rest, 0, 0
+	}
+	if rest, ok := trimPrefixDot(name, pc.CounterPrefix); ok {
+		// Peel off the trailing sequence and pid fields; whatever
+		// remains is the meta-data hash. This is done for every file
+		// in a directory, so it is careful not to allocate.
+		rest, seqs, ok := cutLastField(rest)
+		if !ok {
+			return MalformedCounterDataFile, "", 0, 0
+		}
+		seq, ok := parseDecimal(seqs, math.MaxInt64)
+		if !ok {
+			return MalformedCounterDataFile, "", 0, 0
+		}
+		hash, pids, ok := cutLastField(rest)
+		if !ok || !validHashField(hash) {
+			return MalformedCounterDataFile, "", 0, 0
+		}
+		pid, ok := parseDecimal(pids, math.MaxInt)
+		if !ok {
+			return MalformedCounterDataFile, "", 0, 0
+		}
+		return CounterDataFile, hash, int(pid), int64(seq)
+	}
+	return NonCoverageFile, "", 0, 0
+}
+
+// trimPrefixDot returns the portion of 'name' following "<pref>.",
+// and a flag indicating whether 'name' has that prefix.
+func trimPrefixDot(name, pref string) (string, bool) {
+	if len(name) < len(pref)+1 || name[:len(pref)] != pref || name[len(pref)] != '.' {
+		return "", false
+	}
+	return name[len(pref)+1:], true
+}
+
+// cutLastField splits 's' at its final '.', returning the text
+// before and after the dot.
+func cutLastField(s string) (before, after string, ok bool) {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return s[:i], s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// parseDecimal parses 's', which must be a non-empty string of
+// decimal digits (with no sign) denoting a value no greater than
+// 'max'. It is equivalent to checking the digits and then calling
+// strconv.ParseUint, in a single pass.
+func parseDecimal(s string, max uint64) (uint64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		if n > (max-uint64(c-'0'))/10 {
+			return 0, false
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	return n, true
+}
+
+// metaHashLen is the length of the hash portion of a meta-data
+// file name (the meta-data file hash, formatted with "%x").
+const metaHashLen = 2 * len(coverage.MetaFileHeader{}.MetaFileHash)
+
+// validMetaHash reports whether 's' is a well-formed meta-data file
+// hash: metaHashLen lowercase hex digits.
+func validMetaHash(s string) bool {
+	if len(s) != metaHashLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validHashField reports whether 's' is acceptable as the hash
+// portion of a file name: non-empty and free of white space, control
+// characters (including NUL) and path separators, any of which could
+// confuse tools that log or join the paths of pod files.
+func validHashField(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c <= ' ', c == 0x7f, c == '/', c == '\\':
+			return false
+		}
+	}
+	return true
+}
+
+// FileClass describes how pod collection treats a single file; see
+// ClassifyDir.
+type FileClass struct {
+	// Name is the base name of the file.
+	Name string
+
+	// Kind is the kind of the file. Files excluded by
+	// Config.ExcludeGlobs are reported as NonCoverageFile.
+	Kind FileKind
+
+	// Hash, PID and Seq are the values parsed from the file's name
+	// by the classifier (see Classifier).
+	Hash string
+	PID  int
+	Seq  int64
+
+	// Compressed is set for gzip-compressed files (see Config.Gzip).
+	Compressed bool
+
+	// Orphan is set for a counter data file that CollectPods would
+	// skip as an orphan (see Stats.OrphanCounterFiles).
+	Orphan bool
+}
+
+// ClassifyDir reports how each file in the directory 'dir' would be
+// classified by CollectPods collecting 'dir' alone, without forming
+// pods. Subdirectories are not included. The result is sorted by file
+// name.
+func ClassifyDir(dir string) ([]FileClass, error) {
+	var cfg Config
+	return cfg.ClassifyDir(dir)
+}
+
+// ClassifyDir is similar to the ClassifyDir function, but classifies
+// files according to the settings in 'cfg', except that cfg.Recursive
+// and cfg.SkipMissingDirs are ignored. With cfg.MetaSubdir or
+// cfg.CounterSubdir set, the files of those subdirectories are
+// reported, as CollectPods would read them, meta-data subdirectory
+// first.
+func (cfg *Config) ClassifyDir(dir string) ([]FileClass, error) {
+	wcfg := *cfg
+	wcfg.Recursive = false
+	wcfg.SkipMissingDirs = false
+	classes := []FileClass{}
+	err := wcfg.Walk([]string{dir}, func(path string, fc FileClass, err error) error {
+		if err != nil {
+			return err
+		}
+		classes = append(classes, fc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// probeBatch is the number of directory entries read at a time by
+// ContainsCoverageFiles.
+const probeBatch = 64
+
+// ContainsCoverageFiles reports whether the directory 'dir' contains
+// a meta-data file or a counter data file, as a cheap check before
+// collecting pods. The directory is read a few entries at a time,
+// stopping at the first coverage file found. Subdirectories are not
+// examined, and files with malformed names don't count.
+func ContainsCoverageFiles(dir string) (bool, error) {
+	var cfg Config
+	return cfg.ContainsCoverageFiles(dir)
+}
+
+// ContainsCoverageFiles is similar to the ContainsCoverageFiles
+// function, but recognizes files according to the settings in 'cfg'
+// (its Classifier, ExcludeGlobs and so on).
+func (cfg *Config) ContainsCoverageFiles(dir string) (bool, error) {
+	if err := cfg.checkExcludeGlobs(); err != nil {
+		return false, err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, &DirReadError{Dir: dir, Err: err}
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && !fi.IsDir() {
+		return false, notADirectory(dir)
+	}
+	cl := cfg.classifier()
+	for {
+		dents, err := f.ReadDir(probeBatch)
+		for _, e := range dents {
+			if e.IsDir() {
+				continue
+			}
+			switch kind, _, _, _, _ := cfg.classify(cl, e.Name()); kind {
+			case MetaDataFile, CounterDataFile:
+				return true, nil
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, &DirReadError{Dir: dir, Err: err}
+		}
+	}
+}
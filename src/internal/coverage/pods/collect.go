@@ -0,0 +1,286 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CollectPods is similar to the CollectPods function, but collects
+// pods according to the settings in 'cfg'.
+func (cfg *Config) CollectPods(dirs []string) ([]Pod, error) {
+	return cfg.collectDirs(dirs, new(collectBuffers), nil)
+}
+
+// AssertNoOrphans checks the coverage files in the directories 'dirs'
+// (collected as with CollectPods) for orphaned counter data files:
+// counter data files for which no meta-data file was found. If there
+// are any, it returns a *PairingError listing all of them along with
+// their meta-data hashes; meta-data files with no counter data files
+// are not reported.
+func AssertNoOrphans(dirs []string) error {
+	var cfg Config
+	return cfg.AssertNoOrphans(dirs)
+}
+
+// AssertNoOrphans is similar to the AssertNoOrphans function, but
+// collects pods according to the settings in 'cfg'. Stats, if set,
+// is filled in as for CollectPods.
+func (cfg *Config) AssertNoOrphans(dirs []string) error {
+	acfg := *cfg
+	var st Stats
+	if acfg.Stats == nil {
+		acfg.Stats = &st
+	}
+	if _, err := acfg.CollectPods(dirs); err != nil {
+		return err
+	}
+	if orphans := acfg.Stats.OrphanCounterFiles; len(orphans) != 0 {
+		return &PairingError{
+			OrphanCounterFiles: orphans,
+			OrphanHashes:       cfg.orphanHashes(orphans),
+		}
+	}
+	return nil
+}
+
+// orphanHashes returns the meta-data hash of each of the orphaned
+// counter data files 'orphans', as reported by the classifier.
+func (cfg *Config) orphanHashes(orphans []string) []string {
+	cl := cfg.classifier()
+	hashes := make([]string, len(orphans))
+	for k, f := range orphans {
+		_, hashes[k], _, _, _ = cfg.classify(cl, filepath.Base(f))
+	}
+	return hashes
+}
+
+// collectDirs implements CollectPods, using working storage from
+// 'buf' and reading directories through 'cache' (which may be nil).
+func (cfg *Config) collectDirs(dirs []string, buf *collectBuffers, cache *dirCache) ([]Pod, error) {
+	labels, err := cfg.originLabels(dirs)
+	if err != nil {
+		return nil, err
+	}
+	sc := dirScan{cache: cache}
+	files, err := cfg.scanDirs(buf.files[:0], dirs, &sc)
+	if err != nil {
+		return nil, err
+	}
+	buf.files = files
+	pods, err := collectPodsImpl(files, cfg, os.ReadFile, buf, labels)
+	if cfg.Stats != nil {
+		cfg.Stats.EmptyInputDirs = sc.empty
+		cfg.Stats.MissingInputDirs = sc.missing
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pods {
+		if err := ValidatePod(p, len(dirs)); err != nil {
+			return nil, err
+		}
+	}
+	return pods, nil
+}
+
+// CollectPodsLabeled is similar to CollectPods, but collects pods from
+// several named sources (for example, "unit", "integration" and
+// "e2e"), each with its own list of directories, given by 'roots'.
+// The Origins of the returned pods index into the list of all of the
+// directories, taken source by source in order of label and then in
+// the order listed, and the label of the source of each counter data
+// file can be obtained with Pod.CounterFileLabel. A directory listed
+// under more than one label is read once, and its files are
+// attributed to the first of the labels.
+func CollectPodsLabeled(roots map[string][]string, warn bool) ([]Pod, error) {
+	cfg := Config{Warn: warn}
+	return cfg.CollectPodsLabeled(roots)
+}
+
+// CollectPodsLabeled is similar to the CollectPodsLabeled function,
+// but collects pods according to the settings in 'cfg', except that
+// cfg.OriginLabels is replaced by the labels in 'roots'.
+func (cfg *Config) CollectPodsLabeled(roots map[string][]string) ([]Pod, error) {
+	labels := make([]string, 0, len(roots))
+	for l := range roots {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	var dirs, dirLabels []string
+	for _, l := range labels {
+		for _, dir := range roots[l] {
+			dirs = append(dirs, dir)
+			dirLabels = append(dirLabels, l)
+		}
+	}
+	lcfg := *cfg
+	lcfg.OriginLabels = dirLabels
+	return lcfg.CollectPods(dirs)
+}
+
+// originLabels returns the labels of the origins 'dirs', taken from
+// cfg.OriginLabels if set.
+func (cfg *Config) originLabels(dirs []string) ([]string, error) {
+	if cfg.OriginLabels != nil {
+		if len(cfg.OriginLabels) != len(dirs) {
+			return nil, fmt.Errorf("%d origin labels for %d input directories", len(cfg.OriginLabels), len(dirs))
+		}
+		return cfg.OriginLabels, nil
+	}
+	labels := make([]string, len(dirs))
+	for k, dir := range dirs {
+		labels[k] = filepath.Base(dir)
+	}
+	return labels, nil
+}
+
+// CollectPodsSplit is similar to CollectPods, but handles the case
+// where meta-data files and counter data files are written to
+// separate locations (for example, a shared directory of meta-data
+// files and a separate directory for the counter data files of each
+// run). Only meta-data files are collected from the directories in
+// 'metaDirs', and only counter data files from the directories in
+// 'counterDirs'; counter files are then joined with meta-data files
+// by hash. The Origins field of each returned pod holds indices into
+// 'counterDirs'. A counter data file whose meta-data file does not
+// appear in any of the 'metaDirs' is treated as an orphan, and as
+// with CollectPods, a meta-data file with no counter data files
+// yields a pod with no counter data files.
+func CollectPodsSplit(metaDirs, counterDirs []string, warn bool) ([]Pod, error) {
+	cfg := Config{Warn: warn}
+	return cfg.CollectPodsSplit(metaDirs, counterDirs)
+}
+
+// CollectPodsSplit is similar to the CollectPodsSplit function, but
+// collects pods according to the settings in 'cfg'. As with
+// CollectPods, duplicate directories within 'metaDirs' or within
+// 'counterDirs' are read only once.
+func (cfg *Config) CollectPodsSplit(metaDirs, counterDirs []string) ([]Pod, error) {
+	if err := cfg.checkExcludeGlobs(); err != nil {
+		return nil, err
+	}
+	labels, err := cfg.originLabels(counterDirs)
+	if err != nil {
+		return nil, err
+	}
+	firstMeta, err := cfg.firstDirs(metaDirs)
+	if err != nil {
+		return nil, err
+	}
+	firstCounter, err := cfg.firstDirs(counterDirs)
+	if err != nil {
+		return nil, err
+	}
+	var files []covFile
+	var sc dirScan
+	for k, dir := range metaDirs {
+		if firstMeta[k] != k {
+			continue
+		}
+		if files, err = cfg.readDir(files, dir, &sc, -1, len(metaDirs)+len(counterDirs), MetaDataFile); err != nil {
+			return nil, err
+		}
+	}
+	for k, dir := range counterDirs {
+		if firstCounter[k] != k {
+			continue
+		}
+		if files, err = cfg.readDir(files, dir, &sc, k, len(metaDirs)+len(counterDirs), CounterDataFile); err != nil {
+			return nil, err
+		}
+	}
+	return collectPodsImpl(files, cfg, os.ReadFile, nil, labels)
+}
+
+// CollectPodsFromFiles is similar to the CollectPodsFromFiles
+// function, but collects pods according to the settings in 'cfg'.
+// An error is returned only if a pod exceeds the limit on counter
+// data files and the FailOnCounterFileLimit policy is in effect, if
+// a file can't be stat'ed for the MinModTime check or read for the
+// VerifyMetaFiles check, if one of the ExcludeGlobs is invalid, or
+// if the OnPod callback fails.
+func (cfg *Config) CollectPodsFromFiles(files []string) ([]Pod, error) {
+	if err := cfg.checkExcludeGlobs(); err != nil {
+		return nil, err
+	}
+	cfiles, err := cfg.listedFiles(files, nil)
+	if err != nil {
+		return nil, err
+	}
+	return collectPodsImpl(cfiles, cfg, os.ReadFile, nil, nil)
+}
+
+// listedFiles classifies the explicitly listed 'files' for
+// CollectPodsFromFiles and CollectPodsFromManifest, returning the
+// coverage-related ones. If 'origins' is non-nil, origins[i] is
+// recorded as the origin of files[i]; otherwise origins are unknown.
+func (cfg *Config) listedFiles(files []string, origins []int) ([]covFile, error) {
+	cl := cfg.classifier()
+	cfiles := make([]covFile, 0, len(files))
+	for i, f := range files {
+		kind, hash, pid, seq, gz := cfg.classify(cl, filepath.Base(f))
+		if kind == NonCoverageFile {
+			continue
+		}
+		var stale bool
+		var size int64
+		var modTime time.Time
+		if cfg.needFileInfo() {
+			info, err := cfg.fileInfo(f, nil)
+			if err != nil {
+				return nil, err
+			}
+			modTime = info.ModTime()
+			stale = cfg.isStale(modTime)
+			size = info.Size()
+		}
+		origin := -1
+		if origins != nil {
+			origin = origins[i]
+		}
+		cfiles = append(cfiles, covFile{
+			path:    f,
+			kind:    kind,
+			hash:    hash,
+			pid:     pid,
+			seq:     seq,
+			origin:  origin,
+			stale:   stale,
+			gz:      gz,
+			size:    size,
+			modTime: modTime,
+		})
+	}
+	return cfiles, nil
+}
+
+// dirPrefix returns a string that can be prepended to the name of
+// a file within 'dir' to yield the same path as filepath.Join(dir,
+// name), without having to clean the result for every file.
+func dirPrefix(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	dir = filepath.Clean(dir)
+	if os.IsPathSeparator(dir[len(dir)-1]) {
+		return dir
+	}
+	return dir + string(filepath.Separator)
+}
+
+// forwardSlashes returns 'path' with each occurrence of the separator
+// 'sep' replaced by '/'.
+func forwardSlashes(path string, sep byte) string {
+	if sep == '/' {
+		return path
+	}
+	return strings.ReplaceAll(path, string(sep), "/")
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Collector collects pods from directories, in the same way as
+// Config.CollectPods, but holds on to state between collections so
+// that programs that rescan the same directories periodically, such
+// as long-running servers, do less work. It reuses the working
+// storage used during collection (the list of files found, the table
+// mapping meta-data hashes to pods, and so on), and caches the
+// entries of each directory it reads, keyed by the directory's
+// modification time; a directory whose modification time hasn't
+// changed since the previous collection is not read again. Files are
+// classified afresh on each collection, so the results are the same
+// as those of CollectPods, even if Config is changed between
+// collections.
+//
+// The zero value of Collector is ready to use. Collect may be called
+// from multiple goroutines simultaneously; concurrent collections are
+// performed one at a time. Pods returned by Collect do not share
+// memory with the Collector, and remain valid after later calls.
+type Collector struct {
+	// Config holds the settings used for each collection. It must
+	// not be modified during a call to Collect.
+	Config Config
+
+	mu    sync.Mutex
+	buf   collectBuffers
+	cache dirCache
+}
+
+// Collect collects the pods in the directories 'dirs' according to
+// c.Config (see CollectPods).
+func (c *Collector) Collect(dirs []string) ([]Pod, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache.begin()
+	pods, err := c.Config.collectDirs(dirs, &c.buf, &c.cache)
+	c.cache.end()
+	return pods, err
+}
+
+// dirCache caches the entries of directories, for Collector. A nil
+// *dirCache reads directories without caching.
+type dirCache struct {
+	dirs map[string]*cachedDir
+	gen  int // generation of the current collection
+	now  time.Time
+}
+
+// cachedDir records the entries of a directory as of the given
+// modification time of the directory.
+type cachedDir struct {
+	modTime time.Time
+	dents   []fs.DirEntry
+	gen     int // generation in which the directory was last read
+}
+
+// racyWindow is how recently a directory may have been modified for
+// its entries not to be cached. Modification times have limited
+// resolution, so a directory read soon after it was modified may be
+// modified again without its modification time changing.
+const racyWindow = 2 * time.Second
+
+// begin starts a new collection.
+func (c *dirCache) begin() {
+	c.gen++
+	c.now = time.Now()
+}
+
+// end finishes a collection, dropping directories that it didn't
+// read.
+func (c *dirCache) end() {
+	for dir, cd := range c.dirs {
+		if cd.gen != c.gen {
+			delete(c.dirs, dir)
+		}
+	}
+}
+
+// readDir returns the entries of directory 'dir', as with
+// os.ReadDir, reusing the entries read by an earlier collection if
+// the directory's modification time hasn't changed.
+func (c *dirCache) readDir(dir string) ([]fs.DirEntry, error) {
+	if c == nil {
+		return os.ReadDir(dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	mt := info.ModTime()
+	if cd := c.dirs[dir]; cd != nil && cd.modTime.Equal(mt) {
+		cd.gen = c.gen
+		return cd.dents, nil
+	}
+	dents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if c.now.Sub(mt) < racyWindow {
+		delete(c.dirs, dir)
+		return dents, nil
+	}
+	if c.dirs == nil {
+		c.dirs = make(map[string]*cachedDir)
+	}
+	c.dirs[dir] = &cachedDir{modTime: mt, dents: dents, gen: c.gen}
+	return dents, nil
+}
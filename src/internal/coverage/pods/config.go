@@ -0,0 +1,425 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config holds settings that control how pods are collected. The
+// zero value of Config is ready to use, and collects pods in the same
+// way as the CollectPods function with warnings disabled.
+type Config struct {
+	// Reporting.
+
+	// Warn enables warnings to stderr for non-fatal problems.
+	Warn bool
+
+	// Stats, if non-nil, is overwritten with the files skipped by
+	// each collection.
+	Stats *Stats
+
+	// Logf, if non-nil, receives a trace of how each file was
+	// classified, grouped or skipped, for debugging.
+	Logf func(format string, args ...interface{})
+
+	// OnPod, if non-nil, is called with each pod, in result order,
+	// once collection is complete. The pod's slices are shared with
+	// the result. An error stops collection.
+	OnPod func(Pod) error
+
+	// OnOrphan, if non-nil, is called with each orphaned counter
+	// data file as it is found. An error stops collection.
+	OnOrphan func(counterFile string, origin, pid int) error
+
+	// File selection.
+
+	// Classifier decides which files are meta-data files and which
+	// are counter data files. If nil, DefaultClassifier is used.
+	Classifier Classifier
+
+	// NameRewriter, if non-nil, rewrites the base name of each file
+	// before classification; "" skips the file. Pods, ExcludeGlobs
+	// and CounterFileFilter still see the actual name.
+	NameRewriter func(name string) string
+
+	// Gzip enables recognition of compressed files, whose names
+	// carry an additional ".gz" suffix.
+	Gzip bool
+
+	// ExcludeGlobs lists filepath.Match patterns for base names of
+	// files to ignore. The patterns are checked once at the start of
+	// each collection; an invalid pattern fails it with an error
+	// wrapping filepath.ErrBadPattern.
+	ExcludeGlobs []string
+
+	// AllowHashes, if non-empty, restricts collection to files with
+	// the listed meta-data hashes; other files are ignored.
+	AllowHashes []string
+
+	// CounterFileFilter, if non-nil, is called with the base name of
+	// each counter data file; files for which it returns false are
+	// ignored.
+	CounterFileFilter func(name string) bool
+
+	// MetaOnly causes counter data files to be ignored, without
+	// being stat'ed or read.
+	MetaOnly bool
+
+	// MinModTime, if non-zero, causes files last modified before it
+	// to be skipped as stale.
+	MinModTime time.Time
+
+	// DropStaleMetaCounters causes counter data files whose only
+	// meta-data file is stale to be skipped as stale rather than
+	// reported as orphans.
+	DropStaleMetaCounters bool
+
+	// StrictCounterHashes causes counter data files with malformed
+	// meta-data hashes to be skipped as malformed rather than
+	// reported as orphans.
+	StrictCounterHashes bool
+
+	// FileInfo, if non-nil, replaces the file system as the source of
+	// file sizes and modification times.
+	FileInfo func(path string) (fs.FileInfo, error)
+
+	// Directory layout.
+
+	// Recursive causes the subdirectories of each input directory to
+	// be read too, with the input directory's origin.
+	Recursive bool
+
+	// MaxDepth, if positive, limits Recursive to subdirectories at
+	// most MaxDepth levels below an input directory.
+	MaxDepth int
+
+	// MetaSubdir and CounterSubdir, if either is set, select a layout
+	// in which meta-data files are read only from the subdirectory
+	// MetaSubdir of each input directory, and counter data files only
+	// from CounterSubdir ("" is the input directory itself). Both
+	// must exist, and Recursive has no effect.
+	MetaSubdir, CounterSubdir string
+
+	// AllowFileInputs allows input directories to name coverage
+	// files, which are collected with their index as origin.
+	// Otherwise an input that is not a directory is an error wrapping
+	// ErrNotADirectory.
+	AllowFileInputs bool
+
+	// SkipMissingDirs causes input directories that don't exist to
+	// be skipped rather than fail collection.
+	SkipMissingDirs bool
+
+	// ResolveSymlinks causes symbolic links to be resolved when
+	// looking for duplicate input directories.
+	ResolveSymlinks bool
+
+	// Pairing.
+
+	// StrictSameDir requires a counter data file's meta-data file to
+	// be in the same directory; otherwise the counter data file is an
+	// orphan.
+	StrictSameDir bool
+
+	// StrictPairing causes collection to fail with a *PairingError
+	// if there are orphans or meta-data files without counter data
+	// files.
+	StrictPairing bool
+
+	// IgnoreOrphansForHashes lists meta-data hashes whose orphaned
+	// counter data files are skipped silently.
+	IgnoreOrphansForHashes []string
+
+	// VerifyMetaFiles causes meta-data files with the same hash to be
+	// compared, reporting duplicates whose contents differ in
+	// Stats.MismatchedMetaFiles.
+	VerifyMetaFiles bool
+
+	// ErrorOnEmpty causes collection to fail with ErrNoPods if no
+	// pods are found.
+	ErrorOnEmpty bool
+
+	// Counter data file selection.
+
+	// CounterDedup selects how counter data files with the same hash,
+	// process ID and sequence value are handled.
+	CounterDedup DedupStrategy
+
+	// KeepLatestPerPID keeps only the counter data file with the
+	// highest sequence value for each process ID in a pod.
+	KeepLatestPerPID bool
+
+	// MaxCounterFilesPerPod, if positive, limits the number of counter
+	// data files in a pod, as directed by CounterFileLimitPolicy.
+	MaxCounterFilesPerPod int
+
+	// CounterFileLimitPolicy selects what happens to a pod exceeding
+	// MaxCounterFilesPerPod.
+	CounterFileLimitPolicy CounterFileLimitPolicy
+
+	// GlobalMaxCounterFiles, if positive, limits the total number of
+	// counter data files, taking them round-robin across pods in
+	// meta-data file order. Every pod is still returned.
+	GlobalMaxCounterFiles int
+
+	// Results.
+
+	// OrderPodsBy selects the order of the returned pods.
+	OrderPodsBy PodOrder
+
+	// OriginLabels, if non-nil, labels each input directory (or
+	// counter directory, for CollectPodsSplit) by index. By default
+	// the label is the directory's base name.
+	OriginLabels []string
+
+	// ForwardSlashes causes file paths in the returned pods to use
+	// '/' as the separator.
+	ForwardSlashes bool
+
+	// VerifyExpectedPIDs maps meta-data hashes to the process IDs
+	// expected to write counter data files for them (see
+	// Pod.MissingPIDs).
+	VerifyExpectedPIDs map[string][]int
+
+	// GroupKey, if non-nil, gives the group of the pod with meta-data
+	// file metaFile for CollectPodGroups. By default pods are grouped
+	// by meta-data hash.
+	GroupKey func(metaFile string) (string, error)
+}
+
+// PodOrder determines the order of the pods returned by pod
+// collection (see Config.OrderPodsBy).
+type PodOrder int
+
+const (
+	// OrderByMetaFile orders pods by the path of their meta-data
+	// file, which for pods collected from a single directory is the
+	// order of their meta-data hashes.
+	OrderByMetaFile PodOrder = iota
+	// OrderByCounterFileCount orders pods by decreasing number of
+	// counter data files (after any limit imposed by
+	// MaxCounterFilesPerPod), and then by meta-data file.
+	OrderByCounterFileCount
+	// OrderByTotalBytes orders pods by decreasing total size of the
+	// meta-data file and counter data files that make up the pod,
+	// and then by meta-data file.
+	OrderByTotalBytes
+)
+
+// DedupStrategy determines how duplicate counter data files are
+// handled (see Config.CounterDedup).
+type DedupStrategy int
+
+const (
+	// DedupAll keeps all duplicates.
+	DedupAll DedupStrategy = iota
+	// DedupFirst keeps the first of a set of duplicates, in the
+	// order in which the files were found (for CollectPods, the
+	// order of the input directories), and skips the others.
+	DedupFirst
+	// DedupNewest keeps the most recently modified of a set of
+	// duplicates, and skips the others; of duplicates with the same
+	// modification time, the first is kept. This requires the
+	// modification time of each file, so files are stat'ed during
+	// collection.
+	DedupNewest
+)
+
+// CounterFileLimitPolicy determines what happens when a pod has more
+// counter data files than allowed by Config.MaxCounterFilesPerPod.
+type CounterFileLimitPolicy int
+
+const (
+	// TruncateCounterFiles keeps the first MaxCounterFilesPerPod
+	// counter data files of the pod (in sorted order) and skips the
+	// rest, issuing a warning if warnings are enabled.
+	TruncateCounterFiles CounterFileLimitPolicy = iota
+	// FailOnCounterFileLimit causes pod collection to fail with an
+	// error.
+	FailOnCounterFileLimit
+)
+
+// Stats records information about files that were skipped during pod
+// collection due to non-fatal problems.
+type Stats struct {
+	// MalformedMetaNames lists files whose names mark them as
+	// meta-data files, but whose hashes are not well-formed (see
+	// MalformedMetaDataFile).
+	MalformedMetaNames []string
+
+	// MalformedCounterNames lists files whose names mark them as
+	// counter data files, but which are missing fields or have
+	// fields that are not well-formed (see MalformedCounterDataFile).
+	MalformedCounterNames []string
+
+	// OrphanCounterFiles lists counter data files for which no
+	// corresponding meta-data file was found.
+	OrphanCounterFiles []string
+
+	// TruncatedCounterFiles lists counter data files that were
+	// skipped because their pod exceeded Config.MaxCounterFilesPerPod,
+	// or because the total exceeded Config.GlobalMaxCounterFiles.
+	TruncatedCounterFiles []string
+
+	// StaleFiles lists files that were skipped because they were
+	// older than Config.MinModTime (see also
+	// Config.DropStaleMetaCounters).
+	StaleFiles []string
+
+	// MismatchedMetaFiles lists duplicate meta-data files whose
+	// contents differ from those of the meta-data file chosen for
+	// their pod (see Config.VerifyMetaFiles).
+	MismatchedMetaFiles []string
+
+	// DuplicateCounterFiles lists counter data files that were
+	// skipped as duplicates of other counter data files (see
+	// Config.CounterDedup).
+	DuplicateCounterFiles []string
+
+	// DuplicateCountersAcrossDirs lists counter data files that were
+	// collected even though they duplicate counter data files from an
+	// earlier input directory, because Config.CounterDedup is
+	// DedupAll. Duplicates are only looked for if warnings are
+	// enabled or Stats are requested.
+	DuplicateCountersAcrossDirs []string
+
+	// EmptyInputDirs lists the input directories given to
+	// CollectPods that contained no meta-data files and no counter
+	// data files.
+	EmptyInputDirs []string
+
+	// MissingInputDirs lists the input directories given to
+	// CollectPods that were skipped because they do not exist (see
+	// Config.SkipMissingDirs).
+	MissingInputDirs []string
+
+	// SupersededCounterFiles lists counter data files skipped because
+	// a counter data file with the same meta-data hash and process ID
+	// but a higher emit sequence value was found (see
+	// Config.KeepLatestPerPID).
+	SupersededCounterFiles []string
+}
+
+// needFileInfo reports whether the settings in 'cfg' require the
+// modification time or size of each file.
+func (cfg *Config) needFileInfo() bool {
+	return !cfg.MinModTime.IsZero() || cfg.OrderPodsBy == OrderByTotalBytes || cfg.CounterDedup == DedupNewest
+}
+
+// fileInfo returns information about the file 'path', using
+// cfg.FileInfo if set, and otherwise the directory entry 'e' for the
+// file if non-nil, or os.Stat.
+func (cfg *Config) fileInfo(path string, e fs.DirEntry) (fs.FileInfo, error) {
+	if cfg.FileInfo != nil {
+		return cfg.FileInfo(path)
+	}
+	if e != nil {
+		return e.Info()
+	}
+	return os.Stat(path)
+}
+
+// isStale reports whether a file last modified at 'mt' should be
+// skipped according to cfg.MinModTime.
+func (cfg *Config) isStale(mt time.Time) bool {
+	return !cfg.MinModTime.IsZero() && mt.Before(cfg.MinModTime)
+}
+
+// gzipSuffix is the file name suffix of gzip-compressed coverage
+// files (see Config.Gzip).
+const gzipSuffix = ".gz"
+
+// classify classifies the file with base name 'name' using 'cl',
+// after removing any gzip suffix if cfg.Gzip is set; 'gz' reports
+// whether a suffix was removed; cfg.NameRewriter is applied first,
+// if set. Files matching any of cfg.ExcludeGlobs, files for which
+// cfg.NameRewriter returns "", files whose hashes are not allowed by
+// cfg.AllowHashes, and counter data files rejected by
+// cfg.CounterFileFilter are reported as NonCoverageFile. With
+// cfg.StrictCounterHashes, counter data files with malformed hashes
+// are reported as MalformedCounterDataFile, along with the hash.
+func (cfg *Config) classify(cl Classifier, name string) (kind FileKind, hash string, pid int, seq int64, gz bool) {
+	for _, pat := range cfg.ExcludeGlobs {
+		// Patterns were checked at the start of collection.
+		if ok, _ := filepath.Match(pat, name); ok {
+			return NonCoverageFile, "", 0, 0, false
+		}
+	}
+	base := name
+	if cfg.NameRewriter != nil {
+		if name = cfg.NameRewriter(name); name == "" {
+			return NonCoverageFile, "", 0, 0, false
+		}
+	}
+	if cfg.Gzip && strings.HasSuffix(name, gzipSuffix) {
+		name, gz = name[:len(name)-len(gzipSuffix)], true
+	}
+	kind, hash, pid, seq = cl.Classify(name)
+	if cfg.MetaOnly && kind.base() == CounterDataFile {
+		return NonCoverageFile, "", 0, 0, false
+	}
+	if kind != NonCoverageFile && !cfg.hashAllowed(hash) {
+		return NonCoverageFile, "", 0, 0, false
+	}
+	if kind == CounterDataFile && cfg.StrictCounterHashes && !validMetaHash(hash) {
+		// Keep the hash, for the warning.
+		return MalformedCounterDataFile, hash, 0, 0, gz
+	}
+	if kind == CounterDataFile && cfg.CounterFileFilter != nil && !cfg.CounterFileFilter(base) {
+		return NonCoverageFile, "", 0, 0, false
+	}
+	return kind, hash, pid, seq, gz
+}
+
+// orphanIgnored reports whether an orphaned counter data file with
+// hash 'hash' should be skipped silently (see
+// Config.IgnoreOrphansForHashes).
+func (cfg *Config) orphanIgnored(hash string) bool {
+	for _, h := range cfg.IgnoreOrphansForHashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAllowed reports whether files with hash 'hash' should be
+// collected according to cfg.AllowHashes.
+func (cfg *Config) hashAllowed(hash string) bool {
+	if len(cfg.AllowHashes) == 0 {
+		return true
+	}
+	for _, h := range cfg.AllowHashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExcludeGlobs returns an error if any of cfg.ExcludeGlobs is
+// not a valid pattern. It is called once by each collection entry
+// point, before any files are classified.
+func (cfg *Config) checkExcludeGlobs() error {
+	for _, pat := range cfg.ExcludeGlobs {
+		if _, err := filepath.Match(pat, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pat, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) classifier() Classifier {
+	if cfg.Classifier == nil {
+		return DefaultClassifier
+	}
+	return cfg.Classifier
+}
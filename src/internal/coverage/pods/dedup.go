@@ -0,0 +1,111 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"sort"
+)
+
+// dedupCounterFiles implements cfg.CounterDedup, changing the kind of
+// each counter data file in 'files' that is to be skipped as a
+// duplicate to NonCoverageFile and recording it in 'st'.
+func (cfg *Config) dedupCounterFiles(files []covFile, st *Stats) {
+	keep := make(map[counterKey]int)
+	var dups []int
+	for i := range files {
+		f := &files[i]
+		if f.kind != CounterDataFile {
+			continue
+		}
+		key := counterKey{f.hash, f.pid, f.seq}
+		j, ok := keep[key]
+		if !ok {
+			keep[key] = i
+			continue
+		}
+		dup := i
+		if cfg.CounterDedup == DedupNewest && f.modTime.After(files[j].modTime) {
+			keep[key] = i
+			dup = j
+		}
+		dups = append(dups, dup)
+	}
+	sort.Ints(dups)
+	for _, i := range dups {
+		f := &files[i]
+		if cfg.Warn {
+			fileWarning(f.path, "skipping duplicate counter file")
+		}
+		st.DuplicateCounterFiles = append(st.DuplicateCounterFiles, f.path)
+		f.kind = NonCoverageFile
+	}
+}
+
+// counterKey identifies a counter data file by the fields of its
+// name, for detecting duplicates.
+type counterKey struct {
+	hash string
+	pid  int
+	seq  int64
+}
+
+// findDuplicateCounterFiles records in 'st', and warns about, counter
+// data files in 'files' that duplicate a counter data file with a
+// different origin, for when duplicates are kept (DedupAll).
+func (cfg *Config) findDuplicateCounterFiles(files []covFile, st *Stats) {
+	first := make(map[counterKey]int)
+	for i := range files {
+		f := &files[i]
+		if f.kind != CounterDataFile {
+			continue
+		}
+		key := counterKey{f.hash, f.pid, f.seq}
+		j, ok := first[key]
+		if !ok {
+			first[key] = i
+			continue
+		}
+		if files[j].origin == f.origin {
+			continue
+		}
+		if cfg.Warn {
+			fileWarning(f.path, "counter file duplicates %s from another input directory; both are used", files[j].path)
+		}
+		st.DuplicateCountersAcrossDirs = append(st.DuplicateCountersAcrossDirs, f.path)
+	}
+}
+
+// keepLatestPerPID implements cfg.KeepLatestPerPID, changing the kind
+// of each counter data file in 'files' that is superseded by a later
+// file from the same process ID to NonCoverageFile and recording it
+// in 'st'.
+func (cfg *Config) keepLatestPerPID(files []covFile, st *Stats) {
+	type pidKey struct {
+		hash string
+		pid  int
+	}
+	latest := make(map[pidKey]int)
+	for i := range files {
+		f := &files[i]
+		if f.kind != CounterDataFile {
+			continue
+		}
+		key := pidKey{f.hash, f.pid}
+		if j, ok := latest[key]; !ok || f.seq > files[j].seq {
+			latest[key] = i
+		}
+	}
+	for i := range files {
+		f := &files[i]
+		if f.kind != CounterDataFile || latest[pidKey{f.hash, f.pid}] == i {
+			continue
+		}
+		if cfg.Warn {
+			fileWarning(f.path, "skipping superseded counter file")
+		}
+		st.SupersededCounterFiles = append(st.SupersededCounterFiles, f.path)
+		f.kind = NonCoverageFile
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PodDiff describes the differences between two pod lists, as
+// computed by DiffPodLists. All lists are sorted.
+type PodDiff struct {
+	// AddedMetaHashes lists the meta-data hashes of pods that appear
+	// only in the new pod list.
+	AddedMetaHashes []string
+
+	// RemovedMetaHashes lists the meta-data hashes of pods that
+	// appear only in the old pod list.
+	RemovedMetaHashes []string
+
+	// Changed describes the pods that appear in both lists but whose
+	// counter data files differ, in order of meta-data hash.
+	Changed []PodChange
+}
+
+// PodChange describes the differences in the counter data files of a
+// pod that appears in both pod lists handed to DiffPodLists. Counter
+// data files are identified by base name.
+type PodChange struct {
+	MetaHash            string
+	AddedCounterFiles   []string
+	RemovedCounterFiles []string
+}
+
+// Empty reports whether the diff records no differences.
+func (d *PodDiff) Empty() bool {
+	return len(d.AddedMetaHashes) == 0 && len(d.RemovedMetaHashes) == 0 && len(d.Changed) == 0
+}
+
+// DiffPodLists compares the pod lists 'old' and 'new', which would
+// typically come from two runs of the same set of tests, and reports
+// which pods were added or removed and which counter data files were
+// added to or removed from the pods common to both. Only file names
+// are compared: pods are matched up by the meta-data hash in the name
+// of their meta-data file (as recognized by DefaultClassifier, or the
+// base name of the file if it is not recognized), and counter data
+// files by base name. The contents of the files are not examined.
+func DiffPodLists(old, new []Pod) PodDiff {
+	oldPods := podsByHash(old)
+	newPods := podsByHash(new)
+
+	var d PodDiff
+	for h := range newPods {
+		if _, ok := oldPods[h]; !ok {
+			d.AddedMetaHashes = append(d.AddedMetaHashes, h)
+		}
+	}
+	var common []string
+	for h := range oldPods {
+		if _, ok := newPods[h]; ok {
+			common = append(common, h)
+		} else {
+			d.RemovedMetaHashes = append(d.RemovedMetaHashes, h)
+		}
+	}
+	sort.Strings(d.AddedMetaHashes)
+	sort.Strings(d.RemovedMetaHashes)
+	sort.Strings(common)
+
+	for _, h := range common {
+		oldFiles := counterBaseNames(oldPods[h])
+		newFiles := counterBaseNames(newPods[h])
+		c := PodChange{MetaHash: h}
+		for f := range newFiles {
+			if !oldFiles[f] {
+				c.AddedCounterFiles = append(c.AddedCounterFiles, f)
+			}
+		}
+		for f := range oldFiles {
+			if !newFiles[f] {
+				c.RemovedCounterFiles = append(c.RemovedCounterFiles, f)
+			}
+		}
+		if len(c.AddedCounterFiles) == 0 && len(c.RemovedCounterFiles) == 0 {
+			continue
+		}
+		sort.Strings(c.AddedCounterFiles)
+		sort.Strings(c.RemovedCounterFiles)
+		d.Changed = append(d.Changed, c)
+	}
+	return d
+}
+
+// podsByHash returns a map from meta-data hash to the pods in
+// 'podlist' with that hash.
+func podsByHash(podlist []Pod) map[string][]*Pod {
+	m := make(map[string][]*Pod, len(podlist))
+	for i := range podlist {
+		p := &podlist[i]
+		h := podMetaHash(p)
+		m[h] = append(m[h], p)
+	}
+	return m
+}
+
+// podMetaHash returns the meta-data hash of pod 'p', taken from the
+// name of its meta-data file.
+func podMetaHash(p *Pod) string {
+	name := filepath.Base(p.MetaFile)
+	base := name
+	if p.MetaCompressed {
+		base = strings.TrimSuffix(base, gzipSuffix)
+	}
+	if kind, hash, _, _ := DefaultClassifier.Classify(base); kind == MetaDataFile {
+		return hash
+	}
+	return name
+}
+
+// counterBaseNames returns the set of base names of the counter data
+// files in 'podlist'.
+func counterBaseNames(podlist []*Pod) map[string]bool {
+	m := make(map[string]bool)
+	for _, p := range podlist {
+		for _, f := range p.CounterDataFiles {
+			m[filepath.Base(f)] = true
+		}
+	}
+	return m
+}
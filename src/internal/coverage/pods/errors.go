@@ -0,0 +1,83 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// ErrNotADirectory is the underlying error (wrapped in an
+// *fs.PathError naming the path, itself wrapped in a *DirReadError)
+// returned when an input directory passed to collection is not a
+// directory.
+var ErrNotADirectory = errors.New("input path is not a directory")
+
+// DirReadError is the error returned when collection fails because an
+// input directory (or one of its subdirectories, for a Recursive
+// collection) can't be read, or is not a directory.
+type DirReadError struct {
+	Dir string // the directory that couldn't be read
+	Err error  // the underlying error
+}
+
+func (e *DirReadError) Error() string {
+	err := e.Err
+	var pe *fs.PathError
+	if errors.As(err, &pe) && pe.Path == e.Dir {
+		// Don't repeat the directory name.
+		err = pe.Err
+	}
+	return fmt.Sprintf("reading directory %s: %v", e.Dir, err)
+}
+
+func (e *DirReadError) Unwrap() error { return e.Err }
+
+// FileParseError is the error returned when the contents of a
+// coverage file are malformed, as opposed to the file being
+// unreadable. Collection looks only at file names, so it comes from
+// functions that decode files, such as podsx.ValidateModeCompatibility.
+type FileParseError struct {
+	File string // the malformed file
+	Err  error  // what is wrong with it
+}
+
+func (e *FileParseError) Error() string {
+	return fmt.Sprintf("%s: malformed coverage data file: %v", e.File, e.Err)
+}
+
+func (e *FileParseError) Unwrap() error { return e.Err }
+
+// ErrNoPods is returned by collection with Config.ErrorOnEmpty set
+// when no pods are found.
+var ErrNoPods = errors.New("no coverage data files found")
+
+// PairingError is returned when Config.StrictPairing is set and some
+// coverage files could not be paired up.
+type PairingError struct {
+	// Counter data files with no corresponding meta-data file.
+	OrphanCounterFiles []string
+	// The meta-data hash of each element of OrphanCounterFiles.
+	OrphanHashes []string
+	// Meta-data files with no counter data files.
+	MetaFilesWithoutCounters []string
+}
+
+func (e *PairingError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("unpaired coverage data files:")
+	for k, f := range e.OrphanCounterFiles {
+		fmt.Fprintf(&sb, "\n\tcounter data file with no meta-data file: %s", f)
+		if k < len(e.OrphanHashes) {
+			fmt.Fprintf(&sb, " (meta-data hash %s)", e.OrphanHashes[k])
+		}
+	}
+	for _, f := range e.MetaFilesWithoutCounters {
+		fmt.Fprintf(&sb, "\n\tmeta-data file with no counter data files: %s", f)
+	}
+	return sb.String()
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// CollectPodsFromFS is similar to CollectPodsFromFiles, but collects
+// pods from the files in 'fsys' with the slash-separated paths
+// 'names', such as the members of a zip archive. Each directory
+// within 'fsys' plays the role of an input directory: counter data
+// files in the same directory share an origin, and origins are
+// numbered according to the sorted order of the directory names. The
+// file names recorded in the returned pods are taken from 'names'.
+func (cfg *Config) CollectPodsFromFS(fsys fs.FS, names []string) ([]Pod, error) {
+	if err := cfg.checkExcludeGlobs(); err != nil {
+		return nil, err
+	}
+	cl := cfg.classifier()
+	var files []covFile
+	for _, name := range names {
+		kind, hash, pid, seq, gz := cfg.classify(cl, path.Base(name))
+		if kind == NonCoverageFile {
+			continue
+		}
+		fi, err := fs.Stat(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, covFile{
+			path:    name,
+			kind:    kind,
+			hash:    hash,
+			pid:     pid,
+			seq:     seq,
+			stale:   cfg.isStale(fi.ModTime()),
+			gz:      gz,
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+		})
+	}
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if dir := path.Dir(f.path); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	origin := make(map[string]int, len(dirs))
+	for k, dir := range dirs {
+		origin[dir] = k
+	}
+	for i := range files {
+		files[i].origin = origin[path.Dir(files[i].path)]
+	}
+	readFile := func(name string) ([]byte, error) {
+		return fs.ReadFile(fsys, name)
+	}
+	return collectPodsImpl(files, cfg, readFile, nil, nil)
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PodGroup is a set of pods sharing a group key, as returned by
+// CollectPodGroups.
+type PodGroup struct {
+	Key  string
+	Pods []Pod
+}
+
+// CollectPodGroups collects pods from 'dirs' according to the
+// settings in 'cfg', as for CollectPods, and then groups them by the
+// key returned by cfg.GroupKey for each pod's meta-data file (by
+// default, the meta-data hash). Counter data files stay with the pod
+// of their meta-data file, and so belong to that pod's group. Groups
+// are returned in increasing order of key, and the pods of each group
+// in the order they were collected.
+func (cfg *Config) CollectPodGroups(dirs []string) ([]PodGroup, error) {
+	podlist, err := cfg.CollectPods(dirs)
+	if err != nil {
+		return nil, err
+	}
+	idx := make(map[string]int)
+	var groups []PodGroup
+	for _, p := range podlist {
+		key, err := cfg.groupKey(p.MetaFile)
+		if err != nil {
+			return nil, fmt.Errorf("grouping %s: %w", p.MetaFile, err)
+		}
+		k, ok := idx[key]
+		if !ok {
+			k = len(groups)
+			idx[key] = k
+			groups = append(groups, PodGroup{Key: key})
+		}
+		groups[k].Pods = append(groups[k].Pods, p)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups, nil
+}
+
+// groupKey returns the group key of the pod with meta-data file 'mf',
+// using cfg.GroupKey if set, and otherwise the meta-data hash parsed
+// from the file name, as it was when the file was classified.
+func (cfg *Config) groupKey(mf string) (string, error) {
+	if cfg.GroupKey != nil {
+		return cfg.GroupKey(mf)
+	}
+	name := filepath.Base(mf)
+	if cfg.NameRewriter != nil {
+		name = cfg.NameRewriter(name)
+	}
+	if cfg.Gzip {
+		name = strings.TrimSuffix(name, gzipSuffix)
+	}
+	kind, hash, _, _ := cfg.classifier().Classify(name)
+	if kind != MetaDataFile {
+		return "", fmt.Errorf("%s is not a meta-data file name", name)
+	}
+	return hash, nil
+}
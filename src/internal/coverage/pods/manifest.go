@@ -0,0 +1,106 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A manifest records the files making up a list of pods, so that a
+// later job can collect the same pods without scanning directories
+// again. It is a text file starting with the line given by
+// manifestHeader, followed by one line per file of the form
+//
+//	<origin> <quoted path>
+//
+// where <origin> is the origin of a counter data file (-1 for a
+// meta-data file) and <quoted path> is the path of the file quoted
+// as by strconv.Quote.
+const manifestHeader = "go coverage pod manifest v1"
+
+// WriteManifest writes a manifest listing the meta-data and counter
+// data files of the pods in 'podlist' to 'w', recording the origin
+// of each counter data file. The file names in the manifest can be
+// recovered with ReadManifest and passed to CollectPodsFromFiles, or
+// the manifest can be handed to Config.CollectPodsFromManifest to
+// rebuild the pods with their origins intact.
+func WriteManifest(w io.Writer, podlist []Pod) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, manifestHeader)
+	for _, p := range podlist {
+		fmt.Fprintf(bw, "-1 %s\n", strconv.Quote(p.MetaFile))
+		for k, f := range p.CounterDataFiles {
+			origin := -1
+			if k < len(p.Origins) {
+				origin = p.Origins[k]
+			}
+			fmt.Fprintf(bw, "%d %s\n", origin, strconv.Quote(f))
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadManifest reads a manifest written by WriteManifest from 'r' and
+// returns the names of the files it lists, in the order written.
+func ReadManifest(r io.Reader) ([]string, error) {
+	files, _, err := readManifest(r)
+	return files, err
+}
+
+// CollectPodsFromManifest is similar to CollectPodsFromFiles, but
+// collects pods from the files listed in a manifest written by
+// WriteManifest and read from 'r'. Counter data files are assigned
+// the origins recorded in the manifest.
+func (cfg *Config) CollectPodsFromManifest(r io.Reader) ([]Pod, error) {
+	if err := cfg.checkExcludeGlobs(); err != nil {
+		return nil, err
+	}
+	files, origins, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	cfiles, err := cfg.listedFiles(files, origins)
+	if err != nil {
+		return nil, err
+	}
+	return collectPodsImpl(cfiles, cfg, os.ReadFile, nil, nil)
+}
+
+// readManifest reads a manifest from 'r', returning the files it
+// lists and their origins.
+func readManifest(r io.Reader) (files []string, origins []int, err error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() || s.Text() != manifestHeader {
+		if err := s.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("not a coverage pod manifest")
+	}
+	for line := 2; s.Scan(); line++ {
+		o, q, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return nil, nil, fmt.Errorf("manifest line %d: missing file name", line)
+		}
+		origin, err := strconv.Atoi(o)
+		if err != nil || origin < -1 {
+			return nil, nil, fmt.Errorf("manifest line %d: bad origin %q", line, o)
+		}
+		f, err := strconv.Unquote(q)
+		if err != nil {
+			return nil, nil, fmt.Errorf("manifest line %d: bad file name %s", line, q)
+		}
+		files = append(files, f)
+		origins = append(origins, origin)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	return files, origins, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PodSlice attaches the methods of sort.Interface to []Pod, sorting
+// in increasing order of meta-data hash (for pods whose meta-data
+// file name can't be parsed, the base name of the file is used in
+// place of the hash).
+type PodSlice []Pod
+
+func (x PodSlice) Len() int           { return len(x) }
+func (x PodSlice) Less(i, j int) bool { return podMetaHash(&x[i]) < podMetaHash(&x[j]) }
+func (x PodSlice) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+// SortBy sorts the pods with the comparator 'less', keeping pods
+// that compare equal in their original order.
+func (x PodSlice) SortBy(less PodLess) {
+	sort.SliceStable(x, func(i, j int) bool { return less(&x[i], &x[j]) })
+}
+
+// PodLess is a comparator for pods, reporting whether 'a' sorts
+// before 'b'.
+type PodLess func(a, b *Pod) bool
+
+// ByMetaFile returns a comparator ordering pods by the path of their
+// meta-data file, the same order as OrderByMetaFile.
+func ByMetaFile() PodLess {
+	return func(a, b *Pod) bool {
+		return a.MetaFile < b.MetaFile
+	}
+}
+
+// ByCounterCount returns a comparator ordering pods by decreasing
+// number of counter data files, and then by meta-data file, the same
+// order as OrderByCounterFileCount.
+func ByCounterCount() PodLess {
+	return func(a, b *Pod) bool {
+		if na, nb := len(a.CounterDataFiles), len(b.CounterDataFiles); na != nb {
+			return na > nb
+		}
+		return a.MetaFile < b.MetaFile
+	}
+}
+
+// ByOrigin returns a comparator ordering pods by the smallest origin
+// of their counter data files, and then by meta-data file, so that
+// pods with data from the first input directory come first. Pods
+// with no counter data files sort last.
+func ByOrigin() PodLess {
+	minOrigin := func(p *Pod) (int, bool) {
+		if len(p.Origins) == 0 {
+			return 0, false
+		}
+		m := p.Origins[0]
+		for _, o := range p.Origins[1:] {
+			if o < m {
+				m = o
+			}
+		}
+		return m, true
+	}
+	return func(a, b *Pod) bool {
+		oa, oka := minOrigin(a)
+		ob, okb := minOrigin(b)
+		if oka != okb {
+			return oka
+		}
+		if oa != ob {
+			return oa < ob
+		}
+		return a.MetaFile < b.MetaFile
+	}
+}
+
+// Less returns the comparator giving the pod order 'o', for re-sorting
+// pods after they have been filtered or combined, or nil for
+// OrderByTotalBytes, which depends on file sizes that pods don't
+// record.
+func (o PodOrder) Less() PodLess {
+	switch o {
+	case OrderByMetaFile:
+		return ByMetaFile()
+	case OrderByCounterFileCount:
+		return ByCounterCount()
+	}
+	return nil
+}
+
+// bySize sorts pods by decreasing total file size, where sizes[k] is
+// the size of pods[k].
+type bySize struct {
+	pods  []Pod
+	sizes []int64
+}
+
+func (x bySize) Len() int           { return len(x.pods) }
+func (x bySize) Less(i, j int) bool { return x.sizes[i] > x.sizes[j] }
+func (x bySize) Swap(i, j int) {
+	x.pods[i], x.pods[j] = x.pods[j], x.pods[i]
+	x.sizes[i], x.sizes[j] = x.sizes[j], x.sizes[i]
+}
+
+// InApplyOrder returns the pod's counter data files in the order in
+// which tools should apply them when the result depends on it: by
+// emit sequence value, then process ID, then path. Sequence values
+// and process IDs are taken from the Sequences and ProcessIDs fields,
+// or, if those are not filled in, parsed from the file names using
+// DefaultClassifier. If the sequence value of any file is unknown,
+// because the fields are not filled in and its name can't be parsed,
+// the files are returned in path order instead and fellBack is true.
+func (p *Pod) InApplyOrder() (names []string, fellBack bool) {
+	type applyKey struct {
+		seq  int64
+		pid  int
+		path string
+	}
+	keys := make([]applyKey, len(p.CounterDataFiles))
+	n := len(p.CounterDataFiles)
+	for k, cdf := range p.CounterDataFiles {
+		key := &keys[k]
+		key.path = cdf
+		if len(p.Sequences) == n && len(p.ProcessIDs) == n {
+			key.seq, key.pid = p.Sequences[k], p.ProcessIDs[k]
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(cdf), gzipSuffix)
+		kind, _, pid, seq := DefaultClassifier.Classify(name)
+		if kind != CounterDataFile {
+			fellBack = true
+			break
+		}
+		key.seq, key.pid = seq, pid
+	}
+	if fellBack {
+		names = append([]string(nil), p.CounterDataFiles...)
+		sort.Strings(names)
+		return names, true
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := &keys[i], &keys[j]
+		if a.seq != b.seq {
+			return a.seq < b.seq
+		}
+		if a.pid != b.pid {
+			return a.pid < b.pid
+		}
+		return a.path < b.path
+	})
+	names = make([]string, n)
+	for k := range keys {
+		names[k] = keys[k].path
+	}
+	return names, false
+}
@@ -0,0 +1,437 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewPod returns a pod with meta-data file 'metaFile' and counter
+// data files 'counterFiles', without accessing the file system. The
+// base names of the files must be recognized by DefaultClassifier
+// (optionally with a ".gz" suffix, marking a compressed file), and
+// the counter data files must have the same meta-data hash as the
+// meta-data file; otherwise an error is returned. The process IDs and
+// sequence values of the pod are parsed from the counter data file
+// names, and all counter data files have origin 0.
+func NewPod(metaFile string, counterFiles ...string) (Pod, error) {
+	kind, hash, _, _, mgz := parseName(metaFile)
+	if kind != MetaDataFile {
+		return Pod{}, fmt.Errorf("%s is not a meta-data file name", metaFile)
+	}
+	n := len(counterFiles)
+	p := Pod{
+		MetaFile:          metaFile,
+		CounterDataFiles:  counterFiles,
+		Origins:           make([]int, n),
+		ProcessIDs:        make([]int, n),
+		Sequences:         make([]int64, n),
+		MetaCompressed:    mgz,
+		CounterCompressed: make([]bool, n),
+	}
+	for k, cdf := range counterFiles {
+		kind, chash, pid, seq, gz := parseName(cdf)
+		if kind != CounterDataFile {
+			return Pod{}, fmt.Errorf("%s is not a counter data file name", cdf)
+		}
+		if chash != hash {
+			return Pod{}, fmt.Errorf("counter data file %s has meta-data hash %s, but meta-data file %s has hash %s", cdf, chash, metaFile, hash)
+		}
+		p.ProcessIDs[k] = pid
+		p.Sequences[k] = seq
+		p.CounterCompressed[k] = gz
+	}
+	return p, nil
+}
+
+// parseName classifies the base name of 'path' with
+// DefaultClassifier, after removing any ".gz" suffix, which is
+// reported with 'gz'.
+func parseName(path string) (kind FileKind, hash string, pid int, seq int64, gz bool) {
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, gzipSuffix) {
+		name, gz = name[:len(name)-len(gzipSuffix)], true
+	}
+	kind, hash, pid, seq = DefaultClassifier.Classify(name)
+	return kind, hash, pid, seq, gz
+}
+
+// CounterFilesForOrigin returns the subset of the pod's counter data
+// files that came from the input directory with index 'origin'. The
+// result is empty (but not nil) if no counter data files came from
+// that directory.
+func (p *Pod) CounterFilesForOrigin(origin int) []string {
+	files := []string{}
+	for k, o := range p.Origins {
+		if o == origin {
+			files = append(files, p.CounterDataFiles[k])
+		}
+	}
+	return files
+}
+
+// OriginLabel returns the label of origin 'origin' of the pod's
+// counter data files, as recorded in OriginLabels. If the pod has no
+// label for the origin, the result is "origin N", N being the origin
+// itself.
+func (p *Pod) OriginLabel(origin int) string {
+	if l, ok := p.OriginLabels[origin]; ok {
+		return l
+	}
+	return fmt.Sprintf("origin %d", origin)
+}
+
+// CounterFileLabel returns the label of the origin of the pod's k-th
+// counter data file (see OriginLabel).
+func (p *Pod) CounterFileLabel(k int) string {
+	return p.OriginLabel(p.Origins[k])
+}
+
+// IsMultiOrigin reports whether the pod's counter data files came
+// from more than one origin (input directory), as is the case for a
+// pod merged from the output of several machines. It looks only at
+// the Origins field, and does not access the file system.
+func (p *Pod) IsMultiOrigin() bool {
+	for _, o := range p.Origins {
+		if o != p.Origins[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// NumProcesses returns the number of distinct processes that wrote
+// the pod's counter data files. Process IDs are taken from the
+// ProcessIDs field, or, if that is not filled in (for example, in a
+// pod constructed by hand), parsed from the names of the counter data
+// files using DefaultClassifier; files whose names can't be parsed
+// are not counted.
+func (p *Pod) NumProcesses() int {
+	seen := make(map[int]bool)
+	for _, pid := range p.processIDs() {
+		seen[pid] = true
+	}
+	return len(seen)
+}
+
+// PrimaryPid returns the process ID shared by a strict majority of
+// the pod's counter data files (typically all of them, for a
+// single-process run), with ok set to true. If there is no such
+// process ID, or the pod has no counter data files, ok is false.
+// Process IDs are found as for NumProcesses, and files whose names
+// can't be parsed are ignored.
+func (p *Pod) PrimaryPid() (pid int, ok bool) {
+	pids := p.processIDs()
+	count := make(map[int]int)
+	for _, id := range pids {
+		count[id]++
+		if 2*count[id] > len(pids) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// MissingPIDs returns the elements of ExpectedProcessIDs for which
+// the pod has no counter data file, in increasing order. Process IDs
+// are found as for NumProcesses.
+func (p *Pod) MissingPIDs() []int {
+	if len(p.ExpectedProcessIDs) == 0 {
+		return nil
+	}
+	have := make(map[int]bool)
+	for _, pid := range p.processIDs() {
+		have[pid] = true
+	}
+	var missing []int
+	for _, pid := range p.ExpectedProcessIDs {
+		if !have[pid] {
+			have[pid] = true // report duplicates once
+			missing = append(missing, pid)
+		}
+	}
+	sort.Ints(missing)
+	return missing
+}
+
+// processIDs returns the process ID of each of the pod's counter data
+// files, as described for NumProcesses.
+func (p *Pod) processIDs() []int {
+	if len(p.ProcessIDs) == len(p.CounterDataFiles) {
+		return p.ProcessIDs
+	}
+	var pids []int
+	for _, cdf := range p.CounterDataFiles {
+		name := strings.TrimSuffix(filepath.Base(cdf), gzipSuffix)
+		if kind, _, pid, _ := DefaultClassifier.Classify(name); kind == CounterDataFile {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// SplitByOrigin splits the pod into one pod per distinct origin of
+// its counter data files, in increasing order of origin. Each of the
+// resulting pods has the same meta-data file as 'p', and holds the
+// counter data files (and corresponding process IDs, sequence values
+// and compression flags) from a single origin, in their original order; its Origins
+// are all zero, and the label of the origin, if any, becomes the label
+// of origin zero. A pod with no counter data files is returned
+// unchanged, as the only element of the result.
+func (p *Pod) SplitByOrigin() []Pod {
+	if len(p.CounterDataFiles) == 0 {
+		return []Pod{*p}
+	}
+	origins := distinctSorted(p.Origins)
+	res := make([]Pod, 0, len(origins))
+	for _, o := range origins {
+		sp := Pod{
+			MetaFile:       p.MetaFile,
+			MetaCompressed: p.MetaCompressed,
+			Packages:       p.Packages,
+		}
+		if l, ok := p.OriginLabels[o]; ok {
+			sp.OriginLabels = map[int]string{0: l}
+		}
+		for k, po := range p.Origins {
+			if po != o {
+				continue
+			}
+			sp.CounterDataFiles = append(sp.CounterDataFiles, p.CounterDataFiles[k])
+			sp.Origins = append(sp.Origins, 0)
+			if p.ProcessIDs != nil {
+				sp.ProcessIDs = append(sp.ProcessIDs, p.ProcessIDs[k])
+			}
+			if p.Sequences != nil {
+				sp.Sequences = append(sp.Sequences, p.Sequences[k])
+			}
+			if p.CounterCompressed != nil {
+				sp.CounterCompressed = append(sp.CounterCompressed, p.CounterCompressed[k])
+			}
+		}
+		res = append(res, sp)
+	}
+	return res
+}
+
+// ValidatePod checks that the origins of the counter data files of
+// pod 'p' are valid indices into a list of 'numDirs' input
+// directories, and that there is one origin per counter data file.
+// Pods with unknown origins (-1) never pass.
+func ValidatePod(p Pod, numDirs int) error {
+	if len(p.Origins) != len(p.CounterDataFiles) {
+		return fmt.Errorf("pod %s: %d origins for %d counter data files", p.MetaFile, len(p.Origins), len(p.CounterDataFiles))
+	}
+	for k, o := range p.Origins {
+		if o < 0 || o >= numDirs {
+			return fmt.Errorf("pod %s: counter data file %s has origin %d, outside range [0,%d) of input directories", p.MetaFile, p.CounterDataFiles[k], o, numDirs)
+		}
+	}
+	return nil
+}
+
+// distinctSorted returns the distinct values in 'vals' in increasing
+// order.
+func distinctSorted(vals []int) []int {
+	var res []int
+	seen := make(map[int]bool)
+	for _, v := range vals {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+// Filter returns the pods in 'pods' for which 'keep' returns true,
+// in their original order. The result is a new slice; 'pods' is not
+// modified.
+func Filter(pods []Pod, keep func(Pod) bool) []Pod {
+	var res []Pod
+	for _, p := range pods {
+		if keep(p) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// RebasePods rewrites the paths of the meta-data and counter data
+// files of the pods in 'pods', which must all lie within the
+// directory 'oldRoot', to the same relative paths within 'newRoot'.
+// Paths are compared after cleaning, one path element at a time, so
+// "/a/bc" is not within "/a/b". If 'newRoot' is empty, the rewritten
+// paths are relative to 'oldRoot'. If any path is not within
+// 'oldRoot', an error naming it is returned and no pods are changed.
+func RebasePods(pods []Pod, oldRoot, newRoot string) error {
+	root := filepath.Clean(oldRoot)
+	prefix := dirPrefix(root)
+	rel := func(path string) (string, error) {
+		path = filepath.Clean(path)
+		if path == root {
+			return "", nil
+		}
+		if !strings.HasPrefix(path, prefix) {
+			return "", fmt.Errorf("%s is not within %s", path, oldRoot)
+		}
+		return path[len(prefix):], nil
+	}
+	// Check every path before changing any.
+	for i := range pods {
+		p := &pods[i]
+		if _, err := rel(p.MetaFile); err != nil {
+			return err
+		}
+		for _, cdf := range p.CounterDataFiles {
+			if _, err := rel(cdf); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range pods {
+		p := &pods[i]
+		r, _ := rel(p.MetaFile)
+		p.MetaFile = filepath.Join(newRoot, r)
+		for k, cdf := range p.CounterDataFiles {
+			r, _ := rel(cdf)
+			p.CounterDataFiles[k] = filepath.Join(newRoot, r)
+		}
+	}
+	return nil
+}
+
+// CountMultiOriginPods returns the number of pods in 'pods' whose
+// counter data files came from more than one origin.
+func CountMultiOriginPods(pods []Pod) int {
+	n := 0
+	for i := range pods {
+		if pods[i].IsMultiOrigin() {
+			n++
+		}
+	}
+	return n
+}
+
+// TotalCounterFiles returns the total number of counter data files in
+// 'pods'. Orphaned counter data files, which belong to no pod, are not
+// counted; collection records them in Stats.OrphanCounterFiles.
+func TotalCounterFiles(pods []Pod) int {
+	n := 0
+	for i := range pods {
+		n += len(pods[i].CounterDataFiles)
+	}
+	return n
+}
+
+// CounterRef identifies a counter data file within a pod, as
+// returned by GroupByProcess.
+type CounterRef struct {
+	CounterFile string
+	MetaFile    string
+	Origin      int // -1 if the pod has no origins recorded
+}
+
+// GroupByProcess returns the counter data files in 'pods' grouped by
+// the ID of the process that wrote them, regardless of which pod (and
+// so which program) they belong to. Process IDs are determined as for
+// Pod.NumProcesses; files whose process ID can't be determined are
+// grouped under -1. Within each group, files are listed in the order
+// of 'pods' and then of each pod's counter data files.
+func GroupByProcess(pods []Pod) map[int][]CounterRef {
+	m := make(map[int][]CounterRef)
+	for i := range pods {
+		p := &pods[i]
+		for k, cdf := range p.CounterDataFiles {
+			pid := -1
+			if len(p.ProcessIDs) == len(p.CounterDataFiles) {
+				pid = p.ProcessIDs[k]
+			} else {
+				name := strings.TrimSuffix(filepath.Base(cdf), gzipSuffix)
+				if kind, _, cpid, _ := DefaultClassifier.Classify(name); kind == CounterDataFile {
+					pid = cpid
+				}
+			}
+			origin := -1
+			if k < len(p.Origins) {
+				origin = p.Origins[k]
+			}
+			m[pid] = append(m[pid], CounterRef{CounterFile: cdf, MetaFile: p.MetaFile, Origin: origin})
+		}
+	}
+	return m
+}
+
+// ErrNoCounterFiles is returned by Pod.TimeSpan for a pod with no
+// counter data files.
+var ErrNoCounterFiles = errors.New("pod has no counter data files")
+
+// TimeSpan returns the earliest and latest modification times of the
+// pod's counter data files, which it reads from the file system. If
+// the pod has no counter data files, TimeSpan returns zero times and
+// ErrNoCounterFiles.
+func (p *Pod) TimeSpan() (first, last time.Time, err error) {
+	if len(p.CounterDataFiles) == 0 {
+		return time.Time{}, time.Time{}, ErrNoCounterFiles
+	}
+	for k, cdf := range p.CounterDataFiles {
+		fi, err := os.Stat(cdf)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		mt := fi.ModTime()
+		if k == 0 || mt.Before(first) {
+			first = mt
+		}
+		if k == 0 || mt.After(last) {
+			last = mt
+		}
+	}
+	return first, last, nil
+}
+
+// Factors used by EstimateDecodeBytes. Decoding a counter data file
+// holds the file's contents in memory alongside the decoded counter
+// values, hence decodeOverhead; gzipExpansion is a rough compression
+// ratio for compressed counter data files.
+const (
+	decodeOverhead = 2
+	gzipExpansion  = 4
+)
+
+// EstimateDecodeBytes returns an estimate of the peak memory, in
+// bytes, needed to decode the pod: the size of its meta-data file plus
+// the sizes of its counter data files times a small overhead factor
+// (larger for compressed files). File sizes are read from the file
+// system. The estimate is a heuristic, not a bound.
+func (p *Pod) EstimateDecodeBytes() (int64, error) {
+	fi, err := os.Stat(p.MetaFile)
+	if err != nil {
+		return 0, err
+	}
+	total := fi.Size()
+	if p.MetaCompressed {
+		total *= gzipExpansion
+	}
+	for k, cdf := range p.CounterDataFiles {
+		fi, err := os.Stat(cdf)
+		if err != nil {
+			return 0, err
+		}
+		n := fi.Size() * decodeOverhead
+		if k < len(p.CounterCompressed) && p.CounterCompressed[k] {
+			n *= gzipExpansion
+		}
+		total += n
+	}
+	return total, nil
+}
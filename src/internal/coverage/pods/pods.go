@@ -6,12 +6,11 @@ package pods
 
 import (
 	"fmt"
-	"internal/coverage"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
+	"time"
 )
 
 // Pod encapsulates a set of files emitted during the executions of a
@@ -26,12 +25,37 @@ import (
 // index of the originating directory for the corresponding counter
 // data file (within the slice of input dirs handed to CollectPods).
 // The ProcessIDs field will be populated with the process ID of each
-// data file in the CounterDataFiles slice.
+// data file in the CounterDataFiles slice, and the Sequences field
+// with its emit sequence value (the trailing numeric field of the
+// file name).
+//
+// CounterDataFiles and the slices parallel to it are sorted by path;
+// see InApplyOrder for the order in which the files were written.
+// Origins has one element per counter data file in pods produced by
+// this package, -1 if the originating directory is unknown (as for
+// CollectPodsFromFiles). MetaCompressed and CounterCompressed record
+// which files are gzip-compressed (see Config.Gzip). OriginLabels
+// labels the origins of pods collected from directories (see
+// OriginLabel), and ExpectedProcessIDs holds the process IDs from
+// Config.VerifyExpectedPIDs (see MissingPIDs).
+//
+// Packages lists the import paths of the packages described by the
+// meta-data file, in the order they appear there. Listing them means
+// decoding the meta-data file, which this package (imported by the
+// runtime) does not do, so it is only filled in by
+// podsx.CollectPodsWithPackages.
 type Pod struct {
-	MetaFile         string
-	CounterDataFiles []string
-	Origins          []int
-	ProcessIDs       []int
+	MetaFile          string
+	CounterDataFiles  []string
+	Origins           []int
+	ProcessIDs        []int
+	Sequences         []int64
+	MetaCompressed    bool
+	CounterCompressed []bool
+	OriginLabels      map[int]string
+	Packages          []string
+
+	ExpectedProcessIDs []int
 }
 
 // CollectPods visits the files contained within the directories in
@@ -47,42 +71,115 @@ type Pod struct {
 // corresponding meta-data file). If "warn" is true, CollectPods will
 // issue warnings to stderr when it encounters non-fatal problems (for
 // orphans or a directory with no meta-data files).
+//
+// Input directories that are the same after cleaning with
+// filepath.Clean (for example "o1", "./o1" and "o1/") are read only
+// once; counter data files from such a directory are given the index
+// of its first appearance in 'dirs' as their origin. Names that refer
+// to the same directory by way of symbolic links are treated as
+// separate directories unless Config.ResolveSymlinks is set.
 func CollectPods(dirs []string, warn bool) ([]Pod, error) {
-	files := []string{}
-	dirIndices := []int{}
-	for k, dir := range dirs {
-		dents, err := os.ReadDir(dir)
-		if err != nil {
-			return nil, err
-		}
-		for _, e := range dents {
-			if e.IsDir() {
-				continue
-			}
-			files = append(files, filepath.Join(dir, e.Name()))
-			dirIndices = append(dirIndices, k)
-		}
-	}
-	return collectPodsImpl(files, dirIndices, warn), nil
+	cfg := Config{Warn: warn}
+	return cfg.CollectPods(dirs)
 }
 
 // CollectPodsFromFiles functions the same as "CollectPods" but
 // operates on an explicit list of files instead of a directory.
 func CollectPodsFromFiles(files []string, warn bool) []Pod {
-	return collectPodsImpl(files, nil, warn)
+	cfg := Config{Warn: warn}
+	// With no limit on counter files per pod, collection can't fail.
+	pods, _ := cfg.CollectPodsFromFiles(files)
+	return pods
 }
 
-type fileWithAnnotations struct {
-	file   string
-	origin int
+// covFile records a coverage-related file along with the
+// information parsed from its name.
+type covFile struct {
+	path   string
+	kind   FileKind
+	hash   string
 	pid    int
+	seq    int64
+	origin int  // index of originating dir, or -1 if unknown
+	stale  bool // older than Config.MinModTime
+	gz     bool // gzip-compressed (see Config.Gzip)
+	size   int64
+	// modification time, only recorded if Config.needFileInfo
+	modTime time.Time
 }
 
 type protoPod struct {
 	mf       string
-	elements []fileWithAnnotations
+	hash     string
+	mfgz     bool
+	mfsize   int64
+	elements []covFile
+}
+
+// limitCounterFiles implements cfg.GlobalMaxCounterFiles for the
+// pods 'protos', which are sorted by meta-data file name and whose
+// elements are sorted, recording skipped files in 'st'.
+func (cfg *Config) limitCounterFiles(protos []protoPod, st *Stats) {
+	max := cfg.GlobalMaxCounterFiles
+	total := 0
+	for _, p := range protos {
+		total += len(p.elements)
+	}
+	if total <= max {
+		return
+	}
+	// Take files round-robin until the limit is reached. Since there
+	// are more files than the limit, this stops before any pod runs
+	// out of files it could contribute.
+	keep := make([]int, len(protos))
+	n := 0
+	for j := 0; n < max; j++ {
+		for k := range protos {
+			if n == max {
+				break
+			}
+			if j < len(protos[k].elements) {
+				keep[k]++
+				n++
+			}
+		}
+	}
+	if cfg.Warn {
+		warning("keeping %d of %d counter data files (limit set by GlobalMaxCounterFiles)", max, total)
+	}
+	for k := range protos {
+		p := &protos[k]
+		for _, e := range p.elements[keep[k]:] {
+			cfg.logf("%s: dropped, total exceeds %d counter data files", e.path, max)
+			st.TruncatedCounterFiles = append(st.TruncatedCounterFiles, e.path)
+		}
+		p.elements = p.elements[:keep[k]]
+	}
+}
+
+// dirHash identifies a meta-data hash within a directory, for
+// Config.StrictSameDir.
+type dirHash struct {
+	dir, hash string
+}
+
+// collectBuffers holds working storage for collectPodsImpl, reused
+// across collections by a Collector.
+type collectBuffers struct {
+	files    []covFile
+	podIdx   map[string]int
+	protos   []protoPod
+	counts   []int
+	elements []covFile
 }
 
+// byPath sorts a slice of covFile by path name.
+type byPath []covFile
+
+func (x byPath) Len() int           { return len(x) }
+func (x byPath) Less(i, j int) bool { return x[i].path < x[j].path }
+func (x byPath) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
 // collectPodsImpl examines the specified list of files and picks out
 // subsets that correspond to coverage pods. The first stage in this
 // process is collecting a set { M1, M2, ... MN } where each M_k is a
@@ -116,75 +213,372 @@ type protoPod struct {
 // first pod (with meta-file M1) will have four counter data files
 // (C1, C2, C3, C4) and the second pod will have two counter data files
 // (C5, C6).
-func collectPodsImpl(files []string, dirIndices []int, warn bool) []Pod {
-	metaRE := regexp.MustCompile(fmt.Sprintf(`^%s\.(\S+)$`, coverage.MetaFilePref))
-	mm := make(map[string]protoPod)
-	for _, f := range files {
-		base := filepath.Base(f)
-		if m := metaRE.FindStringSubmatch(base); m != nil {
-			tag := m[1]
-			// We need to allow for the possibility of duplicate
-			// meta-data files. If we hit this case, use the
-			// first encountered as the canonical version.
-			if _, ok := mm[tag]; !ok {
-				mm[tag] = protoPod{mf: f}
-			}
-			// FIXME: should probably check file length and hash here for
-			// the duplicate.
-		}
-	}
-	counterRE := regexp.MustCompile(fmt.Sprintf(coverage.CounterFileRegexp, coverage.CounterFilePref))
-	for k, f := range files {
-		base := filepath.Base(f)
-		if m := counterRE.FindStringSubmatch(base); m != nil {
-			tag := m[1] // meta hash
-			pid, err := strconv.Atoi(m[2])
-			if err != nil {
-				continue
+//
+// Once the counter data files of a pod are sorted, the pod is checked
+// against cfg.MaxCounterFilesPerPod (if set), and either trimmed or
+// rejected with an error depending on cfg.CounterFileLimitPolicy.
+//
+// If cfg.VerifyMetaFiles is set, duplicate meta-data files (M2 above)
+// are read with 'readFile' and compared against the meta-data file
+// chosen for the pod.
+//
+// Working storage is taken from 'buf' if it is non-nil, and left there
+// for reuse by a later call. If 'labels' is non-nil, it holds the label
+// of each origin, recorded in the OriginLabels field of each pod.
+func collectPodsImpl(files []covFile, cfg *Config, readFile func(name string) ([]byte, error), buf *collectBuffers, labels []string) ([]Pod, error) {
+	if buf == nil {
+		buf = new(collectBuffers)
+	}
+	var st Stats
+	if cfg.Stats != nil {
+		defer func() { *cfg.Stats = st }()
+	}
+	warn := cfg.Warn
+
+	if cfg.Logf != nil {
+		for i := range files {
+			f := &files[i]
+			cfg.Logf("%s: classified as %v file, hash %s, origin %d", f.path, f.kind, f.hash, f.origin)
+		}
+	}
+
+	// Set aside stale files, remembering the hashes of stale
+	// meta-data files if their counter data files are to be skipped
+	// as well.
+	var staleMetas map[string]bool
+	for i := range files {
+		f := &files[i]
+		if !f.stale {
+			continue
+		}
+		if warn {
+			fileWarning(f.path, "skipping stale %s file", f.kind.base())
+		}
+		cfg.logf("%s: skipped as stale", f.path)
+		st.StaleFiles = append(st.StaleFiles, f.path)
+		if f.kind == MetaDataFile && cfg.DropStaleMetaCounters {
+			if staleMetas == nil {
+				staleMetas = make(map[string]bool)
 			}
-			if v, ok := mm[tag]; ok {
-				idx := -1
-				if dirIndices != nil {
-					idx = dirIndices[k]
-				}
-				fo := fileWithAnnotations{file: f, origin: idx, pid: pid}
-				v.elements = append(v.elements, fo)
-				mm[tag] = v
-			} else {
-				if warn {
-					warning("skipping orphaned counter file: %s", f)
+			staleMetas[f.hash] = true
+		}
+		f.kind = NonCoverageFile
+	}
+
+	if cfg.CounterDedup != DedupAll {
+		cfg.dedupCounterFiles(files, &st)
+	} else if warn || cfg.Stats != nil {
+		cfg.findDuplicateCounterFiles(files, &st)
+	}
+	if cfg.KeepLatestPerPID {
+		cfg.keepLatestPerPID(files, &st)
+	}
+
+	nmeta := 0
+	for i := range files {
+		if files[i].kind == MetaDataFile {
+			nmeta++
+		}
+	}
+
+	// Create a proto-pod for each distinct meta-data hash. We need to
+	// allow for the possibility of duplicate meta-data files. If we
+	// hit this case, use the first encountered as the canonical
+	// version, checking the others against it if requested.
+	podIdx := buf.podIdx
+	if podIdx == nil {
+		podIdx = make(map[string]int, nmeta)
+		buf.podIdx = podIdx
+	} else {
+		for h := range podIdx {
+			delete(podIdx, h)
+		}
+	}
+	protos := buf.protos[:0]
+	defer func() { buf.protos = protos[:0] }()
+	var metaContents map[string][]byte
+	var metaDirs map[dirHash]bool
+	for i := range files {
+		f := &files[i]
+		if f.kind == MalformedMetaDataFile {
+			if warn {
+				fileWarning(f.path, "skipping meta-data file with malformed name")
+			}
+			st.MalformedMetaNames = append(st.MalformedMetaNames, f.path)
+			continue
+		}
+		if f.kind == MalformedCounterDataFile {
+			if warn {
+				if cfg.StrictCounterHashes && f.hash != "" {
+					fileWarning(f.path, "skipping counter file with malformed meta-data hash %q", f.hash)
+				} else {
+					fileWarning(f.path, "skipping file with malformed counter file name")
 				}
 			}
+			st.MalformedCounterNames = append(st.MalformedCounterNames, f.path)
+			continue
+		}
+		if f.kind != MetaDataFile {
+			continue
+		}
+		if cfg.StrictSameDir {
+			if metaDirs == nil {
+				metaDirs = make(map[dirHash]bool)
+			}
+			metaDirs[dirHash{filepath.Dir(f.path), f.hash}] = true
+		}
+		k, ok := podIdx[f.hash]
+		if !ok {
+			cfg.logf("%s: starts pod for hash %s", f.path, f.hash)
+			podIdx[f.hash] = len(protos)
+			protos = append(protos, protoPod{mf: f.path, hash: f.hash, mfgz: f.gz, mfsize: f.size})
+			continue
+		}
+		cfg.logf("%s: duplicate of meta-data file %s", f.path, protos[k].mf)
+		if !cfg.VerifyMetaFiles || f.gz != protos[k].mfgz {
+			continue
+		}
+		want, ok := metaContents[f.hash]
+		if !ok {
+			var err error
+			if want, err = readFile(protos[k].mf); err != nil {
+				return nil, err
+			}
+			if metaContents == nil {
+				metaContents = make(map[string][]byte)
+			}
+			metaContents[f.hash] = want
+		}
+		got, err := readFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+		if string(got) != string(want) {
+			if warn {
+				fileWarning(f.path, "meta-data file differs from %s, which has the same hash", protos[k].mf)
+			}
+			st.MismatchedMetaFiles = append(st.MismatchedMetaFiles, f.path)
+		}
+	}
+
+	// Count the counter data files that belong to each pod, so that
+	// the elements of all pods can be carved out of a single slice.
+	counts := buf.counts[:0]
+	for range protos {
+		counts = append(counts, 0)
+	}
+	buf.counts = counts
+	total := 0
+	for i := range files {
+		f := &files[i]
+		if f.kind != CounterDataFile {
+			continue
+		}
+		k, ok := podIdx[f.hash]
+		if ok && cfg.StrictSameDir && !metaDirs[dirHash{filepath.Dir(f.path), f.hash}] {
+			f.kind = NonCoverageFile
+			if cfg.orphanIgnored(f.hash) {
+				continue
+			}
+			if err := cfg.orphan(f, &st, "skipping counter file with no meta-data file in the same directory"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if ok {
+			cfg.logf("%s: grouped under hash %s with meta-data file %s", f.path, f.hash, protos[k].mf)
+			counts[k]++
+			total++
+		} else if staleMetas[f.hash] {
+			if warn {
+				fileWarning(f.path, "skipping counter file with stale meta-data file")
+			}
+			cfg.logf("%s: skipped, meta-data file is stale", f.path)
+			st.StaleFiles = append(st.StaleFiles, f.path)
+		} else if !cfg.orphanIgnored(f.hash) {
+			if err := cfg.orphan(f, &st, "skipping orphaned counter file"); err != nil {
+				return nil, err
+			}
+		} else {
+			cfg.logf("%s: orphan ignored for hash %s", f.path, f.hash)
 		}
 	}
-	if len(mm) == 0 {
+	if cfg.StrictPairing {
+		var unpaired []string
+		for k, p := range protos {
+			if counts[k] == 0 {
+				unpaired = append(unpaired, p.mf)
+			}
+		}
+		if len(unpaired) != 0 || len(st.OrphanCounterFiles) != 0 {
+			sort.Strings(unpaired)
+			return nil, &PairingError{
+				OrphanCounterFiles:       st.OrphanCounterFiles,
+				OrphanHashes:             cfg.orphanHashes(st.OrphanCounterFiles),
+				MetaFilesWithoutCounters: unpaired,
+			}
+		}
+	}
+	if len(protos) == 0 {
 		if warn {
 			warning("no coverage data files found")
 		}
-		return nil
+		if cfg.ErrorOnEmpty {
+			return nil, ErrNoPods
+		}
+		return nil, nil
+	}
+	elements := buf.elements[:0]
+	if cap(elements) < total {
+		elements = make([]covFile, 0, total)
+		buf.elements = elements
+	}
+	for k := range protos {
+		n := len(elements)
+		protos[k].elements = elements[n : n : n+counts[k]]
+		elements = elements[:n+counts[k]]
+	}
+	for i := range files {
+		f := &files[i]
+		if f.kind != CounterDataFile {
+			continue
+		}
+		if k, ok := podIdx[f.hash]; ok {
+			protos[k].elements = append(protos[k].elements, *f)
+		}
 	}
-	pods := make([]Pod, 0, len(mm))
-	for _, p := range mm {
-		sort.Slice(p.elements, func(i, j int) bool {
-			return p.elements[i].file < p.elements[j].file
-		})
+
+	sort.Slice(protos, func(i, j int) bool {
+		return protos[i].mf < protos[j].mf
+	})
+	for k := range protos {
+		p := &protos[k]
+		sort.Sort(byPath(p.elements))
+		if max := cfg.MaxCounterFilesPerPod; max > 0 && len(p.elements) > max {
+			if cfg.CounterFileLimitPolicy == FailOnCounterFileLimit {
+				return nil, fmt.Errorf("pod for meta-data file %s has %d counter data files, exceeding limit of %d", p.mf, len(p.elements), max)
+			}
+			if warn {
+				fileWarning(p.mf, "pod has %d counter data files, keeping only the first %d", len(p.elements), max)
+			}
+			for _, e := range p.elements[max:] {
+				cfg.logf("%s: dropped, pod exceeds %d counter data files", e.path, max)
+				st.TruncatedCounterFiles = append(st.TruncatedCounterFiles, e.path)
+			}
+			p.elements = p.elements[:max]
+		}
+	}
+	if cfg.GlobalMaxCounterFiles > 0 {
+		cfg.limitCounterFiles(protos, &st)
+	}
+	pods := make([]Pod, 0, len(protos))
+	cdfs := make([]string, total)
+	origins := make([]int, total)
+	pids := make([]int, total)
+	seqs := make([]int64, total)
+	gzs := make([]bool, total)
+	off := 0
+	for _, p := range protos {
+		n := len(p.elements)
 		pod := Pod{
-			MetaFile:         p.mf,
-			CounterDataFiles: make([]string, 0, len(p.elements)),
-			Origins:          make([]int, 0, len(p.elements)),
-			ProcessIDs:       make([]int, 0, len(p.elements)),
+			MetaFile:          p.mf,
+			CounterDataFiles:  cdfs[off : off+n : off+n],
+			Origins:           origins[off : off+n : off+n],
+			ProcessIDs:        pids[off : off+n : off+n],
+			Sequences:         seqs[off : off+n : off+n],
+			MetaCompressed:    p.mfgz,
+			CounterCompressed: gzs[off : off+n : off+n],
+		}
+		if cfg.ForwardSlashes {
+			pod.MetaFile = forwardSlashes(pod.MetaFile, filepath.Separator)
+		}
+		for k, e := range p.elements {
+			pod.CounterDataFiles[k] = e.path
+			if cfg.ForwardSlashes {
+				pod.CounterDataFiles[k] = forwardSlashes(e.path, filepath.Separator)
+			}
+			pod.Origins[k] = e.origin
+			if labels != nil && e.origin >= 0 && e.origin < len(labels) {
+				if pod.OriginLabels == nil {
+					pod.OriginLabels = make(map[int]string)
+				}
+				pod.OriginLabels[e.origin] = labels[e.origin]
+			}
+			pod.ProcessIDs[k] = e.pid
+			pod.Sequences[k] = e.seq
+			pod.CounterCompressed[k] = e.gz
 		}
-		for _, e := range p.elements {
-			pod.CounterDataFiles = append(pod.CounterDataFiles, e.file)
-			pod.Origins = append(pod.Origins, e.origin)
-			pod.ProcessIDs = append(pod.ProcessIDs, e.pid)
+		off += n
+		if want, ok := cfg.VerifyExpectedPIDs[p.hash]; ok {
+			pod.ExpectedProcessIDs = want
+			if missing := pod.MissingPIDs(); len(missing) != 0 {
+				cfg.logf("%s: no counter data files for expected pids %v", p.mf, missing)
+			}
 		}
 		pods = append(pods, pod)
 	}
-	sort.Slice(pods, func(i, j int) bool {
-		return pods[i].MetaFile < pods[j].MetaFile
-	})
-	return pods
+
+	// The pods are in meta-data file order; reorder them if asked.
+	if less := cfg.OrderPodsBy.Less(); less != nil {
+		PodSlice(pods).SortBy(less)
+	} else if cfg.OrderPodsBy == OrderByTotalBytes {
+		sizes := make([]int64, len(protos))
+		for k, p := range protos {
+			sizes[k] = p.mfsize
+			for _, e := range p.elements {
+				sizes[k] += e.size
+			}
+		}
+		sort.Stable(bySize{pods, sizes})
+	}
+	if cfg.OnPod != nil {
+		for _, pod := range pods {
+			if err := cfg.OnPod(pod); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pods, nil
+}
+
+// orphan records the orphaned counter data file 'f' in 'st', warning
+// about it with message 'msg' if warnings are enabled, and passes it
+// to cfg.OnOrphan if set.
+func (cfg *Config) orphan(f *covFile, st *Stats, msg string) error {
+	cfg.logf("%s: orphan: %s", f.path, msg)
+	if cfg.Warn {
+		fileWarning(f.path, msg)
+	}
+	st.OrphanCounterFiles = append(st.OrphanCounterFiles, f.path)
+	if cfg.OnOrphan != nil {
+		return cfg.OnOrphan(f.path, f.origin, f.pid)
+	}
+	return nil
+}
+
+// logf passes a trace message to cfg.Logf, if set.
+func (cfg *Config) logf(format string, args ...interface{}) {
+	if cfg.Logf != nil {
+		cfg.Logf(format, args...)
+	}
+}
+
+// fileWarning issues a warning about the file 'path', in the form
+// "<dir>: <message>: <base name>". A base name containing control
+// characters is quoted.
+func fileWarning(path string, s string, a ...interface{}) {
+	warning("%s: %s: %s", filepath.Dir(path), fmt.Sprintf(s, a...), printableName(filepath.Base(path)))
+}
+
+// printableName returns 'name', quoted as with strconv.Quote if it
+// contains control characters.
+func printableName(name string) string {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < ' ' || c == 0x7f {
+			return strconv.Quote(name)
+		}
+	}
+	return name
 }
 
 func warning(s string, a ...interface{}) {
@@ -5,15 +5,25 @@
 package pods_test
 
 import (
+	"bytes"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"internal/coverage"
 	"internal/coverage/pods"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestPodCollection(t *testing.T) {
@@ -36,16 +46,12 @@ func TestPodCollection(t *testing.T) {
 	}
 
 	mkmeta := func(dir string, tag string) string {
-		hash := md5.Sum([]byte(tag))
-		fn := fmt.Sprintf("%s.%x", coverage.MetaFilePref, hash)
-		return mkfile(dir, fn)
+		return mkfile(dir, metaName(tag))
 	}
 
 	mkcounter := func(dir string, tag string, nt int) string {
-		hash := md5.Sum([]byte(tag))
 		dummyPid := int(42)
-		fn := fmt.Sprintf(coverage.CounterFileTempl, coverage.CounterFilePref, hash, dummyPid, nt)
-		return mkfile(dir, fn)
+		return mkfile(dir, counterName(tag, dummyPid, int64(nt)))
 	}
 
 	trim := func(path string) string {
@@ -58,11 +64,7 @@ func TestPodCollection(t *testing.T) {
 	podToString := func(p pods.Pod) string {
 		rv := trim(p.MetaFile) + " [\n"
 		for k, df := range p.CounterDataFiles {
-			rv += trim(df)
-			if p.Origins != nil {
-				rv += fmt.Sprintf(" o:%d", p.Origins[k])
-			}
-			rv += "\n"
+			rv += trim(df) + fmt.Sprintf(" o:%d", p.Origins[k]) + "\n"
 		}
 		return rv + "]"
 	}
@@ -136,8 +138,3044 @@ o2/covcounters.aaf2f89992379705dac844c0a2a1d45f.42.3 o:1
 	if runtime.GOOS == "linux" {
 		dbad := "/dev/null"
 		_, err = pods.CollectPods([]string{dbad}, true)
-		if err == nil {
-			t.Errorf("exected error due to unreadable dir")
+		var derr *pods.DirReadError
+		if !errors.As(err, &derr) || derr.Dir != dbad {
+			t.Errorf("got error %v, expected DirReadError due to unreadable dir", err)
+		}
+	}
+}
+
+// legacyClassifier recognizes meta-data files of the form
+// "<hash>.meta" and counter files of the form "<hash>-<pid>.ctr",
+// deferring to the default classifier for anything else.
+type legacyClassifier struct{}
+
+func (legacyClassifier) Classify(name string) (pods.FileKind, string, int, int64) {
+	if hash, ok := strings.CutSuffix(name, ".meta"); ok {
+		return pods.MetaDataFile, hash, 0, 0
+	}
+	if rest, ok := strings.CutSuffix(name, ".ctr"); ok {
+		if hash, pids, ok := strings.Cut(rest, "-"); ok {
+			if pid, err := strconv.Atoi(pids); err == nil {
+				return pods.CounterDataFile, hash, pid, 0
+			}
+		}
+	}
+	return pods.DefaultClassifier.Classify(name)
+}
+
+func TestContainsCoverageFiles(t *testing.T) {
+	root := t.TempDir()
+	empty := writeFiles(t, filepath.Join(root, "empty"))
+	other := writeFiles(t, filepath.Join(root, "other"), "blah.txt", "covmeta.notahash", "abc.meta")
+	writeFiles(t, filepath.Join(other, "sub"), metaName("m1"))
+	meta := writeFiles(t, filepath.Join(root, "meta"), "blah.txt", metaName("m1"))
+	counter := writeFiles(t, filepath.Join(root, "counter"), counterName("m1", 42, 1))
+
+	for _, tc := range []struct {
+		dir  string
+		want bool
+	}{
+		{empty, false},
+		{other, false},
+		{meta, true},
+		{counter, true},
+	} {
+		got, err := pods.ContainsCoverageFiles(tc.dir)
+		if err != nil || got != tc.want {
+			t.Errorf("ContainsCoverageFiles(%s) = %v, %v; want %v", filepath.Base(tc.dir), got, err, tc.want)
+		}
+	}
+
+	// The configured classifier is used.
+	cfg := pods.Config{Classifier: legacyClassifier{}}
+	if got, err := cfg.ContainsCoverageFiles(other); err != nil || !got {
+		t.Errorf("ContainsCoverageFiles with custom classifier = %v, %v; want true", got, err)
+	}
+	cfg = pods.Config{ExcludeGlobs: []string{"covmeta.*"}}
+	if got, err := cfg.ContainsCoverageFiles(meta); err != nil || got {
+		t.Errorf("ContainsCoverageFiles with meta-data file excluded = %v, %v; want false", got, err)
+	}
+
+	var derr *pods.DirReadError
+	if _, err := pods.ContainsCoverageFiles(filepath.Join(root, "missing")); !errors.As(err, &derr) {
+		t.Errorf("ContainsCoverageFiles for missing directory: got %v, want DirReadError", err)
+	}
+	if _, err := pods.ContainsCoverageFiles(filepath.Join(meta, "blah.txt")); !errors.Is(err, pods.ErrNotADirectory) {
+		t.Errorf("ContainsCoverageFiles for file: got %v, want ErrNotADirectory", err)
+	}
+}
+
+func TestCustomClassifier(t *testing.T) {
+	dir := t.TempDir()
+	for _, fn := range []string{
+		"abc.meta",
+		"abc-7.ctr",
+		"abc-8.ctr",
+		"covcounters.abc.9.100",
+		"def-1.ctr",
+		metaName("m1"),
+		counterName("m1", 3, 200),
+		"README",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte("foo"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := pods.Config{Classifier: legacyClassifier{}}
+	podlist := collect(t, &cfg, dir)
+	var got []string
+	for _, p := range podlist {
+		s := filepath.Base(p.MetaFile) + ":"
+		for k, f := range p.CounterDataFiles {
+			s += fmt.Sprintf(" %s/%d", filepath.Base(f), p.ProcessIDs[k])
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"abc.meta: abc-7.ctr/7 abc-8.ctr/8 covcounters.abc.9.100/9",
+		metaName("m1") + ": " + counterName("m1", 3, 200) + "/3",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	type result struct {
+		kind pods.FileKind
+		hash string
+		pid  int
+		seq  int64
+	}
+	tests := []struct {
+		name string
+		want result
+	}{
+		{"covmeta.ae7be26cdaa742ca148068d5ac90eaca",
+			result{pods.MetaDataFile, "ae7be26cdaa742ca148068d5ac90eaca", 0, 0}},
+		{"covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1662138360208416486",
+			result{pods.CounterDataFile, "ae7be26cdaa742ca148068d5ac90eaca", 42, 1662138360208416486}},
+		{"covmeta.", result{kind: pods.MalformedMetaDataFile}},
+		{"covmeta.notvalidhex", result{kind: pods.MalformedMetaDataFile}},
+		{"covmeta.AE7BE26CDAA742CA148068D5AC90EACA", result{kind: pods.MalformedMetaDataFile}},
+		{"covmeta.ae7be26cdaa742ca148068d5ac90eac", result{kind: pods.MalformedMetaDataFile}},
+		{"covmeta.ae7be26cdaa742ca148068d5ac90eaca0", result{kind: pods.MalformedMetaDataFile}},
+		{"covmeta", result{}},
+		{"covmetaX.abc", result{}},
+		{"covcounters.abc.42", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.abc.x.1", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters..1.2", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.abc.99999999999999999999999.1", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.abc.1.9223372036854775807",
+			result{pods.CounterDataFile, "abc", 1, 9223372036854775807}},
+		{"covcounters.abc.1.9223372036854775808", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.abc.-1.2", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.abc.1.", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.ae7be26cdaa742ca148068d5ac90eaca", result{kind: pods.MalformedCounterDataFile}},
+		{"covcounters.", result{kind: pods.MalformedCounterDataFile}},
+		{"covcountersX.abc.1.2", result{}},
+		{"blah.txt", result{}},
+	}
+	for _, tc := range tests {
+		var got result
+		got.kind, got.hash, got.pid, got.seq = pods.DefaultClassifier.Classify(tc.name)
+		if got != tc.want {
+			t.Errorf("Classify(%q) = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// mkBenchDir populates a directory with 'nmeta' meta-data files, each
+// with 'nctr' counter data files, plus a few unrelated files.
+func mkBenchDir(b *testing.B, nmeta, nctr int) string {
+	dir := b.TempDir()
+	mk := func(fn string) {
+		if err := os.WriteFile(filepath.Join(dir, fn), nil, 0666); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < nmeta; i++ {
+		tag := fmt.Sprintf("meta%d", i)
+		mk(metaName(tag))
+		for j := 0; j < nctr; j++ {
+			mk(counterName(tag, 1000+j, 1662138360208416486+int64(j)))
+		}
+	}
+	mk("blah.txt")
+	mk("something.exe")
+	return dir
+}
+
+func BenchmarkCollectPods(b *testing.B) {
+	dir := mkBenchDir(b, 1000, 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		podlist, err := pods.CollectPods([]string{dir}, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(podlist) != 1000 {
+			b.Fatalf("got %d pods, want 1000", len(podlist))
+		}
+	}
+}
+
+// BenchmarkCollectPodsManyDirs collects pods spread across many input
+// directories, each holding a copy of the same meta-data file and a
+// few counter data files, as when merging the output of many test
+// shards. Its cost should grow linearly with the number of
+// directories.
+func BenchmarkCollectPodsManyDirs(b *testing.B) {
+	for _, ndirs := range []int{100, 1000} {
+		b.Run(fmt.Sprint(ndirs), func(b *testing.B) {
+			root := b.TempDir()
+			dirs := make([]string, ndirs)
+			for k := range dirs {
+				dirs[k] = filepath.Join(root, fmt.Sprintf("shard%d", k))
+				if err := os.Mkdir(dirs[k], 0777); err != nil {
+					b.Fatal(err)
+				}
+				for _, fn := range []string{metaName("m1"), counterName("m1", k, 1), counterName("m1", k, 2)} {
+					if err := os.WriteFile(filepath.Join(dirs[k], fn), nil, 0666); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				podlist, err := pods.CollectPods(dirs, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 2*ndirs {
+					b.Fatalf("got %d pods, want 1 with %d counter data files", len(podlist), 2*ndirs)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCollectPodsHugeDir collects a few pods from a directory
+// holding a very large number of unrelated files. The directory is
+// read in batches, so the names of the unrelated files are never all
+// held in memory at once.
+func BenchmarkCollectPodsHugeDir(b *testing.B) {
+	dir := mkBenchDir(b, 10, 10)
+	for k := 0; k < 100000; k++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("unrelated.%d", k)), nil, 0666); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		podlist, err := pods.CollectPods([]string{dir}, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(podlist) != 10 {
+			b.Fatalf("got %d pods, want 10", len(podlist))
+		}
+	}
+}
+
+func BenchmarkClassify(b *testing.B) {
+	names := []string{
+		metaName("m1"),
+		counterName("m1", 12345, 1664556800123456789),
+		"covcounters.notahash.1x.2",
+		"README",
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			pods.DefaultClassifier.Classify(name)
+		}
+	}
+}
+
+func BenchmarkCollector(b *testing.B) {
+	dir := mkBenchDir(b, 1000, 10)
+	var c pods.Collector
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		podlist, err := c.Collect([]string{dir})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(podlist) != 1000 {
+			b.Fatalf("got %d pods, want 1000", len(podlist))
+		}
+	}
+}
+
+// writeFiles creates empty files with the specified names in 'dir',
+// returning 'dir'.
+func writeFiles(t *testing.T, dir string, names ...string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range names {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte("foo"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// metaName and counterName return the names of the meta-data and
+// counter data files for a program identified by 'tag'.
+func metaName(tag string) string {
+	return pods.MetaFileName(fmt.Sprintf("%x", md5.Sum([]byte(tag))))
+}
+
+func counterName(tag string, pid int, nt int64) string {
+	return pods.CounterFileName(fmt.Sprintf("%x", md5.Sum([]byte(tag))), pid, nt)
+}
+
+// summarize returns a compact description of a pod list, listing
+// for each pod the meta-data file and each counter data file as
+// "<parent dir>/<base name>", along with origin and pid.
+func summarize(podlist []pods.Pod) string {
+	trim := func(path string) string {
+		return filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+	}
+	var sb strings.Builder
+	for _, p := range podlist {
+		fmt.Fprintf(&sb, "%s [\n", trim(p.MetaFile))
+		for k, df := range p.CounterDataFiles {
+			fmt.Fprintf(&sb, "  %s o:%d p:%d\n", trim(df), p.Origins[k], p.ProcessIDs[k])
+		}
+		sb.WriteString("]\n")
+	}
+	return sb.String()
+}
+
+// collect collects pods from 'dirs' according to 'cfg', failing the
+// test on error.
+func collect(t *testing.T, cfg *pods.Config, dirs ...string) []pods.Pod {
+	t.Helper()
+	podlist, err := cfg.CollectPods(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return podlist
+}
+
+// captureStderr returns what 'f' writes to os.Stderr, such as
+// warnings.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stderr := os.Stderr
+	os.Stderr = w
+	func() {
+		defer func() {
+			os.Stderr = stderr
+			w.Close()
+		}()
+		f()
+	}()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestCollectPodsSplit(t *testing.T) {
+	root := t.TempDir()
+	metas := writeFiles(t, filepath.Join(root, "metas"),
+		metaName("m1"), metaName("m2"), metaName("m3"),
+		// Counter files in a meta directory are ignored.
+		counterName("m3", 1, 1))
+	run1 := writeFiles(t, filepath.Join(root, "run-1"),
+		counterName("m1", 10, 1), counterName("m2", 11, 1),
+		// Meta files in a counter directory are ignored.
+		metaName("m4"), counterName("m4", 12, 1))
+	run2 := writeFiles(t, filepath.Join(root, "run-2"),
+		counterName("m1", 20, 2), counterName("orphan", 21, 1))
+
+	podlist, err := pods.CollectPodsSplit([]string{metas}, []string{run1, run2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(podlist)
+	want := `metas/covmeta.9678f7a7939f457fa0d9353761e189c7 [
+]
+metas/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+  run-1/covcounters.aaf2f89992379705dac844c0a2a1d45f.11.1 o:0 p:11
+]
+metas/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  run-1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.10.1 o:0 p:10
+  run-2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.20.2 o:1 p:20
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSeparatedLayout(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	writeFiles(t, filepath.Join(a, "meta"),
+		metaName("m1"),
+		// Counter files in a meta-data subdirectory are ignored.
+		counterName("m1", 9, 9))
+	writeFiles(t, filepath.Join(a, "data"),
+		counterName("m1", 10, 1))
+	b := filepath.Join(root, "b")
+	writeFiles(t, filepath.Join(b, "meta"),
+		metaName("m1"))
+	writeFiles(t, filepath.Join(b, "data"),
+		counterName("m1", 20, 2),
+		// Meta-data files in a counter data subdirectory are ignored.
+		metaName("m2"))
+
+	cfg := pods.Config{MetaSubdir: "meta", CounterSubdir: "data"}
+	podlist := collect(t, &cfg, a, b)
+	got := summarize(podlist)
+	want := `meta/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  data/covcounters.ae7be26cdaa742ca148068d5ac90eaca.10.1 o:0 p:10
+  data/covcounters.ae7be26cdaa742ca148068d5ac90eaca.20.2 o:1 p:20
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := podlist[0].OriginLabel(1); got != "b" {
+		t.Errorf("OriginLabel(1) = %q, want %q", got, "b")
+	}
+
+	writeFiles(t, filepath.Join(root, "c", "meta"), metaName("m1"))
+	if _, err := cfg.CollectPods([]string{a, filepath.Join(root, "c")}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CollectPods with missing counter data subdirectory: got error %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestCollectPodsLabeled(t *testing.T) {
+	root := t.TempDir()
+	u1 := writeFiles(t, filepath.Join(root, "u1"),
+		metaName("m1"), counterName("m1", 1, 1))
+	i1 := writeFiles(t, filepath.Join(root, "i1"),
+		metaName("m1"), counterName("m1", 2, 1), counterName("m1", 3, 1))
+	i2 := writeFiles(t, filepath.Join(root, "i2"),
+		metaName("m2"), counterName("m2", 4, 1))
+	podlist, err := pods.CollectPodsLabeled(map[string][]string{
+		"unit":        {u1},
+		"integration": {i1, i2},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLabels := map[string]string{"u1": "unit", "i1": "integration", "i2": "integration"}
+	n := 0
+	for _, p := range podlist {
+		for k, cdf := range p.CounterDataFiles {
+			n++
+			dir := filepath.Base(filepath.Dir(cdf))
+			if got, want := p.CounterFileLabel(k), wantLabels[dir]; got != want {
+				t.Errorf("CounterFileLabel for %s = %q, want %q", cdf, got, want)
+			}
+		}
+	}
+	if n != 4 {
+		t.Errorf("got %d counter data files, want 4:\n%s", n, summarize(podlist))
+	}
+
+	// Origins index into the directories in order of label.
+	got := summarize(podlist)
+	want := `i1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  i1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.2.1 o:0 p:2
+  i1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.3.1 o:0 p:3
+  u1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.1.1 o:2 p:1
+]
+i2/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+  i2/covcounters.aaf2f89992379705dac844c0a2a1d45f.4.1 o:1 p:4
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterFilesForOrigin(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), counterName("m1", 42, 2))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		metaName("m1"), counterName("m1", 42, 11))
+	podlist, err := pods.CollectPods([]string{o1, o2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("expected 1 pod got %d pods", len(podlist))
+	}
+	p := podlist[0]
+	if !p.IsMultiOrigin() {
+		t.Errorf("IsMultiOrigin() = false for pod with two origins")
+	}
+	for _, tc := range []struct {
+		origin int
+		want   []string
+	}{
+		{0, []string{counterName("m1", 42, 1), counterName("m1", 42, 2)}},
+		{1, []string{counterName("m1", 42, 11)}},
+		{2, []string{}},
+		{-1, []string{}},
+	} {
+		got := p.CounterFilesForOrigin(tc.origin)
+		if got == nil {
+			t.Errorf("CounterFilesForOrigin(%d) returned nil", tc.origin)
+		}
+		var gotBase []string
+		for _, f := range got {
+			gotBase = append(gotBase, filepath.Base(f))
+		}
+		if strings.Join(gotBase, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("CounterFilesForOrigin(%d) = %v, want %v", tc.origin, gotBase, tc.want)
+		}
+	}
+}
+
+func TestMalformedMetaNames(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1),
+		"covmeta.notvalidhex", "covcounters.notvalidhex.42.1",
+		"covmeta.AE7BE26CDAA742CA148068D5AC90EACA",
+		"covcounters.AE7BE26CDAA742CA148068D5AC90EACA.42.2")
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	podlist := collect(t, &cfg, dir)
+	if len(podlist) != 1 || filepath.Base(podlist[0].MetaFile) != metaName("m1") {
+		t.Fatalf("unexpected pods:\n%s", summarize(podlist))
+	}
+	base := func(paths []string) string {
+		var bs []string
+		for _, p := range paths {
+			bs = append(bs, filepath.Base(p))
+		}
+		return strings.Join(bs, " ")
+	}
+	wantMalformed := "covmeta.AE7BE26CDAA742CA148068D5AC90EACA covmeta.notvalidhex"
+	if got := base(st.MalformedMetaNames); got != wantMalformed {
+		t.Errorf("MalformedMetaNames: got %q want %q", got, wantMalformed)
+	}
+	wantOrphans := "covcounters.AE7BE26CDAA742CA148068D5AC90EACA.42.2 covcounters.notvalidhex.42.1"
+	if got := base(st.OrphanCounterFiles); got != wantOrphans {
+		t.Errorf("OrphanCounterFiles: got %q want %q", got, wantOrphans)
+	}
+}
+
+func TestMaxCounterFilesPerPod(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 40, 1), counterName("m1", 41, 1),
+		metaName("m2"), counterName("m2", 50, 1))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 42, 1))
+	dirs := []string{o1, o2}
+
+	// Truncation keeps the first files in sorted order, with
+	// matching origins and process IDs.
+	var st pods.Stats
+	cfg := pods.Config{MaxCounterFilesPerPod: 2, Stats: &st}
+	podlist, err := cfg.CollectPods(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(podlist)
+	want := `o1/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+  o1/covcounters.aaf2f89992379705dac844c0a2a1d45f.50.1 o:0 p:50
+]
+o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.40.1 o:0 p:40
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.41.1 o:0 p:41
+]
+`
+	if got != want {
+		t.Errorf("truncate: got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(st.TruncatedCounterFiles) != 1 || filepath.Base(st.TruncatedCounterFiles[0]) != counterName("m1", 42, 1) {
+		t.Errorf("TruncatedCounterFiles: got %v", st.TruncatedCounterFiles)
+	}
+
+	// A limit that is not exceeded changes nothing.
+	cfg = pods.Config{MaxCounterFilesPerPod: 3, CounterFileLimitPolicy: pods.FailOnCounterFileLimit}
+	podlist, err = cfg.CollectPods(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 2 || len(podlist[1].CounterDataFiles) != 3 {
+		t.Errorf("limit not reached: got:\n%s", summarize(podlist))
+	}
+
+	// The strict policy reports an error.
+	cfg = pods.Config{MaxCounterFilesPerPod: 2, CounterFileLimitPolicy: pods.FailOnCounterFileLimit}
+	if _, err := cfg.CollectPods(dirs); err == nil {
+		t.Errorf("expected error from FailOnCounterFileLimit")
+	}
+}
+
+func TestCollectPodsFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"run-2/" + counterName("m1", 20, 1):     {},
+		"run-1/" + metaName("m1"):               {},
+		"run-1/" + counterName("m1", 10, 1):     {},
+		"run-1/" + counterName("orphan", 11, 1): {},
+		"run-1/README":                          {},
+		"run-3/sub/" + metaName("m2"):           {},
+		"run-3/sub/" + counterName("m2", 30, 1): {},
+	}
+	var names []string
+	for name := range fsys {
+		names = append(names, name)
+	}
+	var cfg pods.Config
+	podlist, err := cfg.CollectPodsFromFS(fsys, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(podlist)
+	want := `run-1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  run-1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.10.1 o:0 p:10
+  run-2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.20.1 o:1 p:20
+]
+sub/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+  sub/covcounters.aaf2f89992379705dac844c0a2a1d45f.30.1 o:2 p:30
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	for _, p := range podlist {
+		for _, name := range append([]string{p.MetaFile}, p.CounterDataFiles...) {
+			if _, err := fs.Stat(fsys, name); err != nil {
+				t.Errorf("recorded name %s: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestPodTimeSpan(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m1", 2, 1), counterName("m1", 3, 1),
+		metaName("m2"))
+	base := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	for k, off := range []time.Duration{time.Hour, 0, 3 * time.Hour} {
+		mt := base.Add(off)
+		if err := os.Chtimes(filepath.Join(dir, counterName("m1", k+1, 1)), mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 2 {
+		t.Fatalf("expected 2 pods, got:\n%s", summarize(podlist))
+	}
+
+	// Pods are sorted by meta-data file name, so m2 comes first.
+	if first, last, err := podlist[0].TimeSpan(); err != pods.ErrNoCounterFiles || !first.IsZero() || !last.IsZero() {
+		t.Errorf("TimeSpan for pod without counter files = %v, %v, %v", first, last, err)
+	}
+	first, last, err := podlist[1].TimeSpan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(base) || !last.Equal(base.Add(3*time.Hour)) {
+		t.Errorf("TimeSpan = %v, %v, want %v, %v", first, last, base, base.Add(3*time.Hour))
+	}
+}
+
+func TestEstimateDecodeBytes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int) {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(metaName("m1"), 100)
+	write(counterName("m1", 1, 1), 10)
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("expected 1 pod, got:\n%s", summarize(podlist))
+	}
+	small, err := podlist[0].EstimateDecodeBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small < 110 {
+		t.Errorf("estimate %d less than total file size 110", small)
+	}
+
+	// Growing the counter data file, or adding another, must grow the
+	// estimate by at least as much.
+	write(counterName("m1", 1, 1), 1000)
+	write(counterName("m1", 2, 1), 1000)
+	podlist, err = pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := podlist[0].EstimateDecodeBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large-small < 1990 {
+		t.Errorf("estimate grew from %d to %d, want growth of at least 1990", small, large)
+	}
+
+	podlist[0].MetaFile = filepath.Join(dir, "missing")
+	if _, err := podlist[0].EstimateDecodeBytes(); err == nil {
+		t.Errorf("expected error for missing meta-data file")
+	}
+}
+
+func TestMinModTime(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m1", 2, 1),
+		metaName("m2"), counterName("m2", 3, 1))
+	cutoff := time.Now().Add(-time.Hour)
+	old := cutoff.Add(-time.Hour)
+	for _, name := range []string{counterName("m1", 1, 1), metaName("m2")} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := filepath.Base(dir)
+	wantPods := d + "/" + metaName("m1") + " [\n" +
+		"  " + d + "/" + counterName("m1", 2, 1) + " o:0 p:2\n" +
+		"]\n"
+	base := func(paths []string) string {
+		var bs []string
+		for _, p := range paths {
+			bs = append(bs, filepath.Base(p))
+		}
+		return strings.Join(bs, " ")
+	}
+
+	for _, drop := range []bool{false, true} {
+		var st pods.Stats
+		cfg := pods.Config{MinModTime: cutoff, DropStaleMetaCounters: drop, Stats: &st}
+		podlist, err := cfg.CollectPods([]string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := summarize(podlist); got != wantPods {
+			t.Errorf("drop=%v: got:\n%s\nwant:\n%s", drop, got, wantPods)
+		}
+		wantStale := counterName("m1", 1, 1) + " " + metaName("m2")
+		wantOrphans := counterName("m2", 3, 1)
+		if drop {
+			wantStale += " " + counterName("m2", 3, 1)
+			wantOrphans = ""
+		}
+		if got := base(st.StaleFiles); got != wantStale {
+			t.Errorf("drop=%v: StaleFiles: got %q want %q", drop, got, wantStale)
+		}
+		if got := base(st.OrphanCounterFiles); got != wantOrphans {
+			t.Errorf("drop=%v: OrphanCounterFiles: got %q want %q", drop, got, wantOrphans)
+		}
+	}
+}
+
+func TestCountMultiOriginPods(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 1, 1),
+		metaName("m2"), counterName("m2", 2, 1), counterName("m2", 3, 1),
+		metaName("m3"))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 4, 1))
+	podlist, err := pods.CollectPods([]string{o1, o2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 3 {
+		t.Fatalf("expected 3 pods, got:\n%s", summarize(podlist))
+	}
+	for _, p := range podlist {
+		want := filepath.Base(p.MetaFile) == metaName("m1")
+		if got := p.IsMultiOrigin(); got != want {
+			t.Errorf("IsMultiOrigin() for %s = %v, want %v", p.MetaFile, got, want)
 		}
 	}
+	if n := pods.CountMultiOriginPods(podlist); n != 1 {
+		t.Errorf("CountMultiOriginPods = %d, want 1", n)
+	}
+	if n := pods.CountMultiOriginPods(nil); n != 0 {
+		t.Errorf("CountMultiOriginPods(nil) = %d, want 0", n)
+	}
+}
+
+func TestTotalCounterFiles(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1),
+		metaName("m2"), counterName("m2", 2, 1), counterName("m2", 3, 1),
+		metaName("m3"),
+		counterName("orphan", 4, 1))
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	podlist := collect(t, &cfg, dir)
+	// The orphan belongs to no pod, and so isn't counted.
+	if n := pods.TotalCounterFiles(podlist); n != 3 {
+		t.Errorf("TotalCounterFiles = %d, want 3", n)
+	}
+	if len(st.OrphanCounterFiles) != 1 {
+		t.Errorf("OrphanCounterFiles = %v, want 1 file", st.OrphanCounterFiles)
+	}
+	if n := pods.TotalCounterFiles(nil); n != 0 {
+		t.Errorf("TotalCounterFiles(nil) = %d, want 0", n)
+	}
+}
+
+func TestOnPod(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 1, 1),
+		metaName("m2"), counterName("m2", 2, 1),
+		metaName("m3"))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 3, 1))
+	dirs := []string{o1, o2}
+
+	var seen []pods.Pod
+	cfg := pods.Config{OnPod: func(p pods.Pod) error {
+		seen = append(seen, p)
+		return nil
+	}}
+	podlist, err := cfg.CollectPods(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summarize(seen), summarize(podlist); got != want {
+		t.Errorf("OnPod saw:\n%s\nreturned:\n%s", got, want)
+	}
+
+	// An error from the callback stops collection.
+	errStop := fmt.Errorf("stop")
+	calls := 0
+	cfg.OnPod = func(p pods.Pod) error {
+		calls++
+		return errStop
+	}
+	if podlist, err := cfg.CollectPods(dirs); err != errStop || podlist != nil {
+		t.Errorf("CollectPods with failing OnPod = %v, %v", podlist, err)
+	}
+	if calls != 1 {
+		t.Errorf("OnPod called %d times, want 1", calls)
+	}
+}
+
+func TestGzipFiles(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		// Compressed meta-data file, plain counter data files.
+		metaName("m1")+".gz", counterName("m1", 1, 1), counterName("m1", 2, 1),
+		// Plain meta-data file, compressed counter data files.
+		metaName("m2"), counterName("m2", 3, 1)+".gz", counterName("m2", 4, 1))
+
+	// Without Gzip, compressed files aren't recognized.
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	podlist := collect(t, &cfg, dir)
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+		t.Errorf("without Gzip: unexpected pods:\n%s", summarize(podlist))
+	}
+
+	cfg.Gzip = true
+	podlist = collect(t, &cfg, dir)
+	if len(st.OrphanCounterFiles) != 0 || len(st.MalformedMetaNames) != 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	var sb strings.Builder
+	for _, p := range podlist {
+		fmt.Fprintf(&sb, "%s gz:%v\n", filepath.Base(p.MetaFile), p.MetaCompressed)
+		for k, cdf := range p.CounterDataFiles {
+			fmt.Fprintf(&sb, "  %s gz:%v\n", filepath.Base(cdf), p.CounterCompressed[k])
+		}
+	}
+	got := sb.String()
+	want := `covmeta.aaf2f89992379705dac844c0a2a1d45f gz:false
+  covcounters.aaf2f89992379705dac844c0a2a1d45f.3.1.gz gz:true
+  covcounters.aaf2f89992379705dac844c0a2a1d45f.4.1 gz:false
+covmeta.ae7be26cdaa742ca148068d5ac90eaca.gz gz:true
+  covcounters.ae7be26cdaa742ca148068d5ac90eaca.1.1 gz:false
+  covcounters.ae7be26cdaa742ca148068d5ac90eaca.2.1 gz:false
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDuplicateDirs(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 43, 1))
+	sep := string(filepath.Separator)
+	dirs := []string{
+		o1,
+		o1 + sep,
+		o1 + sep + "." + sep,
+		filepath.Join(root, "o2") + sep + ".." + sep + "o1",
+		o2,
+		o2 + sep,
+	}
+	podlist, err := pods.CollectPods(dirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(podlist)
+	want := `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.1 o:4 p:43
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A symbolic link to a directory counts as a separate input
+	// unless symbolic links are resolved.
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(o1, link); err != nil {
+		t.Skipf("can't create symbolic link: %v", err)
+	}
+	for _, resolve := range []bool{false, true} {
+		cfg := pods.Config{ResolveSymlinks: resolve}
+		podlist, err := cfg.CollectPods([]string{o1, link})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(podlist) != 1 {
+			t.Fatalf("ResolveSymlinks=%v: got %d pods, want 1", resolve, len(podlist))
+		}
+		wantFiles := 2
+		if resolve {
+			wantFiles = 1
+		}
+		if n := len(podlist[0].CounterDataFiles); n != wantFiles {
+			t.Errorf("ResolveSymlinks=%v: got %d counter data files, want %d", resolve, n, wantFiles)
+		}
+	}
+}
+
+func TestVerifyMetaFiles(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		metaName("m1"), counterName("m1", 42, 11))
+	o3 := writeFiles(t, filepath.Join(root, "o3"),
+		counterName("m1", 42, 21))
+	bad := filepath.Join(o3, metaName("m1"))
+	if err := os.WriteFile(bad, []byte("bar"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, verify := range []bool{false, true} {
+		var st pods.Stats
+		cfg := pods.Config{Stats: &st, VerifyMetaFiles: verify}
+		podlist, err := cfg.CollectPods([]string{o1, o2, o3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 3 {
+			t.Fatalf("VerifyMetaFiles=%v: unexpected pods:\n%s", verify, summarize(podlist))
+		}
+		var want []string
+		if verify {
+			want = []string{bad}
+		}
+		if fmt.Sprint(st.MismatchedMetaFiles) != fmt.Sprint(want) {
+			t.Errorf("VerifyMetaFiles=%v: MismatchedMetaFiles = %v, want %v", verify, st.MismatchedMetaFiles, want)
+		}
+	}
+}
+
+func TestDiffPodLists(t *testing.T) {
+	root := t.TempDir()
+	run1 := writeFiles(t, filepath.Join(root, "run1"),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m2"), counterName("m2", 43, 1), counterName("m2", 44, 1),
+		metaName("m3"), counterName("m3", 45, 1))
+	run2 := writeFiles(t, filepath.Join(root, "run2"),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m2"), counterName("m2", 43, 1), counterName("m2", 46, 1),
+		metaName("m4"))
+	old, err := pods.CollectPods([]string{run1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := pods.CollectPods([]string{run2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := func(tag string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(tag)))
+	}
+	d := pods.DiffPodLists(old, new)
+	if d.Empty() {
+		t.Fatalf("diff unexpectedly empty")
+	}
+	if want := []string{hash("m4")}; fmt.Sprint(d.AddedMetaHashes) != fmt.Sprint(want) {
+		t.Errorf("AddedMetaHashes = %v, want %v", d.AddedMetaHashes, want)
+	}
+	if want := []string{hash("m3")}; fmt.Sprint(d.RemovedMetaHashes) != fmt.Sprint(want) {
+		t.Errorf("RemovedMetaHashes = %v, want %v", d.RemovedMetaHashes, want)
+	}
+	want := []pods.PodChange{{
+		MetaHash:            hash("m2"),
+		AddedCounterFiles:   []string{counterName("m2", 46, 1)},
+		RemovedCounterFiles: []string{counterName("m2", 44, 1)},
+	}}
+	if fmt.Sprint(d.Changed) != fmt.Sprint(want) {
+		t.Errorf("Changed = %v, want %v", d.Changed, want)
+	}
+
+	if d := pods.DiffPodLists(old, old); !d.Empty() {
+		t.Errorf("diff of pod list with itself not empty: %+v", d)
+	}
+}
+
+func TestExcludeGlobs(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m1")+".bak", counterName("m1", 42, 2)+".bak",
+		counterName("m1", 43, 1))
+
+	var st pods.Stats
+	cfg := pods.Config{
+		Stats:        &st,
+		ExcludeGlobs: []string{"*.bak", "covcounters.*.43.*"},
+	}
+	podlist := collect(t, &cfg, dir)
+	got := summarize(podlist)
+	want := `001/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  001/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(st.MalformedMetaNames) != 0 {
+		t.Errorf("excluded file reported as malformed: %v", st.MalformedMetaNames)
+	}
+
+	cfg = pods.Config{ExcludeGlobs: []string{"*.bak", "[x"}}
+	if _, err := cfg.CollectPods([]string{dir}); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Errorf("CollectPods with invalid exclude pattern: got error %v, want ErrBadPattern", err)
+	}
+	if _, err := cfg.CollectPodsFromFiles(nil); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Errorf("CollectPodsFromFiles with invalid exclude pattern: got error %v, want ErrBadPattern", err)
+	}
+}
+
+func TestOrderPodsBy(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"),
+		metaName("m2"), counterName("m2", 1, 1), counterName("m2", 2, 1), counterName("m2", 3, 1),
+		metaName("m3"), counterName("m3", 4, 1), counterName("m3", 5, 1))
+	big := filepath.Join(dir, counterName("m1", 6, 1))
+	if err := os.WriteFile(big, make([]byte, 1000), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		order pods.PodOrder
+		want  []string
+	}{
+		{pods.OrderByMetaFile, []string{"m3", "m2", "m1"}},
+		{pods.OrderByCounterFileCount, []string{"m2", "m3", "m1"}},
+		{pods.OrderByTotalBytes, []string{"m1", "m2", "m3"}},
+	} {
+		var fromOnPod []string
+		cfg := pods.Config{
+			OrderPodsBy: tc.order,
+			OnPod: func(p pods.Pod) error {
+				fromOnPod = append(fromOnPod, filepath.Base(p.MetaFile))
+				return nil
+			},
+		}
+		podlist, err := cfg.CollectPods([]string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want []string
+		for _, p := range podlist {
+			got = append(got, filepath.Base(p.MetaFile))
+		}
+		for _, tag := range tc.want {
+			want = append(want, metaName(tag))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("order %d: got pods %v, want %v", tc.order, got, want)
+		}
+		if fmt.Sprint(fromOnPod) != fmt.Sprint(want) {
+			t.Errorf("order %d: OnPod called for %v, want %v", tc.order, fromOnPod, want)
+		}
+	}
+}
+
+func TestClassifyDir(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 7),
+		counterName("orphan", 43, 8),
+		coverage.MetaFilePref+".bogus",
+		"README")
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	classes, err := pods.ClassifyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for _, fc := range classes {
+		fmt.Fprintf(&sb, "%s %v %q %d %d %v\n", fc.Name, fc.Kind, fc.Hash, fc.PID, fc.Seq, fc.Orphan)
+	}
+	got := sb.String()
+	want := `README non-coverage "" 0 0 false
+covcounters.5c8ad194b0f0302f5a8a2c3bf502dcb2.43.8 counter-data "5c8ad194b0f0302f5a8a2c3bf502dcb2" 43 8 true
+covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.7 counter-data "ae7be26cdaa742ca148068d5ac90eaca" 42 7 false
+covmeta.ae7be26cdaa742ca148068d5ac90eaca meta-data "ae7be26cdaa742ca148068d5ac90eaca" 0 0 false
+covmeta.bogus malformed meta-data "" 0 0 false
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Collecting the directory must agree with the classification.
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	collect(t, &cfg, dir)
+	if want := []string{filepath.Join(dir, classes[1].Name)}; fmt.Sprint(st.OrphanCounterFiles) != fmt.Sprint(want) {
+		t.Errorf("OrphanCounterFiles = %v, want %v", st.OrphanCounterFiles, want)
+	}
+}
+
+func TestWarningFormat(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1),
+		counterName("orphan", 43, 1))
+
+	// Capture warnings written to stderr.
+	got := captureStderr(t, func() {
+		if _, err := pods.CollectPods([]string{dir}, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	want := fmt.Sprintf("warning: %s: skipping orphaned counter file: %s\n", dir, counterName("orphan", 43, 1))
+	if got != want {
+		t.Errorf("got warnings:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRecursiveMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	top := writeFiles(t, filepath.Join(root, "top"), metaName("m1"))
+	writeFiles(t, filepath.Join(top, "d1"), counterName("m1", 1, 1))
+	writeFiles(t, filepath.Join(top, "d1", "d2"), counterName("m1", 2, 1))
+	writeFiles(t, filepath.Join(top, "d1", "d2", "d3"), counterName("m1", 3, 1))
+	other := writeFiles(t, filepath.Join(root, "other"), counterName("m1", 4, 1))
+
+	for _, tc := range []struct {
+		recursive bool
+		maxDepth  int
+		want      string
+	}{
+		{false, 0, `top/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  other/covcounters.ae7be26cdaa742ca148068d5ac90eaca.4.1 o:1 p:4
+]
+`},
+		{true, 0, `top/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  other/covcounters.ae7be26cdaa742ca148068d5ac90eaca.4.1 o:1 p:4
+  d1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.1.1 o:0 p:1
+  d2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.2.1 o:0 p:2
+  d3/covcounters.ae7be26cdaa742ca148068d5ac90eaca.3.1 o:0 p:3
+]
+`},
+		{true, 2, `top/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  other/covcounters.ae7be26cdaa742ca148068d5ac90eaca.4.1 o:1 p:4
+  d1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.1.1 o:0 p:1
+  d2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.2.1 o:0 p:2
+]
+`},
+		{true, 1, `top/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  other/covcounters.ae7be26cdaa742ca148068d5ac90eaca.4.1 o:1 p:4
+  d1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.1.1 o:0 p:1
+]
+`},
+	} {
+		cfg := pods.Config{Recursive: tc.recursive, MaxDepth: tc.maxDepth}
+		podlist, err := cfg.CollectPods([]string{top, other})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := summarize(podlist); got != tc.want {
+			t.Errorf("Recursive=%v MaxDepth=%d: got:\n%s\nwant:\n%s", tc.recursive, tc.maxDepth, got, tc.want)
+		}
+	}
+}
+
+func TestCollectorReuse(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), counterName("m1", 42, 2),
+		metaName("m2"), counterName("m2", 43, 1))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		metaName("m3"), counterName("m3", 44, 1),
+		counterName("orphan", 45, 1))
+
+	var c pods.Collector
+	var st pods.Stats
+	c.Config.Stats = &st
+	var first []pods.Pod
+	var firstSummary string
+	for k, dirs := range [][]string{{o1}, {o2}, {o1, o2}} {
+		podlist, err := c.Collect(dirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := pods.CollectPods(dirs, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := summarize(podlist)
+		if got != summarize(want) {
+			t.Errorf("Collect(%v) got:\n%s\nwant:\n%s", dirs, got, summarize(want))
+		}
+		if k == 0 {
+			first, firstSummary = podlist, got
+		}
+	}
+	if got := len(st.OrphanCounterFiles); got != 1 {
+		t.Errorf("Stats from final collection has %d orphans, want 1", got)
+	}
+	// Pods from earlier collections must not be affected by later ones.
+	if got := summarize(first); got != firstSummary {
+		t.Errorf("pods from first collection changed to:\n%s\nwas:\n%s", got, firstSummary)
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	hash := md5.Sum([]byte("m1"))
+	hs := fmt.Sprintf("%x", hash)
+
+	// The names must match those written by instrumented programs.
+	if got, want := pods.MetaFileName(hs), fmt.Sprintf(coverage.MetaFileTempl, coverage.MetaFilePref, hash); got != want {
+		t.Errorf("MetaFileName(%q) = %q, want %q", hs, got, want)
+	}
+	const pid, nt = 42, int64(1662138360208416486)
+	if got, want := pods.CounterFileName(hs, pid, nt), fmt.Sprintf(coverage.CounterFileTempl, coverage.CounterFilePref, hash, pid, nt); got != want {
+		t.Errorf("CounterFileName(%q, %d, %d) = %q, want %q", hs, pid, nt, got, want)
+	}
+
+	// Round trip through the default classifier.
+	kind, h, _, _ := pods.DefaultClassifier.Classify(pods.MetaFileName(hs))
+	if kind != pods.MetaDataFile || h != hs {
+		t.Errorf("Classify(MetaFileName(%q)) = %v, %q", hs, kind, h)
+	}
+	kind, h, p, seq := pods.DefaultClassifier.Classify(pods.CounterFileName(hs, pid, nt))
+	if kind != pods.CounterDataFile || h != hs || p != pid || seq != nt {
+		t.Errorf("Classify(CounterFileName(%q, %d, %d)) = %v, %q, %d, %d", hs, pid, nt, kind, h, p, seq)
+	}
+}
+
+func TestExpectedFileNames(t *testing.T) {
+	hs := fmt.Sprintf("%x", md5.Sum([]byte("m1")))
+	pids := []int{42, 7, 42}
+	seqs := []int64{1662138360208416486, 1, 1662138360208416999}
+	names := pods.ExpectedFileNames(hs, pids, seqs)
+	if len(names) != 1+len(pids) {
+		t.Fatalf("ExpectedFileNames returned %d names, want %d: %q", len(names), 1+len(pids), names)
+	}
+
+	// Parsing the names gives back the inputs.
+	kind, h, _, _ := pods.DefaultClassifier.Classify(names[0])
+	if kind != pods.MetaDataFile || h != hs {
+		t.Errorf("Classify(%q) = %v, %q; want meta-data file with hash %q", names[0], kind, h, hs)
+	}
+	for i, name := range names[1:] {
+		kind, h, pid, seq := pods.DefaultClassifier.Classify(name)
+		if kind != pods.CounterDataFile || h != hs || pid != pids[i] || seq != seqs[i] {
+			t.Errorf("Classify(%q) = %v, %q, %d, %d; want counter data file with %q, %d, %d", name, kind, h, pid, seq, hs, pids[i], seqs[i])
+		}
+	}
+
+	if names := pods.ExpectedFileNames(hs, nil, nil); len(names) != 1 || names[0] != pods.MetaFileName(hs) {
+		t.Errorf("ExpectedFileNames with no counter files = %q", names)
+	}
+}
+
+func TestAllowHashes(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1),
+		metaName("m2"), counterName("m2", 2, 1),
+		metaName("m3"), counterName("m3", 3, 1), counterName("m3", 3, 2),
+		metaName("m4"), counterName("m4", 4, 1),
+		metaName("m5"), counterName("m5", 5, 1),
+		counterName("orphan", 6, 1),
+		coverage.MetaFilePref+".bogus")
+
+	var st pods.Stats
+	cfg := pods.Config{
+		Stats: &st,
+		AllowHashes: []string{
+			fmt.Sprintf("%x", md5.Sum([]byte("m3"))),
+			fmt.Sprintf("%x", md5.Sum([]byte("m5"))),
+		},
+	}
+	podlist := collect(t, &cfg, dir)
+	got := summarize(podlist)
+	want := `001/covmeta.7b1f6dff14d8c2dfeb7da9487be0612d [
+  001/covcounters.7b1f6dff14d8c2dfeb7da9487be0612d.5.1 o:0 p:5
+]
+001/covmeta.9678f7a7939f457fa0d9353761e189c7 [
+  001/covcounters.9678f7a7939f457fa0d9353761e189c7.3.1 o:0 p:3
+  001/covcounters.9678f7a7939f457fa0d9353761e189c7.3.2 o:0 p:3
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(st.OrphanCounterFiles) != 0 || len(st.MalformedMetaNames) != 0 {
+		t.Errorf("disallowed files reported in stats: %+v", st)
+	}
+}
+
+func TestNumProcesses(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"),
+		counterName("m1", 42, 1), counterName("m1", 42, 2),
+		counterName("m1", 43, 1), counterName("m1", 44, 1),
+		metaName("m2"))
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range podlist {
+		want := 0
+		if p.MetaFile == filepath.Join(dir, metaName("m1")) {
+			want = 3
+		}
+		if got := p.NumProcesses(); got != want {
+			t.Errorf("pod %s: NumProcesses() = %d, want %d", filepath.Base(p.MetaFile), got, want)
+		}
+	}
+
+	// Without ProcessIDs, pids are parsed from the file names, and
+	// names that don't parse are ignored.
+	p := pods.Pod{
+		MetaFile: metaName("m1"),
+		CounterDataFiles: []string{
+			filepath.Join(dir, counterName("m1", 42, 1)),
+			filepath.Join(dir, counterName("m1", 42, 2)),
+			filepath.Join(dir, counterName("m1", 43, 1)),
+			filepath.Join(dir, "covcounters.bogus"),
+		},
+	}
+	if got := p.NumProcesses(); got != 2 {
+		t.Errorf("NumProcesses() for pod without ProcessIDs = %d, want 2", got)
+	}
+}
+
+func TestPrimaryPid(t *testing.T) {
+	bogus := "covcounters.bogus"
+	tests := []struct {
+		name   string
+		pids   []int
+		names  []string // used when pids is nil
+		want   int
+		wantOK bool
+	}{
+		{name: "single", pids: []int{42, 42, 42}, want: 42, wantOK: true},
+		{name: "majority", pids: []int{43, 42, 42}, want: 42, wantOK: true},
+		{name: "tie", pids: []int{42, 43}},
+		{name: "mixed", pids: []int{42, 43, 44}},
+		{name: "empty"},
+		// Unparseable names are ignored, so 42 is a majority of the
+		// remaining files.
+		{name: "parsed", names: []string{counterName("m1", 42, 1), counterName("m1", 42, 2), counterName("m1", 43, 1), bogus, bogus}, want: 42, wantOK: true},
+		{name: "unparseable", names: []string{bogus}},
+	}
+	for _, tc := range tests {
+		p := pods.Pod{MetaFile: metaName("m1"), ProcessIDs: tc.pids}
+		if tc.pids != nil {
+			for range tc.pids {
+				p.CounterDataFiles = append(p.CounterDataFiles, bogus)
+			}
+		} else {
+			p.CounterDataFiles = tc.names
+		}
+		pid, ok := p.PrimaryPid()
+		if pid != tc.want || ok != tc.wantOK {
+			t.Errorf("%s: PrimaryPid() = %d, %v; want %d, %v", tc.name, pid, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestMissingPIDs(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"),
+		counterName("m1", 42, 1), counterName("m1", 42, 2),
+		counterName("m1", 44, 1),
+		metaName("m2"), counterName("m2", 50, 1))
+	h1 := strings.TrimPrefix(metaName("m1"), "covmeta.")
+	h2 := strings.TrimPrefix(metaName("m2"), "covmeta.")
+	cfg := pods.Config{
+		VerifyExpectedPIDs: map[string][]int{
+			h1:              {44, 43, 42},
+			h2:              {50},
+			"nosuchpodhash": {1},
+		},
+	}
+	podlist := collect(t, &cfg, dir)
+	if len(podlist) != 2 {
+		t.Fatalf("got pods:\n%s\nwant 2", summarize(podlist))
+	}
+	for _, p := range podlist {
+		want := "[]"
+		if p.MetaFile == filepath.Join(dir, metaName("m1")) {
+			want = "[43]"
+		}
+		if got := fmt.Sprint(p.MissingPIDs()); got != want {
+			t.Errorf("pod %s: MissingPIDs() = %s, want %s", filepath.Base(p.MetaFile), got, want)
+		}
+	}
+
+	// Without the option, nothing is expected, so nothing is missing.
+	podlist = collect(t, &pods.Config{}, dir)
+	for _, p := range podlist {
+		if missing := p.MissingPIDs(); missing != nil {
+			t.Errorf("pod %s: MissingPIDs() = %v without VerifyExpectedPIDs", filepath.Base(p.MetaFile), missing)
+		}
+	}
+}
+
+func TestStrictSameDir(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 43, 1),
+		metaName("m2"), counterName("m2", 44, 1))
+
+	for _, strict := range []bool{false, true} {
+		var st pods.Stats
+		cfg := pods.Config{Stats: &st, StrictSameDir: strict}
+		podlist, err := cfg.CollectPods([]string{o1, o2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := summarize(podlist)
+		want := `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+`
+		var wantOrphans []string
+		if strict {
+			wantOrphans = []string{filepath.Join(o2, counterName("m1", 43, 1))}
+		} else {
+			want += "  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.1 o:1 p:43\n"
+		}
+		want += `]
+o2/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+  o2/covcounters.aaf2f89992379705dac844c0a2a1d45f.44.1 o:1 p:44
+]
+`
+		if got != want {
+			t.Errorf("StrictSameDir=%v: got:\n%s\nwant:\n%s", strict, got, want)
+		}
+		if fmt.Sprint(st.OrphanCounterFiles) != fmt.Sprint(wantOrphans) {
+			t.Errorf("StrictSameDir=%v: OrphanCounterFiles = %v, want %v", strict, st.OrphanCounterFiles, wantOrphans)
+		}
+	}
+}
+
+func TestAssertNoOrphans(t *testing.T) {
+	root := t.TempDir()
+	d1 := writeFiles(t, filepath.Join(root, "d1"),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m2"),
+		counterName("orphan1", 43, 1))
+	d2 := writeFiles(t, filepath.Join(root, "d2"),
+		counterName("m1", 44, 1),
+		counterName("orphan2", 45, 1), counterName("orphan1", 46, 1))
+
+	err := pods.AssertNoOrphans([]string{d1, d2})
+	var perr *pods.PairingError
+	if !errors.As(err, &perr) {
+		t.Fatalf("AssertNoOrphans returned %v, want PairingError", err)
+	}
+	want := []string{
+		filepath.Join(d1, counterName("orphan1", 43, 1)),
+		filepath.Join(d2, counterName("orphan1", 46, 1)),
+		filepath.Join(d2, counterName("orphan2", 45, 1)),
+	}
+	sort.Strings(want)
+	if fmt.Sprint(perr.OrphanCounterFiles) != fmt.Sprint(want) {
+		t.Errorf("OrphanCounterFiles = %v, want %v", perr.OrphanCounterFiles, want)
+	}
+	if len(perr.OrphanHashes) != len(perr.OrphanCounterFiles) {
+		t.Fatalf("got %d orphan hashes for %d orphans", len(perr.OrphanHashes), len(perr.OrphanCounterFiles))
+	}
+	for k, f := range perr.OrphanCounterFiles {
+		h := perr.OrphanHashes[k]
+		if !strings.Contains(filepath.Base(f), h) {
+			t.Errorf("orphan %s has hash %s", f, h)
+		}
+		if !strings.Contains(err.Error(), f) || !strings.Contains(err.Error(), h) {
+			t.Errorf("error %q does not mention %s with hash %s", err, f, h)
+		}
+	}
+	if len(perr.MetaFilesWithoutCounters) != 0 {
+		t.Errorf("MetaFilesWithoutCounters = %v, want none", perr.MetaFilesWithoutCounters)
+	}
+
+	// Meta-data files without counter data files are fine.
+	d3 := writeFiles(t, filepath.Join(root, "d3"),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m2"))
+	if err := pods.AssertNoOrphans([]string{d3}); err != nil {
+		t.Errorf("AssertNoOrphans for paired files: %v", err)
+	}
+}
+
+func TestStrictPairing(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m2"),
+		metaName("m3"),
+		counterName("orphan1", 43, 1), counterName("orphan2", 44, 1))
+
+	// Tolerant by default.
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil || len(podlist) != 3 {
+		t.Fatalf("CollectPods returned %d pods, err %v; want 3 pods", len(podlist), err)
+	}
+
+	cfg := pods.Config{StrictPairing: true}
+	_, err = cfg.CollectPods([]string{dir})
+	var perr *pods.PairingError
+	if !errors.As(err, &perr) {
+		t.Fatalf("CollectPods with StrictPairing returned %v, want PairingError", err)
+	}
+	wantOrphans := []string{
+		filepath.Join(dir, counterName("orphan1", 43, 1)),
+		filepath.Join(dir, counterName("orphan2", 44, 1)),
+	}
+	sort.Strings(wantOrphans)
+	if fmt.Sprint(perr.OrphanCounterFiles) != fmt.Sprint(wantOrphans) {
+		t.Errorf("OrphanCounterFiles = %v, want %v", perr.OrphanCounterFiles, wantOrphans)
+	}
+	wantMetas := []string{
+		filepath.Join(dir, metaName("m2")),
+		filepath.Join(dir, metaName("m3")),
+	}
+	sort.Strings(wantMetas)
+	if fmt.Sprint(perr.MetaFilesWithoutCounters) != fmt.Sprint(wantMetas) {
+		t.Errorf("MetaFilesWithoutCounters = %v, want %v", perr.MetaFilesWithoutCounters, wantMetas)
+	}
+	for _, f := range append(wantOrphans, wantMetas...) {
+		if !strings.Contains(err.Error(), f) {
+			t.Errorf("error %q does not mention %s", err, f)
+		}
+	}
+
+	// A fully paired directory is accepted.
+	good := writeFiles(t, t.TempDir(), metaName("m1"), counterName("m1", 42, 1))
+	if _, err := cfg.CollectPods([]string{good}); err != nil {
+		t.Errorf("CollectPods with StrictPairing failed for paired files: %v", err)
+	}
+}
+
+func TestSplitByOrigin(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), counterName("m1", 43, 2))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		metaName("m1"), counterName("m1", 44, 11))
+	podlist, err := pods.CollectPods([]string{o1, o2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("expected 1 pod got %d pods", len(podlist))
+	}
+	split := podlist[0].SplitByOrigin()
+	got := summarize(split)
+	want := `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.2 o:0 p:43
+]
+o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.44.11 o:0 p:44
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	for _, p := range split {
+		if p.IsMultiOrigin() {
+			t.Errorf("split pod is multi-origin: %+v", p)
+		}
+		if len(p.CounterCompressed) != len(p.CounterDataFiles) {
+			t.Errorf("split pod has %d compression flags for %d counter data files", len(p.CounterCompressed), len(p.CounterDataFiles))
+		}
+	}
+
+	empty := pods.Pod{MetaFile: metaName("m2")}
+	if split := empty.SplitByOrigin(); len(split) != 1 || split[0].MetaFile != empty.MetaFile {
+		t.Errorf("SplitByOrigin for pod with no counter files = %+v", split)
+	}
+}
+
+func TestOriginLabels(t *testing.T) {
+	root := t.TempDir()
+	shard0 := writeFiles(t, filepath.Join(root, "shard-0"),
+		metaName("m1"), counterName("m1", 42, 1))
+	shard1 := writeFiles(t, filepath.Join(root, "shard-1"),
+		counterName("m1", 43, 2))
+	dirs := []string{shard0, shard1}
+	podlist, err := pods.CollectPods(dirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("expected 1 pod got %d pods", len(podlist))
+	}
+	p := podlist[0]
+	for k, o := range p.Origins {
+		if got, want := p.OriginLabel(o), filepath.Base(dirs[o]); got != want {
+			t.Errorf("OriginLabel(%d) for %s = %q, want %q", o, p.CounterDataFiles[k], got, want)
+		}
+	}
+	if got, want := p.OriginLabel(7), "origin 7"; got != want {
+		t.Errorf("OriginLabel(7) = %q, want %q", got, want)
+	}
+	split := p.SplitByOrigin()
+	for k, sp := range split {
+		if got, want := sp.OriginLabel(0), filepath.Base(dirs[k]); got != want {
+			t.Errorf("split pod %d: OriginLabel(0) = %q, want %q", k, got, want)
+		}
+	}
+
+	cfg := pods.Config{OriginLabels: []string{"first", "second"}}
+	podlist, err = cfg.CollectPods(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{0: "first", 1: "second"}
+	if got := podlist[0].OriginLabels; !reflect.DeepEqual(got, want) {
+		t.Errorf("OriginLabels = %v, want %v", got, want)
+	}
+	cfg.OriginLabels = []string{"first"}
+	if _, err := cfg.CollectPods(dirs); err == nil {
+		t.Errorf("CollectPods with too few origin labels succeeded")
+	}
+}
+
+func TestIgnoreOrphansForHashes(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1),
+		counterName("elsewhere", 43, 1),
+		counterName("orphan", 44, 1))
+
+	var st pods.Stats
+	cfg := pods.Config{
+		Warn:                   true,
+		Stats:                  &st,
+		IgnoreOrphansForHashes: []string{fmt.Sprintf("%x", md5.Sum([]byte("elsewhere")))},
+	}
+	var podlist []pods.Pod
+	out := captureStderr(t, func() { podlist = collect(t, &cfg, dir) })
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+		t.Errorf("unexpected pods:\n%s", summarize(podlist))
+	}
+	orphan := counterName("orphan", 44, 1)
+	want := fmt.Sprintf("warning: %s: skipping orphaned counter file: %s\n", dir, orphan)
+	if out != want {
+		t.Errorf("got warnings:\n%s\nwant:\n%s", out, want)
+	}
+	if want := []string{filepath.Join(dir, orphan)}; fmt.Sprint(st.OrphanCounterFiles) != fmt.Sprint(want) {
+		t.Errorf("OrphanCounterFiles = %v, want %v", st.OrphanCounterFiles, want)
+	}
+}
+
+func TestCounterFileFilter(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"),
+		counterName("m1", 40, 1), counterName("m1", 41, 1),
+		counterName("m1", 42, 1), counterName("m1", 43, 1))
+
+	var st pods.Stats
+	cfg := pods.Config{
+		Stats: &st,
+		CounterFileFilter: func(name string) bool {
+			_, _, pid, _ := pods.DefaultClassifier.Classify(name)
+			return pid%2 == 0
+		},
+	}
+	podlist := collect(t, &cfg, dir)
+	got := summarize(podlist)
+	want := `001/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  001/covcounters.ae7be26cdaa742ca148068d5ac90eaca.40.1 o:0 p:40
+  001/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(st.OrphanCounterFiles) != 0 {
+		t.Errorf("filtered files reported as orphans: %v", st.OrphanCounterFiles)
+	}
+}
+
+func TestCounterDedup(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), counterName("m1", 42, 2))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 42, 2), counterName("m1", 43, 1))
+	// Make the copy in o2 the newer one.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(o1, counterName("m1", 42, 2)), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := counterName("m1", 42, 2)
+	for _, tc := range []struct {
+		strategy pods.DedupStrategy
+		want     string
+		wantDups []string
+	}{
+		{pods.DedupAll, `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.2 o:0 p:42
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.2 o:1 p:42
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.1 o:1 p:43
+]
+`, nil},
+		{pods.DedupFirst, `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.2 o:0 p:42
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.1 o:1 p:43
+]
+`, []string{filepath.Join(o2, dup)}},
+		{pods.DedupNewest, `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.2 o:1 p:42
+  o2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.1 o:1 p:43
+]
+`, []string{filepath.Join(o1, dup)}},
+	} {
+		var st pods.Stats
+		cfg := pods.Config{Stats: &st, CounterDedup: tc.strategy}
+		podlist, err := cfg.CollectPods([]string{o1, o2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := summarize(podlist); got != tc.want {
+			t.Errorf("strategy %d: got:\n%s\nwant:\n%s", tc.strategy, got, tc.want)
+		}
+		if fmt.Sprint(st.DuplicateCounterFiles) != fmt.Sprint(tc.wantDups) {
+			t.Errorf("strategy %d: DuplicateCounterFiles = %v, want %v", tc.strategy, st.DuplicateCounterFiles, tc.wantDups)
+		}
+	}
+}
+
+func TestEmptyInputDirs(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1))
+	empty := writeFiles(t, filepath.Join(root, "empty"), "README")
+	missing := filepath.Join(root, "missing")
+	dirs := []string{o1, empty, missing}
+
+	// Without SkipMissingDirs, a missing directory is an error.
+	if _, err := pods.CollectPods(dirs, false); err == nil {
+		t.Errorf("CollectPods with missing dir succeeded, want error")
+	}
+
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st, SkipMissingDirs: true}
+	podlist, err := cfg.CollectPods(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(podlist)
+	want := `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.1 o:0 p:42
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if fmt.Sprint(st.EmptyInputDirs) != fmt.Sprint([]string{empty}) {
+		t.Errorf("EmptyInputDirs = %v, want [%s]", st.EmptyInputDirs, empty)
+	}
+	if fmt.Sprint(st.MissingInputDirs) != fmt.Sprint([]string{missing}) {
+		t.Errorf("MissingInputDirs = %v, want [%s]", st.MissingInputDirs, missing)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), metaName("m2"))
+	o2 := writeFiles(t, filepath.Join(root, "o 2"),
+		counterName("m1", 43, 1), counterName("m2", 44, 1))
+	podlist, err := pods.CollectPods([]string{o1, o2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := pods.WriteManifest(&buf, podlist); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := pods.ReadManifest(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, p := range podlist {
+		want = append(want, p.MetaFile)
+		want = append(want, p.CounterDataFiles...)
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("ReadManifest = %v, want %v", files, want)
+	}
+
+	// Collecting from the manifest preserves origins.
+	var cfg pods.Config
+	got, err := cfg.CollectPodsFromManifest(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := summarize(got), summarize(podlist); g != w {
+		t.Errorf("CollectPodsFromManifest got:\n%s\nwant:\n%s", g, w)
+	}
+
+	for _, bad := range []string{
+		"",
+		"not a manifest\n",
+		"go coverage pod manifest v1\n0\n",
+		"go coverage pod manifest v1\nx \"a\"\n",
+		"go coverage pod manifest v1\n-2 \"a\"\n",
+		"go coverage pod manifest v1\n0 a\n",
+	} {
+		if _, err := pods.ReadManifest(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadManifest(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestSkipMissingDirs(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1))
+	missing := filepath.Join(root, "missing")
+	notDir := filepath.Join(writeFiles(t, root, "file"), "file")
+
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st, SkipMissingDirs: true}
+	podlist := collect(t, &cfg, missing, o1)
+	if len(podlist) != 1 {
+		t.Errorf("got %d pods, want 1", len(podlist))
+	}
+	if fmt.Sprint(st.MissingInputDirs) != fmt.Sprint([]string{missing}) {
+		t.Errorf("MissingInputDirs = %v, want [%s]", st.MissingInputDirs, missing)
+	}
+
+	// A directory that exists but can't be read is still an error.
+	if _, err := cfg.CollectPods([]string{o1, notDir}); err == nil {
+		t.Errorf("CollectPods with non-directory input succeeded, want error")
+	}
+
+	// Without SkipMissingDirs, a missing directory is a DirReadError.
+	_, err := pods.CollectPods([]string{o1, missing}, false)
+	var derr *pods.DirReadError
+	if !errors.As(err, &derr) || derr.Dir != missing || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CollectPods with missing directory: got %v, want DirReadError for %s", err, missing)
+	}
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		return
+	}
+	unreadable := writeFiles(t, filepath.Join(root, "unreadable"))
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(unreadable, 0777)
+	_, err = cfg.CollectPods([]string{o1, unreadable})
+	if err == nil {
+		t.Errorf("CollectPods with unreadable dir succeeded, want error")
+	} else if errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CollectPods with unreadable dir: got %v, want permission error", err)
+	}
+}
+
+func TestKeepLatestPerPID(t *testing.T) {
+	o1 := writeFiles(t, t.TempDir(),
+		metaName("m1"),
+		counterName("m1", 42, 100),
+		counterName("m1", 42, 200),
+		counterName("m1", 43, 50))
+
+	podlist, err := pods.CollectPods([]string{o1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("got %d pods, want 1", len(podlist))
+	}
+	if got, want := fmt.Sprint(podlist[0].Sequences), "[100 200 50]"; got != want {
+		t.Errorf("Sequences = %s, want %s", got, want)
+	}
+
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st, KeepLatestPerPID: true}
+	podlist = collect(t, &cfg, o1)
+	got := summarize(podlist)
+	want := fmt.Sprintf(`%s/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  %s/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.200 o:0 p:42
+  %s/covcounters.ae7be26cdaa742ca148068d5ac90eaca.43.50 o:0 p:43
+]
+`, filepath.Base(o1), filepath.Base(o1), filepath.Base(o1))
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := fmt.Sprint(podlist[0].Sequences), "[200 50]"; got != want {
+		t.Errorf("Sequences = %s, want %s", got, want)
+	}
+	wantSuperseded := []string{filepath.Join(o1, counterName("m1", 42, 100))}
+	if fmt.Sprint(st.SupersededCounterFiles) != fmt.Sprint(wantSuperseded) {
+		t.Errorf("SupersededCounterFiles = %v, want %v", st.SupersededCounterFiles, wantSuperseded)
+	}
+}
+
+func TestMetaOnly(t *testing.T) {
+	truncated := "covcounters." + strings.TrimPrefix(metaName("m1"), "covmeta.")
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1),
+		metaName("m2"),
+		counterName("orphan", 43, 1), truncated)
+
+	var st pods.Stats
+	cfg := pods.Config{Warn: true, Stats: &st, MetaOnly: true}
+	var podlist []pods.Pod
+	out := captureStderr(t, func() { podlist = collect(t, &cfg, dir) })
+	got := summarize(podlist)
+	want := fmt.Sprintf(`%[1]s/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+]
+%[1]s/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+]
+`, filepath.Base(dir))
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(out) != 0 {
+		t.Errorf("got warnings:\n%s\nwant none", out)
+	}
+	if len(st.OrphanCounterFiles) != 0 || len(st.MalformedCounterNames) != 0 {
+		t.Errorf("OrphanCounterFiles = %v, MalformedCounterNames = %v; want none", st.OrphanCounterFiles, st.MalformedCounterNames)
+	}
+
+	// Counter data files are never stat'ed, even when file
+	// information is needed for ordering.
+	var statted []string
+	cfg = pods.Config{
+		MetaOnly:    true,
+		OrderPodsBy: pods.OrderByTotalBytes,
+		FileInfo: func(path string) (fs.FileInfo, error) {
+			statted = append(statted, filepath.Base(path))
+			return os.Stat(path)
+		},
+	}
+	collect(t, &cfg, dir)
+	sort.Strings(statted)
+	if want := []string{metaName("m2"), metaName("m1")}; fmt.Sprint(statted) != fmt.Sprint(want) {
+		t.Errorf("stat'ed %v, want only %v", statted, want)
+	}
+}
+
+func TestMalformedCounterNames(t *testing.T) {
+	// A counter data file name that is missing its process ID and
+	// sequence fields, as might result from truncation or a partial
+	// rename.
+	truncated := "covcounters." + strings.TrimPrefix(metaName("m1"), "covmeta.")
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 42, 1), truncated, "blah.txt")
+
+	var st pods.Stats
+	cfg := pods.Config{Warn: true, Stats: &st}
+	var podlist []pods.Pod
+	out := captureStderr(t, func() { podlist = collect(t, &cfg, dir) })
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+		t.Errorf("got pods %+v, want one pod with one counter data file", podlist)
+	}
+	wantMalformed := []string{filepath.Join(dir, truncated)}
+	if fmt.Sprint(st.MalformedCounterNames) != fmt.Sprint(wantMalformed) {
+		t.Errorf("MalformedCounterNames = %v, want %v", st.MalformedCounterNames, wantMalformed)
+	}
+	want := fmt.Sprintf("warning: %s: skipping file with malformed counter file name: %s\n", dir, truncated)
+	if out != want {
+		t.Errorf("got warnings:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestCollectorCache(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), metaName("m1"), counterName("m1", 42, 1))
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(dir, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	var c pods.Collector
+	count := func() int {
+		t.Helper()
+		podlist, err := c.Collect([]string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(podlist) != 1 {
+			t.Fatalf("got %d pods, want 1", len(podlist))
+		}
+		return len(podlist[0].CounterDataFiles)
+	}
+	if got := count(); got != 1 {
+		t.Fatalf("first collection: got %d counter data files, want 1", got)
+	}
+
+	// Add a file but put back the directory's modification time, so
+	// that the directory appears unchanged: the collector should use
+	// its cached entries.
+	writeFiles(t, dir, counterName("m1", 43, 1))
+	if err := os.Chtimes(dir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 1 {
+		t.Errorf("collection of unchanged directory: got %d counter data files, want 1 (cached)", got)
+	}
+
+	// Once the modification time changes, the directory is read again.
+	newer := old.Add(time.Minute)
+	if err := os.Chtimes(dir, newer, newer); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("collection of changed directory: got %d counter data files, want 2", got)
+	}
+
+	// A directory modified very recently is not cached, since further
+	// changes might not be reflected in its modification time.
+	writeFiles(t, dir, counterName("m1", 44, 1))
+	if got := count(); got != 3 {
+		t.Errorf("collection of recently modified directory: got %d counter data files, want 3", got)
+	}
+	writeFiles(t, dir, counterName("m1", 45, 1))
+	if got := count(); got != 4 {
+		t.Errorf("second collection of recently modified directory: got %d counter data files, want 4", got)
+	}
+}
+
+func TestCollectorConcurrent(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), metaName("m2"))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 43, 1), counterName("m2", 44, 1))
+	dirs := []string{o1, o2}
+	want, err := pods.CollectPods(dirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c pods.Collector
+	const n = 8
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				podlist, err := c.Collect(dirs)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if got := summarize(podlist); got != summarize(want) {
+					errc <- fmt.Errorf("got:\n%s\nwant:\n%s", got, summarize(want))
+					return
+				}
+			}
+			errc <- nil
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestWalkCounterFiles(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		"blah.txt", metaName("m1"), counterName("m1", 42, 1), counterName("m1", 42, 2),
+		counterName("m3", 45, 1), counterName("orphan", 42, 9))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		metaName("m2"), counterName("m2", 42, 1), counterName("m2", 43, 2),
+		metaName("m1"), counterName("m1", 44, 11), metaName("m3"))
+	dirs := []string{o1, o2}
+
+	// The files walked are those of the collected pods.
+	var walked, orphans []string
+	cfg := pods.Config{
+		OnOrphan: func(cdf string, origin, pid int) error {
+			orphans = append(orphans, fmt.Sprintf("%s o:%d p:%d", filepath.Base(cdf), origin, pid))
+			return nil
+		},
+	}
+	err := cfg.WalkCounterFiles(dirs, func(mf, cdf string, origin, pid int) error {
+		walked = append(walked, fmt.Sprintf("%s %s o:%d p:%d", mf, cdf, origin, pid))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	podlist, err := pods.CollectPods(dirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, p := range podlist {
+		for k, cdf := range p.CounterDataFiles {
+			want = append(want, fmt.Sprintf("%s %s o:%d p:%d", p.MetaFile, cdf, p.Origins[k], p.ProcessIDs[k]))
+		}
+	}
+	sort.Strings(walked)
+	sort.Strings(want)
+	if strings.Join(walked, "\n") != strings.Join(want, "\n") {
+		t.Errorf("walk got:\n%s\nwant:\n%s", strings.Join(walked, "\n"), strings.Join(want, "\n"))
+	}
+	wantOrphans := []string{counterName("orphan", 42, 9) + " o:0 p:42"}
+	if fmt.Sprint(orphans) != fmt.Sprint(wantOrphans) {
+		t.Errorf("orphans = %v, want %v", orphans, wantOrphans)
+	}
+
+	// Files are passed as directories are read: a failing callback
+	// stops the walk before the missing directory is reached.
+	stop := errors.New("stop")
+	calls := 0
+	missing := filepath.Join(root, "missing")
+	err = pods.WalkCounterFiles([]string{o1, missing}, func(mf, cdf string, origin, pid int) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("walk with failing callback: got error %v after %d calls, want %v after 1 call", err, calls, stop)
+	}
+	cfg.OnOrphan = func(string, int, int) error { return stop }
+	if err := cfg.WalkCounterFiles(dirs, func(string, string, int, int) error { return nil }); err != stop {
+		t.Errorf("walk with failing OnOrphan: got error %v, want %v", err, stop)
+	}
+}
+
+// fakeFileInfo is an fs.FileInfo with a synthetic modification time.
+type fakeFileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return 0 }
+func (fi fakeFileInfo) Mode() fs.FileMode  { return 0666 }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() any           { return nil }
+
+func TestFileInfoHook(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), counterName("m1", 42, 2))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 42, 2))
+
+	// Synthetic modification times, in hours after 'base'; the copy
+	// of counterName("m1", 42, 2) in o1 is the newer one, and the
+	// file counterName("m1", 42, 1) is older than the others.
+	base := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	hours := map[string]int{
+		filepath.Join(o1, metaName("m1")):           5,
+		filepath.Join(o1, counterName("m1", 42, 1)): 1,
+		filepath.Join(o1, counterName("m1", 42, 2)): 4,
+		filepath.Join(o2, counterName("m1", 42, 2)): 3,
+	}
+	fileInfo := func(path string) (fs.FileInfo, error) {
+		h, ok := hours[path]
+		if !ok {
+			return nil, fmt.Errorf("no synthetic time for %s", path)
+		}
+		return fakeFileInfo{filepath.Base(path), base.Add(time.Duration(h) * time.Hour)}, nil
+	}
+
+	var st pods.Stats
+	cfg := pods.Config{
+		FileInfo:     fileInfo,
+		Stats:        &st,
+		CounterDedup: pods.DedupNewest,
+		MinModTime:   base.Add(2 * time.Hour),
+	}
+	podlist := collect(t, &cfg, o1, o2)
+	got := summarize(podlist)
+	want := `o1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  o1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.42.2 o:0 p:42
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if want := []string{filepath.Join(o1, counterName("m1", 42, 1))}; fmt.Sprint(st.StaleFiles) != fmt.Sprint(want) {
+		t.Errorf("StaleFiles = %v, want %v", st.StaleFiles, want)
+	}
+	if want := []string{filepath.Join(o2, counterName("m1", 42, 2))}; fmt.Sprint(st.DuplicateCounterFiles) != fmt.Sprint(want) {
+		t.Errorf("DuplicateCounterFiles = %v, want %v", st.DuplicateCounterFiles, want)
+	}
+
+	// Errors from the hook are passed on.
+	delete(hours, filepath.Join(o2, counterName("m1", 42, 2)))
+	if _, err := cfg.CollectPods([]string{o1, o2}); err == nil {
+		t.Errorf("CollectPods with failing FileInfo succeeded, want error")
+	}
+}
+
+func TestForwardSlashes(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		sep  byte
+		want string
+	}{
+		{`C:\cov\o1\covmeta.abc`, '\\', "C:/cov/o1/covmeta.abc"},
+		{`\\server\share\covmeta.abc`, '\\', "//server/share/covmeta.abc"},
+		{"/cov/o1/covmeta.abc", '/', "/cov/o1/covmeta.abc"},
+		{`dir\with\backslash/covmeta.abc`, '/', `dir\with\backslash/covmeta.abc`},
+	} {
+		if got := pods.ForwardSlashes(tc.path, tc.sep); got != tc.want {
+			t.Errorf("ForwardSlashes(%q, %q) = %q, want %q", tc.path, tc.sep, got, tc.want)
+		}
+	}
+}
+
+func TestForwardSlashesOption(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1))
+	for _, fwd := range []bool{false, true} {
+		cfg := pods.Config{ForwardSlashes: fwd}
+		podlist, err := cfg.CollectPods([]string{o1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+			t.Fatalf("ForwardSlashes=%v: got pods %+v, want one pod with one counter data file", fwd, podlist)
+		}
+		p := podlist[0]
+		wantMeta := filepath.Join(o1, metaName("m1"))
+		wantCounter := filepath.Join(o1, counterName("m1", 42, 1))
+		if fwd {
+			wantMeta, wantCounter = filepath.ToSlash(wantMeta), filepath.ToSlash(wantCounter)
+		}
+		if p.MetaFile != wantMeta || p.CounterDataFiles[0] != wantCounter {
+			t.Errorf("ForwardSlashes=%v: got %s, %s; want %s, %s", fwd, p.MetaFile, p.CounterDataFiles[0], wantMeta, wantCounter)
+		}
+		if runtime.GOOS == "windows" && strings.Contains(p.MetaFile, `\`) == fwd {
+			t.Errorf("ForwardSlashes=%v: unexpected separators in %s", fwd, p.MetaFile)
+		}
+	}
+}
+
+func TestValidatePod(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m1", 2, 1))
+	podlist, err := pods.CollectPods([]string{dir, dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("expected 1 pod, got:\n%s", summarize(podlist))
+	}
+	p := podlist[0]
+	if err := pods.ValidatePod(p, 1); err != nil {
+		t.Errorf("ValidatePod of collected pod: %v", err)
+	}
+
+	bad := p
+	bad.Origins = []int{0, 2}
+	err = pods.ValidatePod(bad, 2)
+	if err == nil || !strings.Contains(err.Error(), "origin 2") || !strings.Contains(err.Error(), p.CounterDataFiles[1]) {
+		t.Errorf("ValidatePod with out-of-range origin: got %v, want error naming origin 2 and %s", err, p.CounterDataFiles[1])
+	}
+	bad.Origins = []int{-1, 0}
+	if err := pods.ValidatePod(bad, 2); err == nil {
+		t.Errorf("ValidatePod with negative origin: got nil error")
+	}
+	bad.Origins = []int{0}
+	if err := pods.ValidatePod(bad, 2); err == nil {
+		t.Errorf("ValidatePod with missing origin: got nil error")
+	}
+}
+
+func TestGroupByProcess(t *testing.T) {
+	o1 := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 7, 1), counterName("m1", 8, 1),
+		metaName("m2"), counterName("m2", 7, 2))
+	o2 := writeFiles(t, t.TempDir(), counterName("m2", 7, 3))
+	podlist, err := pods.CollectPods([]string{o1, o2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A hand-built pod whose counter file name can't be parsed.
+	podlist = append(podlist, pods.Pod{MetaFile: "m3", CounterDataFiles: []string{"junk"}})
+
+	groups := pods.GroupByProcess(podlist)
+	var got []string
+	for pid, refs := range groups {
+		for _, r := range refs {
+			got = append(got, fmt.Sprintf("%d %s %s o:%d", pid, filepath.Base(r.CounterFile), filepath.Base(r.MetaFile), r.Origin))
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"-1 junk m3 o:-1",
+		fmt.Sprintf("7 %s %s o:0", counterName("m1", 7, 1), metaName("m1")),
+		fmt.Sprintf("7 %s %s o:0", counterName("m2", 7, 2), metaName("m2")),
+		fmt.Sprintf("7 %s %s o:1", counterName("m2", 7, 3), metaName("m2")),
+		fmt.Sprintf("8 %s %s o:0", counterName("m1", 8, 1), metaName("m1")),
+	}
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GroupByProcess:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLogf(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m2", 2, 1))
+	var trace []string
+	cfg := pods.Config{
+		Logf: func(format string, args ...interface{}) {
+			msg := fmt.Sprintf(format, args...)
+			trace = append(trace, strings.ReplaceAll(msg, dir+string(filepath.Separator), ""))
+		},
+	}
+	collect(t, &cfg, dir)
+	h1 := strings.TrimPrefix(metaName("m1"), "covmeta.")
+	h2 := strings.TrimPrefix(metaName("m2"), "covmeta.")
+	// Files are traced in the order in which they are read, which
+	// for a single directory is sorted by name (m2 before m1).
+	want := []string{
+		counterName("m2", 2, 1) + ": classified as counter-data file, hash " + h2 + ", origin 0",
+		counterName("m1", 1, 1) + ": classified as counter-data file, hash " + h1 + ", origin 0",
+		metaName("m1") + ": classified as meta-data file, hash " + h1 + ", origin 0",
+		metaName("m1") + ": starts pod for hash " + h1,
+		counterName("m2", 2, 1) + ": orphan: skipping orphaned counter file",
+		counterName("m1", 1, 1) + ": grouped under hash " + h1 + " with meta-data file " + metaName("m1"),
+	}
+	if got := strings.Join(trace, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("trace:\ngot:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestErrorOnEmpty(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), "README")
+	var cfg pods.Config
+	podlist, err := cfg.CollectPods([]string{dir})
+	if err != nil || len(podlist) != 0 {
+		t.Errorf("CollectPods of empty directory = %v, %v; want no pods and nil error", podlist, err)
+	}
+	cfg.ErrorOnEmpty = true
+	if podlist, err := cfg.CollectPods([]string{dir}); err != pods.ErrNoPods {
+		t.Errorf("CollectPods of empty directory with ErrorOnEmpty = %v, %v; want ErrNoPods", podlist, err)
+	}
+
+	// Orphans alone don't make a pod.
+	writeFiles(t, dir, counterName("m1", 1, 1))
+	if _, err := cfg.CollectPods([]string{dir}); err != pods.ErrNoPods {
+		t.Errorf("CollectPods of directory with only orphans: got %v, want ErrNoPods", err)
+	}
+	writeFiles(t, dir, metaName("m1"))
+	if podlist, err := cfg.CollectPods([]string{dir}); err != nil || len(podlist) != 1 {
+		t.Errorf("CollectPods with one pod and ErrorOnEmpty = %v, %v; want one pod", podlist, err)
+	}
+}
+
+func TestClassifyAllocs(t *testing.T) {
+	name := counterName("m1", 12345, 1664556800123456789)
+	var cl pods.Classifier = pods.DefaultClassifier
+	allocs := testing.AllocsPerRun(100, func() {
+		if kind, _, pid, _ := cl.Classify(name); kind != pods.CounterDataFile || pid != 12345 {
+			t.Fatalf("Classify(%q) = %v, pid %d", name, kind, pid)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Classify of counter data file name: got %v allocs, want 0", allocs)
+	}
+}
+
+func TestNewPod(t *testing.T) {
+	dir := filepath.Join("some", "dir")
+	mf := filepath.Join(dir, metaName("m1"))
+	c1 := filepath.Join(dir, counterName("m1", 7, 100))
+	c2 := filepath.Join(dir, counterName("m1", 8, 200)+".gz")
+	p, err := pods.NewPod(mf, c1, c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pods.Pod{
+		MetaFile:          mf,
+		CounterDataFiles:  []string{c1, c2},
+		Origins:           []int{0, 0},
+		ProcessIDs:        []int{7, 8},
+		Sequences:         []int64{100, 200},
+		CounterCompressed: []bool{false, true},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("NewPod = %+v, want %+v", p, want)
+	}
+	// CollectPodsFromFiles gives the same pod for the same files.
+	if podlist := pods.CollectPodsFromFiles([]string{mf, c1}, false); len(podlist) != 1 ||
+		podlist[0].MetaFile != mf || !reflect.DeepEqual(podlist[0].CounterDataFiles, []string{c1}) {
+		t.Errorf("CollectPodsFromFiles of one pair:\n%s", summarize(podlist))
+	}
+	if p, err := pods.NewPod(mf); err != nil || len(p.CounterDataFiles) != 0 {
+		t.Errorf("NewPod with no counter data files = %+v, %v", p, err)
+	}
+
+	// Errors.
+	other := filepath.Join(dir, counterName("m2", 7, 100))
+	_, err = pods.NewPod(mf, c1, other)
+	if err == nil || !strings.Contains(err.Error(), other) {
+		t.Errorf("NewPod with mismatched hash: got %v, want error naming %s", err, other)
+	}
+	if _, err := pods.NewPod(c1, c1); err == nil {
+		t.Errorf("NewPod with counter data file as meta-data file: got nil error")
+	}
+	if _, err := pods.NewPod(mf, mf); err == nil {
+		t.Errorf("NewPod with meta-data file as counter data file: got nil error")
+	}
+	if _, err := pods.NewPod(mf, "covcounters.bad"); err == nil {
+		t.Errorf("NewPod with malformed counter data file name: got nil error")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	o1 := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m2", 2, 1),
+		counterName("m3", 4, 1), "README")
+	o2 := writeFiles(t, t.TempDir(), metaName("m2"), counterName("m2", 3, 1))
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	var got []string
+	fn := func(path string, fc pods.FileClass, err error) error {
+		if err != nil {
+			got = append(got, "error "+filepath.Base(path))
+			return nil
+		}
+		if filepath.Base(path) != fc.Name {
+			t.Errorf("path %s doesn't match name %s", path, fc.Name)
+		}
+		s := fmt.Sprintf("%s %s %v", filepath.Base(filepath.Dir(path)), fc.Name, fc.Kind)
+		if fc.Orphan {
+			s += " orphan"
+		}
+		got = append(got, s)
+		return nil
+	}
+	if err := pods.Walk([]string{o1, missing, o2, o1}, fn); err != nil {
+		t.Fatal(err)
+	}
+	// The counter data file for m2 in o1 pairs with the meta-data
+	// file in o2, as in CollectPods; only the one for m3 is an orphan.
+	d1, d2 := filepath.Base(o1), filepath.Base(o2)
+	want := []string{
+		d1 + " README non-coverage",
+		d1 + " " + counterName("m3", 4, 1) + " counter-data orphan",
+		d1 + " " + counterName("m2", 2, 1) + " counter-data",
+		d1 + " " + counterName("m1", 1, 1) + " counter-data",
+		d1 + " " + metaName("m1") + " meta-data",
+		"error missing",
+		d2 + " " + counterName("m2", 3, 1) + " counter-data",
+		d2 + " " + metaName("m2") + " meta-data",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Walk:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// SkipDir skips the rest of a directory, and SkipAll the rest of
+	// the walk.
+	for _, tc := range []struct {
+		stop error
+		n    int
+	}{
+		{fs.SkipDir, 3},
+		{fs.SkipAll, 1},
+	} {
+		n := 0
+		err := pods.Walk([]string{o1, o2}, func(path string, fc pods.FileClass, err error) error {
+			n++
+			if fc.Name == "README" {
+				return tc.stop
+			}
+			return nil
+		})
+		if err != nil || n != tc.n {
+			t.Errorf("Walk returning %v: got %d calls, error %v; want %d calls, no error", tc.stop, n, err, tc.n)
+		}
+	}
+
+	// Other errors stop the walk and are returned.
+	errStop := errors.New("stop")
+	err := pods.Walk([]string{missing, o1}, func(path string, fc pods.FileClass, err error) error {
+		if err == nil {
+			t.Errorf("Walk continued after error")
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("Walk returned %v, want %v", err, errStop)
+	}
+
+	// With a separated layout, the files of the subdirectories are
+	// walked, and files in the wrong subdirectory are not collected.
+	root := t.TempDir()
+	writeFiles(t, filepath.Join(root, "meta"), metaName("m1"), counterName("m1", 5, 1))
+	writeFiles(t, filepath.Join(root, "counters"), counterName("m1", 6, 1))
+	got = nil
+	cfg := pods.Config{MetaSubdir: "meta", CounterSubdir: "counters"}
+	if err := cfg.Walk([]string{root}, fn); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"meta " + counterName("m1", 5, 1) + " non-coverage",
+		"meta " + metaName("m1") + " meta-data",
+		"counters " + counterName("m1", 6, 1) + " counter-data",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Walk with separated layout:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDuplicateCountersAcrossDirs(t *testing.T) {
+	o1 := writeFiles(t, t.TempDir(), metaName("m1"), counterName("m1", 1, 1), counterName("m1", 2, 1))
+	o2 := writeFiles(t, t.TempDir(), counterName("m1", 1, 1), counterName("m1", 3, 1))
+	dup := filepath.Join(o2, counterName("m1", 1, 1))
+
+	// By default, the duplicate is collected but reported.
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	podlist := collect(t, &cfg, o1, o2)
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 4 {
+		t.Fatalf("expected 1 pod with 4 counter files, got:\n%s", summarize(podlist))
+	}
+	if !reflect.DeepEqual(st.DuplicateCountersAcrossDirs, []string{dup}) {
+		t.Errorf("DuplicateCountersAcrossDirs = %q, want %q", st.DuplicateCountersAcrossDirs, []string{dup})
+	}
+	if len(st.DuplicateCounterFiles) != 0 {
+		t.Errorf("DuplicateCounterFiles = %q, want none", st.DuplicateCounterFiles)
+	}
+
+	// With DedupFirst, only the copy from the first directory is kept.
+	cfg.CounterDedup = pods.DedupFirst
+	podlist = collect(t, &cfg, o1, o2)
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 3 {
+		t.Fatalf("expected 1 pod with 3 counter files, got:\n%s", summarize(podlist))
+	}
+	for k, cdf := range podlist[0].CounterDataFiles {
+		if cdf == dup {
+			t.Errorf("duplicate %s collected with origin %d", cdf, podlist[0].Origins[k])
+		}
+	}
+	if !reflect.DeepEqual(st.DuplicateCounterFiles, []string{dup}) || len(st.DuplicateCountersAcrossDirs) != 0 {
+		t.Errorf("with DedupFirst: DuplicateCounterFiles = %q, DuplicateCountersAcrossDirs = %q", st.DuplicateCounterFiles, st.DuplicateCountersAcrossDirs)
+	}
+}
+
+func TestFileAsInputDir(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(), metaName("m1"), counterName("m1", 1, 1), "README")
+	o2 := writeFiles(t, t.TempDir(), counterName("m1", 2, 1))
+	mf := filepath.Join(dir, metaName("m1"))
+	readme := filepath.Join(dir, "README")
+
+	for _, recursive := range []bool{false, true} {
+		cfg := pods.Config{Recursive: recursive}
+		_, err := cfg.CollectPods([]string{dir, readme})
+		var pe *fs.PathError
+		if !errors.Is(err, pods.ErrNotADirectory) || !errors.As(err, &pe) || pe.Path != readme {
+			t.Errorf("CollectPods with file input (recursive=%v): got %v, want ErrNotADirectory for %s", recursive, err, readme)
+		}
+	}
+
+	// With AllowFileInputs, coverage files are collected with their
+	// position in the input list as their origin.
+	cfg := pods.Config{AllowFileInputs: true}
+	podlist := collect(t, &cfg, mf, o2, filepath.Join(dir, counterName("m1", 1, 1)))
+	if len(podlist) != 1 || podlist[0].MetaFile != mf || len(podlist[0].CounterDataFiles) != 2 {
+		t.Fatalf("expected 1 pod for %s with 2 counter files, got:\n%s", mf, summarize(podlist))
+	}
+	for k, cdf := range podlist[0].CounterDataFiles {
+		want := 2
+		if filepath.Dir(cdf) == o2 {
+			want = 1
+		}
+		if o := podlist[0].Origins[k]; o != want {
+			t.Errorf("origin of %s = %d, want %d", cdf, o, want)
+		}
+	}
+	if _, err := cfg.CollectPods([]string{dir, readme}); !errors.Is(err, pods.ErrNotADirectory) {
+		t.Errorf("CollectPods with non-coverage file input and AllowFileInputs: got %v, want ErrNotADirectory", err)
+	}
+}
+
+func TestRebasePods(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"), metaName("m1"), counterName("m1", 1, 1))
+	o2 := writeFiles(t, filepath.Join(root, "sub", "o2"), metaName("m2"), counterName("m2", 2, 1), counterName("m1", 3, 1))
+	podlist, err := pods.CollectPods([]string{o1, o2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := summarize(podlist)
+
+	// Rebase onto a relative root, then back again.
+	if err := pods.RebasePods(podlist, root, "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range podlist {
+		for _, f := range append([]string{p.MetaFile}, p.CounterDataFiles...) {
+			if !strings.HasPrefix(f, "elsewhere"+string(filepath.Separator)) {
+				t.Errorf("rebased path %s not within new root", f)
+			}
+		}
+	}
+	if got, want := podlist[0].MetaFile, filepath.Join("elsewhere", "o1", metaName("m1")); got != want {
+		t.Errorf("rebased meta-data file = %s, want %s", got, want)
+	}
+	if err := pods.RebasePods(podlist, "elsewhere", root); err != nil {
+		t.Fatal(err)
+	}
+	if got := summarize(podlist); got != orig {
+		t.Errorf("round trip changed pods:\ngot:\n%s\nwant:\n%s", got, orig)
+	}
+
+	// A path outside the old root is an error, and nothing changes.
+	if err := pods.RebasePods(podlist, filepath.Join(root, "o"), "x"); err == nil {
+		t.Errorf("RebasePods with paths outside old root: got nil error")
+	}
+	if err := pods.RebasePods(podlist, o2, "x"); err == nil || !strings.Contains(err.Error(), metaName("m1")) {
+		t.Errorf("RebasePods with %s outside old root: got %v", podlist[0].MetaFile, err)
+	}
+	if got := summarize(podlist); got != orig {
+		t.Errorf("failed RebasePods changed pods:\ngot:\n%s\nwant:\n%s", got, orig)
+	}
+}
+
+func TestGlobalMaxCounterFiles(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m1", 2, 1), counterName("m1", 3, 1),
+		metaName("m2"), counterName("m2", 4, 1),
+		metaName("m3"))
+	var st pods.Stats
+	cfg := pods.Config{GlobalMaxCounterFiles: 3, Stats: &st}
+	podlist := collect(t, &cfg, dir)
+	d := filepath.Base(dir)
+	// Pods sort m3 (9678...), m2 (aaf2...), m1 (ae7b...); files are
+	// taken round-robin, so m2 and m1 contribute one each, then m1
+	// another.
+	want := d + "/" + metaName("m3") + " [\n" +
+		"]\n" +
+		d + "/" + metaName("m2") + " [\n" +
+		"  " + d + "/" + counterName("m2", 4, 1) + " o:0 p:4\n" +
+		"]\n" +
+		d + "/" + metaName("m1") + " [\n" +
+		"  " + d + "/" + counterName("m1", 1, 1) + " o:0 p:1\n" +
+		"  " + d + "/" + counterName("m1", 2, 1) + " o:0 p:2\n" +
+		"]\n"
+	if got := summarize(podlist); got != want {
+		t.Errorf("GlobalMaxCounterFiles=3:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	wantTrunc := []string{filepath.Join(dir, counterName("m1", 3, 1))}
+	if !reflect.DeepEqual(st.TruncatedCounterFiles, wantTrunc) {
+		t.Errorf("TruncatedCounterFiles = %q, want %q", st.TruncatedCounterFiles, wantTrunc)
+	}
+
+	// The selection is the same every time.
+	for i := 0; i < 3; i++ {
+		again, err := cfg.CollectPods([]string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := summarize(again); got != want {
+			t.Errorf("repeated collection selected different files:\n%s", got)
+		}
+	}
+
+	// A limit that isn't reached changes nothing.
+	cfg.GlobalMaxCounterFiles = 4
+	podlist = collect(t, &cfg, dir)
+	if n := len(podlist[1].CounterDataFiles) + len(podlist[2].CounterDataFiles); n != 4 || len(st.TruncatedCounterFiles) != 0 {
+		t.Errorf("GlobalMaxCounterFiles=4: got %d counter files, truncated %q", n, st.TruncatedCounterFiles)
+	}
+}
+
+func TestStrictCounterHashes(t *testing.T) {
+	h1 := strings.TrimPrefix(metaName("m1"), "covmeta.")
+	upper := "covcounters." + strings.ToUpper(h1) + ".7.1"
+	short := "covcounters." + h1[:10] + ".8.1"
+	dir := writeFiles(t, t.TempDir(), metaName("m1"), counterName("m1", 42, 1), upper, short)
+
+	// By default, the files are orphans.
+	var st pods.Stats
+	cfg := pods.Config{Stats: &st}
+	collect(t, &cfg, dir)
+	if len(st.OrphanCounterFiles) != 2 || len(st.MalformedCounterNames) != 0 {
+		t.Errorf("without StrictCounterHashes: orphans %q, malformed %q", st.OrphanCounterFiles, st.MalformedCounterNames)
+	}
+
+	cfg = pods.Config{Warn: true, Stats: &st, StrictCounterHashes: true}
+	var podlist []pods.Pod
+	out := captureStderr(t, func() { podlist = collect(t, &cfg, dir) })
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+		t.Errorf("got pods:\n%s\nwant one pod with one counter data file", summarize(podlist))
+	}
+	wantMalformed := []string{filepath.Join(dir, upper), filepath.Join(dir, short)}
+	if fmt.Sprint(st.MalformedCounterNames) != fmt.Sprint(wantMalformed) || len(st.OrphanCounterFiles) != 0 {
+		t.Errorf("MalformedCounterNames = %v, OrphanCounterFiles = %v; want %v, none", st.MalformedCounterNames, st.OrphanCounterFiles, wantMalformed)
+	}
+	want := fmt.Sprintf("warning: %s: skipping counter file with malformed meta-data hash %q: %s\n", dir, strings.ToUpper(h1), upper) +
+		fmt.Sprintf("warning: %s: skipping counter file with malformed meta-data hash %q: %s\n", dir, h1[:10], short)
+	if out != want {
+		t.Errorf("got warnings:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1), counterName("m1", 2, 1),
+		metaName("m2"), counterName("m2", 3, 1),
+		metaName("m3"), counterName("m3", 4, 1), counterName("m3", 5, 1))
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := summarize(podlist)
+
+	multi := pods.Filter(podlist, func(p pods.Pod) bool { return p.NumProcesses() > 1 })
+	if len(multi) != 2 {
+		t.Fatalf("expected 2 pods with several processes, got:\n%s", summarize(multi))
+	}
+	// Kept pods are in their original order (m3, then m1).
+	if filepath.Base(multi[0].MetaFile) != metaName("m3") || filepath.Base(multi[1].MetaFile) != metaName("m1") {
+		t.Errorf("Filter changed order:\n%s", summarize(multi))
+	}
+	if got := summarize(podlist); got != orig {
+		t.Errorf("Filter modified its input:\n%s", got)
+	}
+	if none := pods.Filter(podlist, func(pods.Pod) bool { return false }); len(none) != 0 {
+		t.Errorf("Filter keeping nothing returned:\n%s", summarize(none))
+	}
+}
+
+func TestPodComparators(t *testing.T) {
+	fixture := func() pods.PodSlice {
+		return pods.PodSlice{
+			{
+				MetaFile:         filepath.Join("a", metaName("m1")),
+				CounterDataFiles: []string{filepath.Join("a", counterName("m1", 1, 1))},
+				Origins:          []int{2},
+			},
+			{
+				MetaFile: filepath.Join("b", metaName("m2")),
+				CounterDataFiles: []string{
+					filepath.Join("b", counterName("m2", 2, 1)),
+					filepath.Join("b", counterName("m2", 3, 1)),
+					filepath.Join("b", counterName("m2", 4, 1)),
+				},
+				Origins: []int{1, 0, 1},
+			},
+			{
+				MetaFile: filepath.Join("c", metaName("m3")),
+			},
+		}
+	}
+	dirs := func(x pods.PodSlice) string {
+		var s []string
+		for _, p := range x {
+			s = append(s, filepath.Dir(p.MetaFile))
+		}
+		return strings.Join(s, " ")
+	}
+
+	x := fixture()
+	sort.Sort(x)
+	if got, want := dirs(x), "c b a"; got != want {
+		t.Errorf("sort.Sort: got %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		name string
+		less pods.PodLess
+		want string
+	}{
+		{"ByMetaFile", pods.ByMetaFile(), "a b c"},
+		{"ByCounterCount", pods.ByCounterCount(), "b a c"},
+		{"ByOrigin", pods.ByOrigin(), "b a c"},
+		{"OrderByMetaFile", pods.OrderByMetaFile.Less(), "a b c"},
+		{"OrderByCounterFileCount", pods.OrderByCounterFileCount.Less(), "b a c"},
+	}
+	for _, tc := range tests {
+		x := fixture()
+		// Start from hash order, which differs from each want.
+		sort.Sort(x)
+		x.SortBy(tc.less)
+		if got := dirs(x); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+	if pods.OrderByTotalBytes.Less() != nil {
+		t.Errorf("OrderByTotalBytes.Less() returned non-nil comparator")
+	}
+
+	// Ties are broken by meta-data file.
+	tie := pods.PodSlice{
+		{MetaFile: "y", Origins: []int{0}, CounterDataFiles: []string{"y1"}},
+		{MetaFile: "x", Origins: []int{0}, CounterDataFiles: []string{"x1"}},
+	}
+	tie.SortBy(pods.ByOrigin())
+	if tie[0].MetaFile != "x" {
+		t.Errorf("ByOrigin tie: got %s first, want x", tie[0].MetaFile)
+	}
+	tie.SortBy(pods.ByCounterCount())
+	if tie[0].MetaFile != "x" {
+		t.Errorf("ByCounterCount tie: got %s first, want x", tie[0].MetaFile)
+	}
+}
+
+func TestNameRewriter(t *testing.T) {
+	legacy := counterName("m1", 1, 1) + ".bak"
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), legacy, counterName("m1", 2, 1),
+		"skip."+counterName("m1", 3, 1))
+
+	// Without a rewriter, the legacy file is not recognized.
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+		t.Fatalf("without rewriter, got pods:\n%s\nwant one pod with one counter data file", summarize(podlist))
+	}
+
+	var rewritten []string
+	cfg := pods.Config{
+		NameRewriter: func(name string) string {
+			rewritten = append(rewritten, name)
+			if strings.HasPrefix(name, "skip.") {
+				return ""
+			}
+			return strings.TrimSuffix(name, ".bak")
+		},
+	}
+	podlist = collect(t, &cfg, dir)
+	if len(podlist) != 1 {
+		t.Fatalf("got pods:\n%s\nwant one pod", summarize(podlist))
+	}
+	// Pods refer to the files by their actual names.
+	want := []string{filepath.Join(dir, legacy), filepath.Join(dir, counterName("m1", 2, 1))}
+	if got := podlist[0].CounterDataFiles; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got counter data files %v, want %v", got, want)
+	}
+	if got := podlist[0].ProcessIDs; fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("got process IDs %v, want [1 2]", got)
+	}
+	if len(rewritten) != 4 {
+		t.Errorf("rewriter called for %v, want all 4 files", rewritten)
+	}
+}
+
+func TestCollectPodGroups(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 1, 1),
+		metaName("m2"), counterName("m2", 2, 1), counterName("m2", 3, 1),
+		metaName("m3"), counterName("m3", 4, 1))
+	h1 := strings.TrimPrefix(metaName("m1"), "covmeta.")
+	h3 := strings.TrimPrefix(metaName("m3"), "covmeta.")
+
+	describe := func(groups []pods.PodGroup) string {
+		var sb strings.Builder
+		for _, g := range groups {
+			fmt.Fprintf(&sb, "%s:\n%s", g.Key, summarize(g.Pods))
+		}
+		return sb.String()
+	}
+
+	// By default, each pod is a group of its own, keyed by hash.
+	var cfg pods.Config
+	groups, err := cfg.CollectPodGroups([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 3 || groups[0].Key != h3 || groups[2].Key != h1 {
+		t.Errorf("default grouping:\n%s", describe(groups))
+	}
+
+	// Programs m1 and m2 are two builds of the same binary.
+	cfg.GroupKey = func(mf string) (string, error) {
+		switch filepath.Base(mf) {
+		case metaName("m1"), metaName("m2"):
+			return "app", nil
+		case metaName("m3"):
+			return "tool", nil
+		}
+		return "", fmt.Errorf("unknown program")
+	}
+	groups, err = cfg.CollectPodGroups([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Base(dir)
+	want := fmt.Sprintf(`app:
+%[1]s/%[2]s [
+  %[1]s/%[3]s o:0 p:2
+  %[1]s/%[4]s o:0 p:3
+]
+%[1]s/%[5]s [
+  %[1]s/%[6]s o:0 p:1
+]
+tool:
+%[1]s/%[7]s [
+  %[1]s/%[8]s o:0 p:4
+]
+`, base, metaName("m2"), counterName("m2", 2, 1), counterName("m2", 3, 1),
+		metaName("m1"), counterName("m1", 1, 1),
+		metaName("m3"), counterName("m3", 4, 1))
+	if got := describe(groups); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// An error from GroupKey fails the collection.
+	errNoSidecar := errors.New("no sidecar file")
+	cfg.GroupKey = func(string) (string, error) { return "", errNoSidecar }
+	if _, err := cfg.CollectPodGroups([]string{dir}); !errors.Is(err, errNoSidecar) {
+		t.Errorf("got error %v, want GroupKey failure", err)
+	}
+}
+
+func TestControlCharacterNames(t *testing.T) {
+	h1 := strings.TrimPrefix(metaName("m1"), "covmeta.")
+	esc := "covcounters." + h1[:8] + "\x1b[2J" + h1[8:] + ".42.1"
+	bs := "covcounters." + h1[:8] + "\\" + h1[8:] + ".43.1"
+	del := "covcounters.\x7f" + h1 + ".44.1"
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"), counterName("m1", 41, 1), esc, bs, del,
+		"covmeta.\x01"+h1)
+
+	var st pods.Stats
+	cfg := pods.Config{Warn: true, Stats: &st}
+	var podlist []pods.Pod
+	out := captureStderr(t, func() { podlist = collect(t, &cfg, dir) })
+
+	// The legitimate files are unaffected.
+	if len(podlist) != 1 || fmt.Sprint(podlist[0].ProcessIDs) != "[41]" {
+		t.Errorf("got pods:\n%s\nwant one pod with pid 41", summarize(podlist))
+	}
+	if len(st.MalformedCounterNames) != 3 || len(st.MalformedMetaNames) != 1 || len(st.OrphanCounterFiles) != 0 {
+		t.Errorf("MalformedCounterNames = %q, MalformedMetaNames = %q, OrphanCounterFiles = %q; want 3, 1, none",
+			st.MalformedCounterNames, st.MalformedMetaNames, st.OrphanCounterFiles)
+	}
+	for _, c := range out {
+		if c < ' ' && c != '\n' || c == 0x7f {
+			t.Errorf("warnings contain control character %q:\n%q", c, out)
+			break
+		}
+	}
+	if !strings.Contains(out, strconv.Quote(esc)) {
+		t.Errorf("warnings don't quote %q:\n%s", esc, out)
+	}
+
+	// NUL bytes can't appear in names on disk, but can in lists of
+	// files from other sources.
+	nul := "covcounters." + h1 + "\x00.45.1"
+	podlist = pods.CollectPodsFromFiles([]string{metaName("m1"), nul, counterName("m1", 46, 1)}, false)
+	if len(podlist) != 1 || fmt.Sprint(podlist[0].ProcessIDs) != "[46]" {
+		t.Errorf("got pods:\n%s\nwant one pod with pid 46", summarize(podlist))
+	}
+	if kind, _, _, _ := pods.DefaultClassifier.Classify(nul); kind != pods.MalformedCounterDataFile {
+		t.Errorf("Classify(%q) = %v, want %v", nul, kind, pods.MalformedCounterDataFile)
+	}
+}
+
+func TestInApplyOrder(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		metaName("m1"),
+		counterName("m1", 10, 100), counterName("m1", 10, 500),
+		counterName("m1", 9, 200), counterName("m1", 10, 200),
+		counterName("m1", 9, 300))
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("got pods:\n%s\nwant one", summarize(podlist))
+	}
+	p := podlist[0]
+	var want []string
+	for _, c := range []struct {
+		pid int
+		seq int64
+	}{{10, 100}, {9, 200}, {10, 200}, {9, 300}, {10, 500}} {
+		want = append(want, filepath.Join(dir, counterName("m1", c.pid, c.seq)))
+	}
+	if files, fellBack := p.InApplyOrder(); fellBack || fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("InApplyOrder() = %v, %v; want %v, false", files, fellBack, want)
+	}
+	// CounterDataFiles itself stays in path order.
+	if !sort.StringsAreSorted(p.CounterDataFiles) {
+		t.Errorf("CounterDataFiles not in path order: %v", p.CounterDataFiles)
+	}
+
+	// Without Sequences, sequence values are parsed from the names.
+	p.Sequences = nil
+	if files, fellBack := p.InApplyOrder(); fellBack || fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("without Sequences, InApplyOrder() = %v, %v; want %v, false", files, fellBack, want)
+	}
+
+	// A name without a sequence value makes it fall back to path order.
+	bogus := filepath.Join(dir, "covcounters.bogus")
+	p.CounterDataFiles = append([]string{bogus}, p.CounterDataFiles...)
+	want = append([]string(nil), p.CounterDataFiles...)
+	sort.Strings(want)
+	if files, fellBack := p.InApplyOrder(); !fellBack || fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("with unparseable name, InApplyOrder() = %v, %v; want %v, true", files, fellBack, want)
+	}
+	if p.CounterDataFiles[0] != bogus {
+		t.Errorf("InApplyOrder reordered CounterDataFiles: %v", p.CounterDataFiles)
+	}
 }
@@ -0,0 +1,134 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package podsx
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"internal/coverage"
+	"internal/coverage/pods"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ValidateModeCompatibility checks that the pods in 'podlist' can be
+// merged with one another, which requires that they were all built
+// with the same counter mode (set, count or atomic) and counter
+// granularity, as recorded in the header of each pod's meta-data file.
+// It returns an error listing the meta-data hashes of the pods in
+// each conflicting mode if they were not, or if a meta-data file
+// can't be read. It is meant to be run as a precondition check before
+// feeding pods to a merger, such as the one in cmd/covdata.
+func ValidateModeCompatibility(podlist []pods.Pod) error {
+	hashes := make(map[podMode][]string)
+	for i := range podlist {
+		p := &podlist[i]
+		m, err := readPodMode(p)
+		if err != nil {
+			return err
+		}
+		hashes[m] = append(hashes[m], metaHash(p))
+	}
+	if len(hashes) <= 1 {
+		return nil
+	}
+	var groups []string
+	for m, hs := range hashes {
+		sort.Strings(hs)
+		groups = append(groups, fmt.Sprintf("%s: %s", m, strings.Join(hs, ", ")))
+	}
+	sort.Strings(groups)
+	return fmt.Errorf("pods have incompatible counter modes (%s)", strings.Join(groups, "; "))
+}
+
+// metaHash returns the meta-data hash of pod 'p', or the base name
+// of its meta-data file if the name can't be parsed.
+func metaHash(p *pods.Pod) string {
+	name := filepath.Base(p.MetaFile)
+	base := name
+	if p.MetaCompressed {
+		base = strings.TrimSuffix(base, ".gz")
+	}
+	if kind, hash, _, _ := pods.DefaultClassifier.Classify(base); kind == pods.MetaDataFile {
+		return hash
+	}
+	return name
+}
+
+// openMetaFile opens the meta-data file of pod 'p' for reading,
+// decompressing it if necessary.
+func openMetaFile(p *pods.Pod) (io.ReadCloser, error) {
+	f, err := os.Open(p.MetaFile)
+	if err != nil {
+		return nil, err
+	}
+	if !p.MetaCompressed {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		if isMalformed(err) {
+			return nil, &pods.FileParseError{File: p.MetaFile, Err: err}
+		}
+		return nil, fmt.Errorf("%s: %v", p.MetaFile, err)
+	}
+	return gzipFile{zr, f}, nil
+}
+
+// gzipFile is a decompressing reader for a gzip-compressed file.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// podMode is the counter mode and granularity of a pod.
+type podMode struct {
+	mode coverage.CounterMode
+	gran coverage.CounterGranularity
+}
+
+func (m podMode) String() string {
+	return m.mode.String() + "/" + m.gran.String()
+}
+
+// readPodMode reads the counter mode and granularity from the header
+// of the meta-data file of pod 'p'.
+func readPodMode(p *pods.Pod) (podMode, error) {
+	r, err := openMetaFile(p)
+	if err != nil {
+		return podMode{}, err
+	}
+	defer r.Close()
+	var hdr coverage.MetaFileHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		if isMalformed(err) {
+			return podMode{}, &pods.FileParseError{File: p.MetaFile, Err: fmt.Errorf("reading header: %v", err)}
+		}
+		return podMode{}, fmt.Errorf("reading header of meta-data file %s: %v", p.MetaFile, err)
+	}
+	if hdr.Magic != coverage.CovMetaMagic {
+		return podMode{}, &pods.FileParseError{File: p.MetaFile, Err: errors.New("invalid meta-data file magic string")}
+	}
+	return podMode{hdr.CMode, hdr.CGranularity}, nil
+}
+
+// isMalformed reports whether 'err', returned while decoding a file,
+// is due to the contents of the file (it ends too soon, or is not
+// valid gzip data) rather than a failure to read it.
+func isMalformed(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF ||
+		errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum)
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package podsx
+
+import (
+	"fmt"
+	"internal/coverage/decodemeta"
+	"internal/coverage/pods"
+	"os"
+)
+
+// CollectPodsWithPackages is like cfg.CollectPods, but also fills in
+// the Packages field of each pod using ReadPackages. A pod whose
+// meta-data file can't be decoded is kept with Packages left nil, and
+// a warning is written to stderr if cfg.Warn is set; it doesn't stop
+// the collection. cfg.OnPod, if set, sees the pods before Packages is
+// filled in.
+func CollectPodsWithPackages(cfg *pods.Config, dirs []string) ([]pods.Pod, error) {
+	podlist, err := cfg.CollectPods(dirs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range podlist {
+		p := &podlist[i]
+		pkgs, err := ReadPackages(p)
+		if err != nil {
+			if cfg.Logf != nil {
+				cfg.Logf("%s: package list not read: %v", p.MetaFile, err)
+			}
+			if cfg.Warn {
+				fmt.Fprintf(os.Stderr, "warning: can't read package list: %v\n", err)
+			}
+			continue
+		}
+		p.Packages = pkgs
+	}
+	return podlist, nil
+}
+
+// ReadPackages decodes the meta-data file of pod 'p' and returns the
+// import path of each package it describes, in the order they appear
+// there, so that callers can skip pods that don't involve packages of
+// interest. Compressed meta-data files are not supported.
+func ReadPackages(p *pods.Pod) ([]string, error) {
+	if p.MetaCompressed {
+		return nil, fmt.Errorf("%s: meta-data file is compressed", p.MetaFile)
+	}
+	f, err := os.Open(p.MetaFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mfr, err := decodemeta.NewCoverageMetaFileReader(f, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p.MetaFile, err)
+	}
+	pkgs := make([]string, 0, mfr.NumPackages())
+	var payload []byte
+	for pkIdx := uint32(0); uint64(pkIdx) < mfr.NumPackages(); pkIdx++ {
+		var pd *decodemeta.CoverageMetaDataDecoder
+		pd, payload, err = mfr.GetPackageDecoder(pkIdx, payload)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p.MetaFile, err)
+		}
+		pkgs = append(pkgs, pd.PackagePath())
+	}
+	return pkgs, nil
+}
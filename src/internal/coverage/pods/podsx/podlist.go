@@ -0,0 +1,66 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package podsx
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/coverage/pods"
+	"io"
+)
+
+// podListVersion is the version of the format written by
+// WritePodList.
+const podListVersion = 1
+
+// podList is the JSON form of a pod list, as written by WritePodList.
+type podList struct {
+	Version int
+	Pods    []pods.Pod
+}
+
+// WritePodList writes the pods in 'podlist' to 'w' as a JSON document
+// that records every field of each pod, along with a format version,
+// so that one process can collect pods and another can consume them
+// (with ReadPodList) without scanning directories again. Unlike a
+// manifest (see pods.WriteManifest), a pod list needs no further
+// collection when read back.
+func WritePodList(w io.Writer, podlist []pods.Pod) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(podList{Version: podListVersion, Pods: podlist})
+}
+
+// ReadPodList reads a pod list written by WritePodList from 'r'. An
+// error is returned if the list was written in a different version
+// of the format, or if the per-file fields of a pod don't line up
+// with its counter data files. A pod whose origins are missing from
+// the list is given origins of -1 (unknown).
+func ReadPodList(r io.Reader) ([]pods.Pod, error) {
+	var pl podList
+	if err := json.NewDecoder(r).Decode(&pl); err != nil {
+		return nil, fmt.Errorf("reading pod list: %v", err)
+	}
+	if pl.Version != podListVersion {
+		return nil, fmt.Errorf("pod list has format version %d, want version %d", pl.Version, podListVersion)
+	}
+	for k := range pl.Pods {
+		p := &pl.Pods[k]
+		n := len(p.CounterDataFiles)
+		if (p.Origins != nil && len(p.Origins) != n) ||
+			(p.ProcessIDs != nil && len(p.ProcessIDs) != n) ||
+			(p.Sequences != nil && len(p.Sequences) != n) ||
+			(p.CounterCompressed != nil && len(p.CounterCompressed) != n) {
+			return nil, fmt.Errorf("pod list entry %d (%s): per-file fields don't match %d counter data files", k, p.MetaFile, n)
+		}
+		if p.Origins == nil {
+			p.Origins = make([]int, n)
+			for i := range p.Origins {
+				p.Origins[i] = -1
+			}
+		}
+	}
+	return pl.Pods, nil
+}
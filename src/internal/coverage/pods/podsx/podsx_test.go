@@ -0,0 +1,338 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package podsx_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"internal/coverage"
+	"internal/coverage/encodemeta"
+	"internal/coverage/pods"
+	"internal/coverage/pods/podsx"
+	"internal/coverage/slicewriter"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates empty files with the specified names in 'dir',
+// returning 'dir'.
+func writeFiles(t *testing.T, dir string, names ...string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range names {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte("foo"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// metaName and counterName return the names of the meta-data and
+// counter data files for a program identified by 'tag'.
+func metaName(tag string) string {
+	return pods.MetaFileName(fmt.Sprintf("%x", md5.Sum([]byte(tag))))
+}
+
+func counterName(tag string, pid int, nt int64) string {
+	return pods.CounterFileName(fmt.Sprintf("%x", md5.Sum([]byte(tag))), pid, nt)
+}
+
+// summarize returns a compact description of a pod list, listing
+// for each pod the meta-data file and each counter data file as
+// "<parent dir>/<base name>", along with origin and pid.
+func summarize(podlist []pods.Pod) string {
+	trim := func(path string) string {
+		return filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+	}
+	var sb strings.Builder
+	for _, p := range podlist {
+		fmt.Fprintf(&sb, "%s [\n", trim(p.MetaFile))
+		for k, df := range p.CounterDataFiles {
+			fmt.Fprintf(&sb, "  %s o:%d p:%d\n", trim(df), p.Origins[k], p.ProcessIDs[k])
+		}
+		sb.WriteString("]\n")
+	}
+	return sb.String()
+}
+
+func TestCollectPodsFromZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{
+		"run-2/",
+		"run-2/" + counterName("m1", 20, 1),
+		"run-1/" + metaName("m1"),
+		"run-1/" + counterName("m1", 10, 1),
+		"run-1/" + counterName("orphan", 11, 1),
+		"run-1/README",
+		// Written by some Windows tools.
+		`run-3\` + metaName("m2"),
+		`run-3\` + counterName("m2", 30, 1),
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, name)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	podlist, err := podsx.CollectPodsFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summarize(podlist)
+	want := `run-1/covmeta.ae7be26cdaa742ca148068d5ac90eaca [
+  run-1/covcounters.ae7be26cdaa742ca148068d5ac90eaca.10.1 o:0 p:10
+  run-2/covcounters.ae7be26cdaa742ca148068d5ac90eaca.20.1 o:1 p:20
+]
+run-3/covmeta.aaf2f89992379705dac844c0a2a1d45f [
+  run-3/covcounters.aaf2f89992379705dac844c0a2a1d45f.30.1 o:2 p:30
+]
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The recorded paths can be used to open the archive members.
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range podlist {
+		for _, name := range append([]string{p.MetaFile}, p.CounterDataFiles...) {
+			f, err := zr.Open(name)
+			if err != nil {
+				t.Errorf("opening %s: %v", name, err)
+				continue
+			}
+			f.Close()
+		}
+	}
+}
+
+// writeMetaHeader writes a meta-data file for the program identified
+// by 'tag' to 'dir', consisting of just a header recording counter
+// mode 'cmode' and granularity 'cgran'.
+func writeMetaHeader(t *testing.T, dir, tag string, cmode coverage.CounterMode, cgran coverage.CounterGranularity) {
+	t.Helper()
+	var buf bytes.Buffer
+	hdr := coverage.MetaFileHeader{
+		Magic:        coverage.CovMetaMagic,
+		Version:      coverage.MetaFileVersion,
+		CMode:        cmode,
+		CGranularity: cgran,
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, metaName(tag)), buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateModeCompatibility(t *testing.T) {
+	dir := writeFiles(t, t.TempDir())
+	writeMetaHeader(t, dir, "m1", coverage.CtrModeSet, coverage.CtrGranularityPerBlock)
+	writeMetaHeader(t, dir, "m2", coverage.CtrModeSet, coverage.CtrGranularityPerBlock)
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := podsx.ValidateModeCompatibility(podlist); err != nil {
+		t.Errorf("ValidateModeCompatibility with matching modes: %v", err)
+	}
+
+	writeMetaHeader(t, dir, "m3", coverage.CtrModeCount, coverage.CtrGranularityPerBlock)
+	podlist, err = pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = podsx.ValidateModeCompatibility(podlist)
+	want := "pods have incompatible counter modes (count/perblock: 9678f7a7939f457fa0d9353761e189c7; set/perblock: aaf2f89992379705dac844c0a2a1d45f, ae7be26cdaa742ca148068d5ac90eaca)"
+	if err == nil || err.Error() != want {
+		t.Errorf("ValidateModeCompatibility with mixed modes:\ngot  %v\nwant %s", err, want)
+	}
+
+	// Pods with meta-data files that aren't meta-data files can't be
+	// checked.
+	writeFiles(t, dir, metaName("m4"))
+	podlist, err = pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = podsx.ValidateModeCompatibility(podlist)
+	var perr *pods.FileParseError
+	if !errors.As(err, &perr) || perr.File != filepath.Join(dir, metaName("m4")) {
+		t.Errorf("ValidateModeCompatibility with bogus meta-data file: got %v, want FileParseError for %s", err, metaName("m4"))
+	}
+}
+
+func TestPodListRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	o1 := writeFiles(t, filepath.Join(root, "o1"),
+		metaName("m1"), counterName("m1", 42, 1), metaName("m2"))
+	o2 := writeFiles(t, filepath.Join(root, "o2"),
+		counterName("m1", 43, 2), counterName("m2", 44, 3))
+	cfg := pods.Config{OriginLabels: []string{"first", "second"}}
+	podlist, err := cfg.CollectPods([]string{o1, o2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := podsx.WritePodList(&buf, podlist); err != nil {
+		t.Fatal(err)
+	}
+	got, err := podsx.ReadPodList(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, podlist) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", got, podlist)
+	}
+
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`{"Version": 2, "Pods": []}`, "pod list has format version 2, want version 1"},
+		{`{"Pods": []}`, "pod list has format version 0, want version 1"},
+		{`{"Version": 1, "Pods": [{"MetaFile": "m", "CounterDataFiles": ["c"], "Origins": [0, 1]}]}`,
+			"pod list entry 0 (m): per-file fields don't match 1 counter data files"},
+	} {
+		_, err := podsx.ReadPodList(strings.NewReader(tc.in))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("ReadPodList(%s): got error %v, want %q", tc.in, err, tc.want)
+		}
+	}
+
+	// Missing origins are filled in as unknown.
+	got, err = podsx.ReadPodList(strings.NewReader(`{"Version": 1, "Pods": [{"MetaFile": "m", "CounterDataFiles": ["c1", "c2"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := got[0].Origins; fmt.Sprint(o) != "[-1 -1]" {
+		t.Errorf("ReadPodList with no origins: got Origins %v, want [-1 -1]", o)
+	}
+}
+
+// writeMetaFile writes a meta-data file for the program identified
+// by 'tag' to 'dir', describing a package (with one function) for
+// each of the import paths 'pkgpaths'.
+func writeMetaFile(t *testing.T, dir, tag string, pkgpaths ...string) {
+	t.Helper()
+	var blobs [][]byte
+	for _, pp := range pkgpaths {
+		b, err := encodemeta.NewCoverageMetaDataBuilder(pp, filepath.Base(pp), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AddFunc(coverage.FuncDesc{
+			Funcname: "f",
+			Srcfile:  "f.go",
+			Units:    []coverage.CoverableUnit{{StLine: 1, StCol: 1, EnLine: 2, EnCol: 2, NxStmts: 1}},
+		})
+		var ws slicewriter.WriteSeeker
+		if _, err := b.Emit(&ws); err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, ws.BytesWritten())
+	}
+	path := filepath.Join(dir, metaName(tag))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfw := encodemeta.NewCoverageMetaFileWriter(path, f)
+	if err := mfw.Write(md5.Sum([]byte(tag)), blobs, coverage.CtrModeSet, coverage.CtrGranularityPerBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadPackages(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		counterName("m1", 42, 1),
+		// Not a real meta-data file, so it can't be decoded.
+		metaName("m2"), counterName("m2", 43, 1))
+	writeMetaFile(t, dir, "m1", "example.com/a", "example.com/a/b")
+
+	podlist, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range podlist {
+		p := &podlist[i]
+		got, err := podsx.ReadPackages(p)
+		switch filepath.Base(p.MetaFile) {
+		case metaName("m1"):
+			if want := []string{"example.com/a", "example.com/a/b"}; err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadPackages(%s) = %v, %v, want %v", p.MetaFile, got, err, want)
+			}
+		case metaName("m2"):
+			if err == nil || !strings.HasPrefix(err.Error(), p.MetaFile+": ") {
+				t.Errorf("ReadPackages(%s) = %v, %v, want error naming the file", p.MetaFile, got, err)
+			}
+		}
+	}
+
+	p := podlist[0]
+	p.MetaCompressed = true
+	if _, err := podsx.ReadPackages(&p); err == nil {
+		t.Errorf("ReadPackages with compressed meta-data file succeeded")
+	}
+}
+
+func TestCollectPodsWithPackages(t *testing.T) {
+	dir := writeFiles(t, t.TempDir(),
+		counterName("m1", 42, 1),
+		// Not a real meta-data file, so it can't be decoded.
+		metaName("m2"), counterName("m2", 43, 1))
+	writeMetaFile(t, dir, "m1", "example.com/a", "example.com/a/b")
+
+	var logs []string
+	cfg := pods.Config{Logf: func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}}
+	podlist, err := podsx.CollectPodsWithPackages(&cfg, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podlist) != 2 {
+		t.Fatalf("got %d pods, want 2", len(podlist))
+	}
+	for _, p := range podlist {
+		var want []string
+		if filepath.Base(p.MetaFile) == metaName("m1") {
+			want = []string{"example.com/a", "example.com/a/b"}
+		}
+		if !reflect.DeepEqual(p.Packages, want) {
+			t.Errorf("pod %s: got Packages %v, want %v", p.MetaFile, p.Packages, want)
+		}
+	}
+	m2 := filepath.Join(dir, metaName("m2"))
+	found := false
+	for _, l := range logs {
+		if strings.HasPrefix(l, m2+": package list not read: ") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no log of unreadable package list for %s in:\n%s", m2, strings.Join(logs, "\n"))
+	}
+}
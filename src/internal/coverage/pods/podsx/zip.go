@@ -0,0 +1,58 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package podsx provides helpers for coverage tools working with the
+// pods collected by package pods, for features that need more of the
+// standard library than the runtime can afford to import.
+package podsx
+
+import (
+	"archive/zip"
+	"internal/coverage/pods"
+	"io"
+	"path"
+	"strings"
+)
+
+// CollectPodsFromZip is similar to pods.CollectPods, but collects pods
+// from the members of the zip archive of size 'size' that can be read
+// from 'r'. Each directory within the archive plays the role of an
+// input directory (see pods.Config.CollectPodsFromFS). The file names
+// recorded in the returned pods are member paths within the archive
+// (cleaned and using forward slashes), suitable for passing to the
+// Open method of a zip.Reader for the archive.
+func CollectPodsFromZip(r io.ReaderAt, size int64, warn bool) ([]pods.Pod, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	cfg := pods.Config{Warn: warn}
+	return CollectPodsFromZipReader(&cfg, zr)
+}
+
+// CollectPodsFromZipReader is similar to CollectPodsFromZip, but
+// reads from an already opened archive and collects pods according
+// to the settings in 'cfg'.
+func CollectPodsFromZipReader(cfg *pods.Config, zr *zip.Reader) ([]pods.Pod, error) {
+	names := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		names = append(names, archiveName(f.Name))
+	}
+	return cfg.CollectPodsFromFS(zr, names)
+}
+
+// archiveName converts the name of an archive member to a clean,
+// slash-separated relative path, following zip.Reader's handling of
+// names for its Open method.
+func archiveName(name string) string {
+	name = strings.ReplaceAll(name, `\`, `/`)
+	p := strings.TrimPrefix(path.Clean(name), "/")
+	for strings.HasPrefix(p, "../") {
+		p = p[len("../"):]
+	}
+	return p
+}
@@ -0,0 +1,350 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A dirScan holds the options and results of a scan of input
+// directories by scanDirs.
+type dirScan struct {
+	cache *dirCache // directory cache, or nil
+
+	// visit, if non-nil, is called with the coverage files found in
+	// each directory as soon as the directory has been read, and may
+	// stop the scan by returning an error. With discard set, the
+	// files are dropped once visited instead of being accumulated.
+	visit   func(dir string, files []covFile) error
+	discard bool
+
+	// all causes files that are not collected (including files that
+	// are not coverage files) to be recorded as NonCoverageFile.
+	all bool
+
+	// dirErr, if non-nil, is called when a directory can't be read;
+	// the scan skips the directory and goes on if it returns nil.
+	dirErr func(dir string, err error) error
+
+	found   int      // number of files found
+	empty   []string // input directories with no coverage files
+	missing []string // input directories skipped as missing
+}
+
+// visited passes the files found in directory 'dir', files[start:],
+// to sc.visit, returning 'files' with them dropped if sc.discard is
+// set.
+func (sc *dirScan) visited(files []covFile, start int, dir string) ([]covFile, error) {
+	sc.found += len(files) - start
+	if sc.visit == nil {
+		return files, nil
+	}
+	if err := sc.visit(dir, files[start:]); err != nil {
+		return nil, err
+	}
+	if sc.discard {
+		files = files[:start]
+	}
+	return files, nil
+}
+
+// failed passes the failure 'err' to read directory 'dir' to
+// sc.dirErr, returning the error that should end the scan, if any.
+func (sc *dirScan) failed(dir string, err error) error {
+	if sc.dirErr == nil {
+		return err
+	}
+	return sc.dirErr(dir, err)
+}
+
+// scanDirs reads the input directories 'dirs', appending the coverage
+// files found to 'files'. It is the one directory scan behind
+// CollectPods, WalkCounterFiles and Walk: duplicate directories are
+// read once, missing ones are skipped if cfg.SkipMissingDirs is set,
+// and each directory is read according to cfg.MetaSubdir,
+// cfg.CounterSubdir, cfg.Recursive and cfg.AllowFileInputs.
+func (cfg *Config) scanDirs(files []covFile, dirs []string, sc *dirScan) ([]covFile, error) {
+	if err := cfg.checkExcludeGlobs(); err != nil {
+		return nil, err
+	}
+	first, err := cfg.firstDirs(dirs)
+	if err != nil {
+		return nil, err
+	}
+	for k, dir := range dirs {
+		if first[k] != k {
+			continue
+		}
+		if cfg.SkipMissingDirs {
+			if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+				if cfg.Warn {
+					warning("skipping missing input directory %s", dir)
+				}
+				sc.missing = append(sc.missing, dir)
+				continue
+			}
+		}
+		found := sc.found
+		var more []covFile
+		if cfg.AllowFileInputs && isFile(dir) {
+			more, err = cfg.inputFile(files, dir, sc, k)
+		} else {
+			more, err = cfg.readInputDir(files, dir, sc, k, len(dirs))
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = more
+		if sc.found == found {
+			sc.empty = append(sc.empty, dir)
+		}
+	}
+	return files, nil
+}
+
+// readInputDir reads the input directory 'dir', with index 'k' in
+// the list of 'ndirs' input directories, and its subdirectories if
+// cfg.Recursive is set (or its meta-data and counter data
+// subdirectories, for a separated layout), appending the coverage
+// files found to 'files'.
+func (cfg *Config) readInputDir(files []covFile, dir string, sc *dirScan, k, ndirs int) ([]covFile, error) {
+	if cfg.MetaSubdir != "" || cfg.CounterSubdir != "" {
+		more, err := cfg.readDir(files, filepath.Join(dir, cfg.MetaSubdir), sc, k, ndirs, MetaDataFile)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.readDir(more, filepath.Join(dir, cfg.CounterSubdir), sc, k, ndirs, CounterDataFile)
+	}
+	if !cfg.Recursive {
+		return cfg.readDir(files, dir, sc, k, ndirs, anyKind)
+	}
+	subdirs, err := cfg.walkDirs(dir)
+	if err != nil {
+		return files, sc.failed(dir, err)
+	}
+	for _, sd := range subdirs {
+		if files, err = cfg.readDir(files, sd, sc, k, ndirs, anyKind); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// inputFile handles the input "directory" 'path', which is a file,
+// for cfg.AllowFileInputs: if it is a coverage file, it is appended
+// to 'files' with origin 'origin'; otherwise it is reported as not
+// being a directory.
+func (cfg *Config) inputFile(files []covFile, path string, sc *dirScan, origin int) ([]covFile, error) {
+	cfiles, err := cfg.listedFiles([]string{path}, []int{origin})
+	if err != nil {
+		return nil, err
+	}
+	if len(cfiles) == 0 {
+		return files, sc.failed(path, notADirectory(path))
+	}
+	start := len(files)
+	return sc.visited(append(files, cfiles...), start, path)
+}
+
+// isFile reports whether 'path' names an existing file that is not a
+// directory.
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
+
+// notADirectory returns the error reported for an input directory
+// 'dir' that is not a directory.
+func notADirectory(dir string) error {
+	return &DirReadError{Dir: dir, Err: &fs.PathError{Op: "collect", Path: dir, Err: ErrNotADirectory}}
+}
+
+// firstDirs returns a slice giving, for each directory in 'dirs', the
+// index of the first element of 'dirs' that names the same directory.
+// Names are compared after cleaning, and after resolving symbolic
+// links if cfg.ResolveSymlinks is set.
+func (cfg *Config) firstDirs(dirs []string) ([]int, error) {
+	first := make([]int, len(dirs))
+	seen := make(map[string]int, len(dirs))
+	for k, dir := range dirs {
+		key := filepath.Clean(dir)
+		if cfg.ResolveSymlinks {
+			var err error
+			if key, err = filepath.EvalSymlinks(key); err != nil {
+				return nil, err
+			}
+		}
+		if j, ok := seen[key]; ok {
+			first[k] = j
+			continue
+		}
+		seen[key] = k
+		first[k] = k
+	}
+	return first, nil
+}
+
+// walkDirs returns 'dir' and the directories below it, down to
+// cfg.MaxDepth levels if set, in lexical order.
+func (cfg *Config) walkDirs(dir string) ([]string, error) {
+	var dirs []string
+	root := filepath.Clean(dir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return &DirReadError{Dir: path, Err: err}
+		}
+		if !d.IsDir() {
+			if path == root {
+				if fi, err := os.Stat(root); err == nil && !fi.IsDir() {
+					return notADirectory(dir)
+				}
+			}
+			return nil
+		}
+		dirs = append(dirs, path)
+		if cfg.MaxDepth > 0 && path != root {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if depth := strings.Count(rel, string(filepath.Separator)) + 1; depth >= cfg.MaxDepth {
+				return fs.SkipDir
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// anyKind is passed to readDir to request both meta-data files and
+// counter data files.
+const anyKind = NonCoverageFile
+
+// scanBatch is the number of entries read at a time from a directory
+// that isn't cached, so that the names of all the files in a very
+// large directory needn't be held in memory at once.
+const scanBatch = 1024
+
+// readDir reads the directory 'dir', appending to 'files' the
+// coverage files it contains (restricted to files of kind 'want'
+// unless 'want' is anyKind), recording 'origin' as their origin, and
+// passes them to sc.visited. Here 'ndirs' is the total number of
+// directories being read, used to size 'files' on first use. The
+// directory is read through sc.cache if non-nil; otherwise it is
+// read in batches of scanBatch entries, classifying each batch before
+// reading the next, and the files found are then sorted by name,
+// giving the same result as reading the whole directory at once.
+func (cfg *Config) readDir(files []covFile, dir string, sc *dirScan, origin, ndirs int, want FileKind) ([]covFile, error) {
+	start := len(files)
+	more, err := cfg.readDirFiles(files, dir, sc, origin, ndirs, want)
+	if err != nil {
+		return files[:start], sc.failed(dir, err)
+	}
+	return sc.visited(more, start, dir)
+}
+
+// readDirFiles does the reading for readDir.
+func (cfg *Config) readDirFiles(files []covFile, dir string, sc *dirScan, origin, ndirs int, want FileKind) ([]covFile, error) {
+	if sc.cache != nil {
+		dents, err := sc.cache.readDir(dir)
+		if err != nil {
+			return nil, dirError(dir, err)
+		}
+		if files == nil {
+			files = make([]covFile, 0, len(dents)*ndirs)
+		}
+		return cfg.appendDirFiles(files, dir, dents, origin, want, sc.all)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, dirError(dir, err)
+	}
+	defer f.Close()
+	start := len(files)
+	for {
+		dents, err := f.ReadDir(scanBatch)
+		if files == nil && len(dents) > 0 {
+			// Size for the first batch, which holds the whole
+			// directory unless it has scanBatch or more entries.
+			// (ReadDir returns io.EOF only with an empty batch.)
+			files = make([]covFile, 0, len(dents)*ndirs)
+		}
+		var ferr error
+		if files, ferr = cfg.appendDirFiles(files, dir, dents, origin, want, sc.all); ferr != nil {
+			return nil, ferr
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, dirError(dir, err)
+		}
+	}
+	found := files[start:]
+	sort.Slice(found, func(i, j int) bool { return found[i].path < found[j].path })
+	return files, nil
+}
+
+// dirError returns the error to report when directory 'dir' can't be
+// read because of 'err'.
+func dirError(dir string, err error) error {
+	if fi, serr := os.Stat(dir); serr == nil && !fi.IsDir() {
+		return notADirectory(dir)
+	}
+	return &DirReadError{Dir: dir, Err: err}
+}
+
+// appendDirFiles appends to 'files' the coverage files among the
+// entries 'dents' of directory 'dir', as described for readDir, and
+// with 'all' set, the other files as NonCoverageFile.
+func (cfg *Config) appendDirFiles(files []covFile, dir string, dents []fs.DirEntry, origin int, want FileKind, all bool) ([]covFile, error) {
+	cl := cfg.classifier()
+	prefix := dirPrefix(dir)
+	for _, e := range dents {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		kind, hash, pid, seq, gz := cfg.classify(cl, name)
+		if kind == NonCoverageFile || (want != anyKind && kind.base() != want) {
+			if all {
+				files = append(files, covFile{path: prefix + name, kind: NonCoverageFile, origin: origin})
+			}
+			continue
+		}
+		var stale bool
+		var size int64
+		var modTime time.Time
+		if cfg.needFileInfo() {
+			info, err := cfg.fileInfo(prefix+name, e)
+			if err != nil {
+				return nil, err
+			}
+			modTime = info.ModTime()
+			stale = cfg.isStale(modTime)
+			size = info.Size()
+		}
+		files = append(files, covFile{
+			path:    prefix + name,
+			kind:    kind,
+			hash:    hash,
+			pid:     pid,
+			seq:     seq,
+			origin:  origin,
+			stale:   stale,
+			gz:      gz,
+			size:    size,
+			modTime: modTime,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pods
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// WalkCounterFiles scans the directories 'dirs' as CollectPods does,
+// but rather than forming pods, calls 'fn' for each counter data file
+// with the meta-data file it pairs with, its origin and its process
+// ID. Files are passed to 'fn' as the directories are read, so a
+// counter data file is passed as soon as its meta-data file has been
+// seen, and only counter data files that precede their meta-data
+// file are held back. If 'fn' returns an error, the walk stops and
+// the error is returned. Orphaned counter data files are skipped
+// silently.
+func WalkCounterFiles(dirs []string, fn func(metaFile, counterFile string, origin, pid int) error) error {
+	var cfg Config
+	return cfg.WalkCounterFiles(dirs, fn)
+}
+
+// WalkCounterFiles is similar to the WalkCounterFiles function, but
+// scans directories according to the settings in 'cfg'. Orphaned
+// counter data files are passed to cfg.OnOrphan if it is set, once
+// all directories have been read. Settings that act on complete pods
+// (such as CounterDedup, KeepLatestPerPID, the limits on counter data
+// files and OnPod) are not applied. Files older than cfg.MinModTime
+// are skipped.
+func (cfg *Config) WalkCounterFiles(dirs []string, fn func(metaFile, counterFile string, origin, pid int) error) error {
+	metas := make(map[string]string)
+	pending := make(map[string][]covFile)
+	sc := dirScan{
+		discard: true,
+		visit: func(dir string, files []covFile) error {
+			for i := range files {
+				f := &files[i]
+				if f.kind != MetaDataFile || f.stale {
+					continue
+				}
+				if _, ok := metas[f.hash]; ok {
+					continue
+				}
+				metas[f.hash] = f.path
+				for _, c := range pending[f.hash] {
+					if err := fn(f.path, c.path, c.origin, c.pid); err != nil {
+						return err
+					}
+				}
+				delete(pending, f.hash)
+			}
+			for i := range files {
+				f := &files[i]
+				if f.kind != CounterDataFile || f.stale {
+					continue
+				}
+				if mf, ok := metas[f.hash]; ok {
+					if err := fn(mf, f.path, f.origin, f.pid); err != nil {
+						return err
+					}
+				} else {
+					pending[f.hash] = append(pending[f.hash], *f)
+				}
+			}
+			return nil
+		},
+	}
+	if _, err := cfg.scanDirs(nil, dirs, &sc); err != nil {
+		return err
+	}
+	var orphans []covFile
+	for _, cfs := range pending {
+		orphans = append(orphans, cfs...)
+	}
+	sort.Sort(byPath(orphans))
+	var st Stats
+	for i := range orphans {
+		f := &orphans[i]
+		if cfg.orphanIgnored(f.hash) {
+			continue
+		}
+		if err := cfg.orphan(f, &st, "skipping orphaned counter file"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk visits the files in the directories 'dirs', calling 'fn' for
+// each file with its classification (see FileClass), whether or not
+// CollectPods would collect it. The directories are read as
+// CollectPods reads them, and all of them are read before 'fn' is
+// first called, so that a counter data file is reported as an orphan
+// exactly when CollectPods would skip it as one. Files are passed to
+// 'fn' directory by directory, in lexical order within each
+// directory. Subdirectories themselves are not passed to 'fn'.
+//
+// If a directory can't be read, 'fn' is called with the directory's
+// path, a zero FileClass and the error; the walk then continues with
+// the next directory unless 'fn' returns an error. If 'fn' returns
+// fs.SkipDir, the rest of the current directory is skipped; if it
+// returns fs.SkipAll, the walk stops and Walk returns nil. Any other
+// error stops the walk and is returned by Walk.
+func Walk(dirs []string, fn func(path string, class FileClass, err error) error) error {
+	var cfg Config
+	return cfg.Walk(dirs, fn)
+}
+
+// Walk is similar to the Walk function, but reads directories and
+// classifies files according to the settings in 'cfg'.
+func (cfg *Config) Walk(dirs []string, fn func(path string, class FileClass, err error) error) error {
+	// walked records a directory read by the scan, with the number of
+	// files it holds or the error reading it.
+	type walked struct {
+		dir string
+		n   int
+		err error
+	}
+	var wds []walked
+	sc := dirScan{
+		all: true,
+		visit: func(dir string, files []covFile) error {
+			wds = append(wds, walked{dir: dir, n: len(files)})
+			return nil
+		},
+		dirErr: func(dir string, err error) error {
+			wds = append(wds, walked{dir: dir, err: err})
+			return nil
+		},
+	}
+	files, err := cfg.scanDirs(nil, dirs, &sc)
+	if err != nil {
+		return err
+	}
+	orphans, err := cfg.orphanSet(files)
+	if err != nil {
+		return err
+	}
+	for _, wd := range wds {
+		if wd.err != nil {
+			err = fn(wd.dir, FileClass{}, wd.err)
+		} else {
+			err = walkFiles(files[:wd.n], orphans, fn)
+			files = files[wd.n:]
+		}
+		if err == fs.SkipAll {
+			return nil
+		}
+		if err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkFiles calls 'fn' for each of the files of a directory, for
+// Walk, stopping at the first error.
+func walkFiles(files []covFile, orphans map[string]bool, fn func(path string, class FileClass, err error) error) error {
+	for _, f := range files {
+		fc := FileClass{
+			Name:       filepath.Base(f.path),
+			Kind:       f.kind,
+			Hash:       f.hash,
+			PID:        f.pid,
+			Seq:        f.seq,
+			Compressed: f.gz,
+			Orphan:     orphans[f.path],
+		}
+		if err := fn(f.path, fc, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orphanSet returns the set of counter data files among 'files' that
+// collection would skip as orphans (see Stats.OrphanCounterFiles),
+// found by running collectPodsImpl on them with its side effects
+// turned off.
+func (cfg *Config) orphanSet(files []covFile) (map[string]bool, error) {
+	var cov []covFile
+	for _, f := range files {
+		if f.kind != NonCoverageFile {
+			cov = append(cov, f)
+		}
+	}
+	var st Stats
+	ocfg := *cfg
+	ocfg.Warn = false
+	ocfg.Logf = nil
+	ocfg.Stats = &st
+	ocfg.OnPod = nil
+	ocfg.OnOrphan = nil
+	ocfg.StrictPairing = false
+	ocfg.ErrorOnEmpty = false
+	ocfg.VerifyMetaFiles = false
+	ocfg.MaxCounterFilesPerPod = 0
+	ocfg.GlobalMaxCounterFiles = 0
+	if _, err := collectPodsImpl(cov, &ocfg, os.ReadFile, nil, nil); err != nil {
+		return nil, err
+	}
+	orphans := make(map[string]bool, len(st.OrphanCounterFiles))
+	for _, f := range st.OrphanCounterFiles {
+		orphans[f] = true
+	}
+	return orphans, nil
+}
@@ -6,6 +6,7 @@ package slicereader
 
 import (
 	"encoding/binary"
+	"errors"
 	"internal/unsafeheader"
 	"unsafe"
 )
@@ -82,6 +83,38 @@ func (r *Reader) ReadULEB128() (value uint64) {
 	return
 }
 
+// Errors returned by ReadULEB128Checked.
+var (
+	ErrUnderrun = errors.New("slicereader: ULEB128 value runs off end of buffer")
+	ErrOverflow = errors.New("slicereader: ULEB128 value overflows 64 bits")
+)
+
+// ReadULEB128Checked is like ReadULEB128, but returns an error
+// instead of panicking if the encoded value runs past the end of the
+// slice (ErrUnderrun), or if the encoding is longer than 10 bytes or
+// otherwise does not fit in 64 bits (ErrOverflow). On error the read
+// offset is left unchanged.
+func (r *Reader) ReadULEB128Checked() (uint64, error) {
+	var value uint64
+	var shift uint
+	for i := 0; ; i++ {
+		off := r.off + int64(i)
+		if off < 0 || off >= int64(len(r.b)) {
+			return 0, ErrUnderrun
+		}
+		b := r.b[off]
+		if i == binary.MaxVarintLen64-1 && b > 1 {
+			return 0, ErrOverflow
+		}
+		value |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			r.off = off + 1
+			return value, nil
+		}
+		shift += 7
+	}
+}
+
 func (r *Reader) ReadString(len int64) string {
 	b := r.b[r.off : r.off+len]
 	r.off += len
@@ -90,3 +90,67 @@ func appendUleb128(b []byte, v uint) []byte {
 	}
 	return b
 }
+
+func TestReadULEB128Checked(t *testing.T) {
+	for _, tc := range []struct {
+		b    []byte
+		want uint64
+		err  error
+	}{
+		{appendUleb128(nil, 0), 0, nil},
+		{appendUleb128(nil, 907050301), 907050301, nil},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, 1<<64 - 1, nil},
+		{[]byte{}, 0, ErrUnderrun},
+		{[]byte{0x80, 0x80}, 0, ErrUnderrun},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, 0, ErrOverflow},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, 0, ErrOverflow},
+	} {
+		slr := NewReader(tc.b, false)
+		got, err := slr.ReadULEB128Checked()
+		if got != tc.want || err != tc.err {
+			t.Errorf("ReadULEB128Checked(%x) = %d, %v, want %d, %v", tc.b, got, err, tc.want, tc.err)
+		}
+		if err != nil && slr.Offset() != 0 {
+			t.Errorf("ReadULEB128Checked(%x) moved offset to %d on error", tc.b, slr.Offset())
+		}
+	}
+}
+
+func FuzzReadULEB128Checked(f *testing.F) {
+	f.Add(appendUleb128(nil, 907050301))
+	f.Add([]byte{0x80, 0x80})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		slr := NewReader(b, true)
+		for {
+			off := slr.Offset()
+			v, err := slr.ReadULEB128Checked()
+			// Cross-check against encoding/binary.
+			want, n := binary.Uvarint(b[off:])
+			switch {
+			case n > 0:
+				if err != nil || v != want || slr.Offset() != off+int64(n) {
+					t.Fatalf("at offset %d: got %d, %v; Uvarint got %d (%d bytes)", off, v, err, want, n)
+				}
+			case n == 0 && len(b[off:]) >= binary.MaxVarintLen64:
+				// Uvarint reports a 10-byte prefix with the
+				// continuation bit still set as too short;
+				// we know the encoding is overlong.
+				if err != ErrOverflow {
+					t.Fatalf("at offset %d: got %d, %v; want ErrOverflow", off, v, err)
+				}
+			case n == 0:
+				if err != ErrUnderrun {
+					t.Fatalf("at offset %d: got %d, %v; want ErrUnderrun", off, v, err)
+				}
+			default:
+				if err != ErrOverflow {
+					t.Fatalf("at offset %d: got %d, %v; want ErrOverflow", off, v, err)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"internal/coverage/slicereader"
 	"internal/coverage/uleb128"
 	"io"
+	"sync"
 )
 
 // This package implements string table writer and reader utilities,
@@ -137,3 +138,47 @@ func (str *Reader) Entries() int {
 func (str *Reader) Get(idx uint32) string {
 	return str.strs[idx]
 }
+
+// ReadonlyReader provides read-only access to a string table
+// previously serialized by a Writer.Write call. In addition to
+// looking up strings by index, it supports looking up the index of a
+// string; the index needed for this is built on first use. A
+// ReadonlyReader may be used by multiple goroutines simultaneously.
+type ReadonlyReader struct {
+	strs []string
+
+	once  sync.Once
+	index map[string]uint32
+}
+
+// NewReadonlyReader reads/decodes a string table from 'r',
+// returning a ReadonlyReader for its contents.
+func NewReadonlyReader(r *slicereader.Reader) *ReadonlyReader {
+	str := NewReader(r)
+	str.Read()
+	return &ReadonlyReader{strs: str.strs}
+}
+
+// Entries returns the number of entries in the string table.
+func (ror *ReadonlyReader) Entries() int {
+	return len(ror.strs)
+}
+
+// Get returns string 'idx' within the string table.
+func (ror *ReadonlyReader) Get(idx uint32) string {
+	return ror.strs[idx]
+}
+
+// Lookup returns the index of string 's' within the string table,
+// and whether the string was found. If the table contains duplicate
+// entries for 's', the lowest index is returned.
+func (ror *ReadonlyReader) Lookup(s string) (uint32, bool) {
+	ror.once.Do(func() {
+		ror.index = make(map[string]uint32, len(ror.strs))
+		for i := len(ror.strs) - 1; i >= 0; i-- {
+			ror.index[ror.strs[i]] = uint32(i)
+		}
+	})
+	idx, ok := ror.index[s]
+	return idx, ok
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stringtab
+
+import (
+	"bytes"
+	"internal/coverage/slicereader"
+	"sync"
+	"testing"
+)
+
+func TestReadonlyReader(t *testing.T) {
+	strs := []string{"", "foo", "bar", "a somewhat longer string", "baz"}
+	var stw Writer
+	stw.InitWriter()
+	for _, s := range strs {
+		stw.Lookup(s)
+	}
+	var b bytes.Buffer
+	if err := stw.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	ror := NewReadonlyReader(slicereader.NewReader(b.Bytes(), false))
+	if got := ror.Entries(); got != len(strs) {
+		t.Fatalf("Entries() = %d, want %d", got, len(strs))
+	}
+	for i, s := range strs {
+		if got := ror.Get(uint32(i)); got != s {
+			t.Errorf("Get(%d) = %q, want %q", i, got, s)
+		}
+	}
+
+	// Lookups from several goroutines at once, including the first
+	// lookup, which builds the index.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, s := range strs {
+				if idx, ok := ror.Lookup(s); !ok || idx != uint32(i) {
+					t.Errorf("Lookup(%q) = %d, %v, want %d, true", s, idx, ok, i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if idx, ok := ror.Lookup("missing"); ok {
+		t.Errorf("Lookup(%q) = %d, true, want false", "missing", idx)
+	}
+}
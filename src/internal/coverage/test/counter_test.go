@@ -5,12 +5,16 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"internal/coverage"
 	"internal/coverage/decodecounter"
 	"internal/coverage/encodecounter"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -129,7 +133,10 @@ func TestCounterDataWriterReader(t *testing.T) {
 	}
 }
 
-func TestCounterDataAppendSegment(t *testing.T) {
+// writeSegmentedCounterFile writes a counter data file with
+// 'numSegments' segments to a temporary directory, returning the path
+// of the file and the functions written for each segment.
+func writeSegmentedCounterFile(t *testing.T, numSegments int) (string, [][]decodecounter.FuncPayload) {
 	d := t.TempDir()
 	cfpath := filepath.Join(d, "covcounters.hash2.0")
 	of, err := os.OpenFile(cfpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
@@ -137,8 +144,6 @@ func TestCounterDataAppendSegment(t *testing.T) {
 		t.Fatalf("opening covcounters: %v", err)
 	}
 
-	const numSegments = 2
-
 	// Write a counter with with multiple segments.
 	args := map[string]string{"argc": "1", "argv0": "prog.exe"}
 	allfuncs := [][]decodecounter.FuncPayload{}
@@ -178,6 +183,12 @@ func TestCounterDataAppendSegment(t *testing.T) {
 	if err := of.Close(); err != nil {
 		t.Fatalf("closing covcounters: %v", err)
 	}
+	return cfpath, allfuncs
+}
+
+func TestCounterDataAppendSegment(t *testing.T) {
+	const numSegments = 2
+	cfpath, allfuncs := writeSegmentedCounterFile(t, numSegments)
 
 	// Read the result file.
 	var cdr *decodecounter.CounterDataReader
@@ -229,3 +240,219 @@ func TestCounterDataAppendSegment(t *testing.T) {
 		}
 	}
 }
+
+func TestCounterDataVisitFuncs(t *testing.T) {
+	const numSegments = 3
+	cfpath, allfuncs := writeSegmentedCounterFile(t, numSegments)
+
+	openReader := func() *decodecounter.CounterDataReader {
+		inf, err := os.Open(cfpath)
+		if err != nil {
+			t.Fatalf("reopening covcounters file: %v", err)
+		}
+		t.Cleanup(func() { inf.Close() })
+		cdr, err := decodecounter.NewCounterDataReader(cfpath, inf)
+		if err != nil {
+			t.Fatalf("opening covcounters for read: %v", err)
+		}
+		return cdr
+	}
+
+	var want []string
+	for _, funcs := range allfuncs {
+		for _, fp := range funcs {
+			want = append(want, fmt.Sprintf("%+v", fp))
+		}
+	}
+
+	// Visit everything.
+	var got []string
+	cdr := openReader()
+	err := cdr.VisitFuncs(func(pkgIdx, funcIdx uint32, counters []uint32) bool {
+		got = append(got, fmt.Sprintf("%+v", mkfunc(pkgIdx, funcIdx, counters)))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("VisitFuncs failed: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("VisitFuncs:\ngot  %v\nwant %v", got, want)
+	}
+
+	// Stop partway through the second segment.
+	const stopAfter = 3
+	got = got[:0]
+	cdr = openReader()
+	err = cdr.VisitFuncs(func(pkgIdx, funcIdx uint32, counters []uint32) bool {
+		got = append(got, fmt.Sprintf("%+v", mkfunc(pkgIdx, funcIdx, counters)))
+		return len(got) < stopAfter
+	})
+	if err != nil {
+		t.Fatalf("VisitFuncs with early exit failed: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want[:stopAfter]) {
+		t.Errorf("VisitFuncs with early exit:\ngot  %v\nwant %v", got, want[:stopAfter])
+	}
+}
+
+func TestCounterDataTruncated(t *testing.T) {
+	cfpath, _ := writeSegmentedCounterFile(t, 2)
+	data, err := os.ReadFile(cfpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readAll := func(b []byte) error {
+		cdr, err := decodecounter.NewCounterDataReader(cfpath, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		return cdr.VisitFuncs(func(pkgIdx, funcIdx uint32, counters []uint32) bool {
+			return true
+		})
+	}
+
+	// The complete file reads without error.
+	if err := readAll(data); err != nil {
+		t.Fatalf("reading complete file: %v", err)
+	}
+
+	// Chopping the file off at any point other than just after the
+	// footer, including in the middle of the second segment, yields a
+	// TruncatedFileError, as does a file whose segments end early and
+	// one whose footer claims more segments than the file can hold.
+	spliced := append(append([]byte{}, data[:len(data)/2+6]...), data[len(data)-16:]...)
+	manySegs := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(manySegs[len(manySegs)-8:], 1000)
+	for _, tc := range []struct {
+		what string
+		b    []byte
+	}{
+		{"empty", data[:0]},
+		{"cut at 10", data[:10]},
+		{"cut at 40", data[:40]},
+		{"cut mid-file", data[:len(data)/2+6]},
+		{"cut before footer", data[:len(data)-20]},
+		{"cut in footer", data[:len(data)-1]},
+		{"segments end early", spliced},
+		{"too many segments", manySegs},
+	} {
+		err := readAll(tc.b)
+		var terr *decodecounter.TruncatedFileError
+		if !errors.As(err, &terr) {
+			t.Errorf("%s: got error %v, want TruncatedFileError", tc.what, err)
+			continue
+		}
+		if terr.File != cfpath || terr.Offset > int64(len(tc.b)) {
+			t.Errorf("%s: bad error %+v", tc.what, terr)
+		}
+	}
+
+	// A file without a counter data file header is not truncated, but
+	// not a counter data file at all.
+	bad := append([]byte("junk"), data[4:]...)
+	err = readAll(bad)
+	var terr *decodecounter.TruncatedFileError
+	if err == nil || errors.As(err, &terr) || !strings.Contains(err.Error(), "invalid magic string") {
+		t.Errorf("bad header magic: got error %v, want bad magic error", err)
+	}
+}
+
+func TestCounterDataFuncSubset(t *testing.T) {
+	// Write counters for only some of the functions of a program.
+	subset := encodecounter.FuncCounterList{
+		{PkgIdx: 0, FuncIdx: 3, Counters: []uint32{1, 0, 2}},
+		{PkgIdx: 2, FuncIdx: 0, Counters: []uint32{5}},
+	}
+	var buf bytes.Buffer
+	cdfw := encodecounter.NewCoverageDataWriter(&buf, coverage.CtrULeb128)
+	hash := [16]byte{1, 2, 3}
+	if err := cdfw.Write(hash, map[string]string{"argc": "0"}, subset); err != nil {
+		t.Fatalf("counter file Write failed: %v", err)
+	}
+
+	cdr, err := decodecounter.NewCounterDataReader("subset", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("opening counter data for read: %v", err)
+	}
+	if cdr.NumFunctionsInSegment() != uint32(len(subset)) {
+		t.Errorf("NumFunctionsInSegment: got %d want %d", cdr.NumFunctionsInSegment(), len(subset))
+	}
+	var got []encodecounter.FuncCounters
+	var fp decodecounter.FuncPayload
+	for {
+		ok, err := cdr.NextFunc(&fp)
+		if err != nil {
+			t.Fatalf("reading func %d: %v", len(got), err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, encodecounter.FuncCounters{
+			PkgIdx:   fp.PkgIdx,
+			FuncIdx:  fp.FuncIdx,
+			Counters: append([]uint32(nil), fp.Counters...),
+		})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(subset) {
+		t.Errorf("decoded functions:\ngot  %+v\nwant %+v", got, subset)
+	}
+}
+
+func TestSumFuncCounts(t *testing.T) {
+	d := t.TempDir()
+	writeFile := func(name string, funcs encodecounter.FuncCounterList) string {
+		var buf bytes.Buffer
+		cdfw := encodecounter.NewCoverageDataWriter(&buf, coverage.CtrULeb128)
+		if err := cdfw.Write([16]byte{1, 2, 3}, map[string]string{"argc": "0"}, funcs); err != nil {
+			t.Fatalf("counter file Write failed: %v", err)
+		}
+		path := filepath.Join(d, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	f1 := writeFile("covcounters.hash.1.1", encodecounter.FuncCounterList{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0, 2}},
+		{PkgIdx: 1, FuncIdx: 0, Counters: []uint32{4294967295}},
+	})
+	f2 := writeFile("covcounters.hash.2.1", encodecounter.FuncCounterList{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{3, 0, 0}},
+		{PkgIdx: 0, FuncIdx: 2, Counters: []uint32{7}},
+		{PkgIdx: 1, FuncIdx: 0, Counters: []uint32{1}},
+	})
+	sums, err := decodecounter.SumFuncCounts([]string{f1, f2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[decodecounter.FuncKey]uint32{
+		{PkgIdx: 0, FuncIdx: 0}: 6,
+		{PkgIdx: 0, FuncIdx: 2}: 7,
+		{PkgIdx: 1, FuncIdx: 0}: 4294967295, // saturated
+	}
+	if fmt.Sprint(sums) != fmt.Sprint(want) {
+		t.Errorf("SumFuncCounts: got %v, want %v", sums, want)
+	}
+
+	// Segmented files contribute every segment.
+	seg, allfuncs := writeSegmentedCounterFile(t, 3)
+	if sums, err = decodecounter.SumFuncCounts([]string{seg}); err != nil {
+		t.Fatal(err)
+	}
+	want = make(map[decodecounter.FuncKey]uint32)
+	for _, funcs := range allfuncs {
+		for _, fp := range funcs {
+			for _, c := range fp.Counters {
+				want[decodecounter.FuncKey{PkgIdx: fp.PkgIdx, FuncIdx: fp.FuncIdx}] += c
+			}
+		}
+	}
+	if fmt.Sprint(sums) != fmt.Sprint(want) {
+		t.Errorf("SumFuncCounts of segmented file: got %v, want %v", sums, want)
+	}
+
+	if _, err := decodecounter.SumFuncCounts([]string{f1, filepath.Join(d, "missing")}); err == nil {
+		t.Errorf("SumFuncCounts with missing file: got nil error")
+	}
+}
@@ -5,6 +5,8 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"internal/coverage"
 	"internal/coverage/decodemeta"
@@ -12,6 +14,7 @@ import (
 	"internal/coverage/slicewriter"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -119,6 +122,25 @@ func TestMetaDataEncoderDecoder(t *testing.T) {
 		t.Errorf("packagename: got %s want %s", gotpn, pn)
 	}
 
+	// Every name referenced by the package should be retrievable
+	// from the string table, and out-of-range indices rejected.
+	strs := make(map[string]bool)
+	for i := 0; i < dec.NumStrings(); i++ {
+		s, ok := dec.StringByIndex(uint32(i))
+		if !ok {
+			t.Fatalf("StringByIndex(%d) failed, NumStrings() = %d", i, dec.NumStrings())
+		}
+		strs[s] = true
+	}
+	for _, s := range []string{pp, pn, mp, "func", "foo.go", "xfunc", "bar.go"} {
+		if !strs[s] {
+			t.Errorf("string %q not found in string table", s)
+		}
+	}
+	if s, ok := dec.StringByIndex(uint32(dec.NumStrings())); ok {
+		t.Errorf("StringByIndex(NumStrings()) = %q, true; want failure", s)
+	}
+
 	cases := []coverage.FuncDesc{f1, f2}
 	for i := uint32(0); i < uint32(len(cases)); i++ {
 		var fn coverage.FuncDesc
@@ -201,8 +223,9 @@ func TestMetaDataWriterReader(t *testing.T) {
 	}
 
 	// ... then read it back in, first time without setting fileView,
-	// second time setting it.
-	for k := 0; k < 2; k++ {
+	// second time setting it, third time letting the reader open (and
+	// where possible map) the file itself.
+	for k := 0; k < 3; k++ {
 		var fileView []byte
 
 		inf, err := os.Open(mfpath)
@@ -210,7 +233,7 @@ func TestMetaDataWriterReader(t *testing.T) {
 			t.Fatalf("open() on meta-file: %v", err)
 		}
 
-		if k != 0 {
+		if k == 1 {
 			// Use fileview to exercise different paths in reader.
 			fi, err := os.Stat(mfpath)
 			if err != nil {
@@ -225,7 +248,12 @@ func TestMetaDataWriterReader(t *testing.T) {
 			}
 		}
 
-		mfr, err := decodemeta.NewCoverageMetaFileReader(inf, fileView)
+		var mfr *decodemeta.CoverageMetaFileReader
+		if k == 2 {
+			mfr, err = decodemeta.OpenCoverageMetaFile(mfpath)
+		} else {
+			mfr, err = decodemeta.NewCoverageMetaFileReader(inf, fileView)
+		}
 		if err != nil {
 			t.Fatalf("k=%d NewCoverageMetaFileReader failed with: %v", k, err)
 		}
@@ -270,6 +298,273 @@ func TestMetaDataWriterReader(t *testing.T) {
 				}
 			}
 		}
+		if err := mfr.Close(); err != nil {
+			t.Fatalf("k=%d Close failed with: %v", k, err)
+		}
 		inf.Close()
 	}
 }
+
+func TestMetaDataFuncSummaries(t *testing.T) {
+	b, err := encodemeta.NewCoverageMetaDataBuilder("foo/bar/pkg", "pkg", "barmod")
+	if err != nil {
+		t.Fatalf("making builder: %v", err)
+	}
+	fds := []coverage.FuncDesc{
+		{
+			Funcname: "func",
+			Srcfile:  "foo.go",
+			Units: []coverage.CoverableUnit{
+				{StLine: 6, StCol: 7, EnLine: 8, EnCol: 9, NxStmts: 10},
+				{StLine: 1, StCol: 2, EnLine: 3, EnCol: 4, NxStmts: 5},
+			},
+		},
+		{
+			Funcname: "xfunc",
+			Srcfile:  "bar.go",
+			Units: []coverage.CoverableUnit{
+				{StLine: 11, StCol: 12, EnLine: 13, EnCol: 14, NxStmts: 15},
+			},
+			Lit: true,
+		},
+		{
+			Funcname: "empty",
+			Srcfile:  "bar.go",
+		},
+	}
+	for _, fd := range fds {
+		b.AddFunc(fd)
+	}
+	drws := &slicewriter.WriteSeeker{}
+	b.Emit(drws)
+	dec, err := decodemeta.NewCoverageMetaDataDecoder(drws.BytesWritten(), false)
+	if err != nil {
+		t.Fatalf("NewCoverageMetaDataDecoder error: %v", err)
+	}
+
+	want := []decodemeta.FuncSummary{
+		{Funcname: "func", Srcfile: "foo.go", StLine: 1, EnLine: 8, NumUnits: 2},
+		{Funcname: "xfunc", Srcfile: "bar.go", StLine: 11, EnLine: 13, NumUnits: 1, Lit: true},
+		{Funcname: "empty", Srcfile: "bar.go"},
+	}
+	var got []decodemeta.FuncSummary
+	err = dec.VisitFuncSummaries(func(fidx uint32, fs *decodemeta.FuncSummary) bool {
+		if int(fidx) != len(got) {
+			t.Errorf("visit: got fidx %d want %d", fidx, len(got))
+		}
+		got = append(got, *fs)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("VisitFuncSummaries: %v", err)
+	}
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+		t.Errorf("VisitFuncSummaries:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// Early termination.
+	visited := 0
+	if err := dec.VisitFuncSummaries(func(fidx uint32, fs *decodemeta.FuncSummary) bool {
+		visited++
+		return false
+	}); err != nil {
+		t.Fatalf("VisitFuncSummaries: %v", err)
+	}
+	if visited != 1 {
+		t.Errorf("VisitFuncSummaries: visited %d funcs after returning false, want 1", visited)
+	}
+
+	// Reading summaries should not allocate.
+	var fs decodemeta.FuncSummary
+	allocs := testing.AllocsPerRun(10, func() {
+		for i := uint32(0); i < dec.NumFuncs(); i++ {
+			if err := dec.ReadFuncSummary(i, &fs); err != nil {
+				t.Fatalf("ReadFuncSummary(%d): %v", i, err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("ReadFuncSummary: got %v allocs, want 0", allocs)
+	}
+	if err := dec.ReadFuncSummary(dec.NumFuncs(), &fs); err == nil {
+		t.Errorf("ReadFuncSummary: expected error for illegal index")
+	}
+}
+
+// failingWriter is an io.Writer whose Write fails for the write that
+// starts at offset 'failAt', and succeeds otherwise.
+type failingWriter struct {
+	off, failAt int
+	buf         bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.off == w.failAt {
+		w.off += len(p)
+		return 0, fmt.Errorf("write failed at offset %d", w.failAt)
+	}
+	w.off += len(p)
+	return w.buf.Write(p)
+}
+
+func TestMetaDataWriteError(t *testing.T) {
+	// A failed write of a function offset must be reported, even if
+	// later writes succeed.
+	b, err := encodemeta.NewCoverageMetaDataBuilder("foo/bar/pkg", "pkg", "barmod")
+	if err != nil {
+		t.Fatalf("making builder: %v", err)
+	}
+	for _, f := range createFuncs(3) {
+		b.AddFunc(f)
+	}
+	w := &failingWriter{failAt: int(coverage.CovMetaHeaderSize)}
+	if _, err := b.Finish(w); err == nil {
+		t.Errorf("Finish succeeded despite failed write of function offsets")
+	}
+}
+
+func TestMetaDataFinish(t *testing.T) {
+	// Finish should produce exactly the same bytes as Emit, without
+	// requiring a seekable writer.
+	mkBuilder := func() *encodemeta.CoverageMetaDataBuilder {
+		b, err := encodemeta.NewCoverageMetaDataBuilder("foo/bar/pkg", "pkg", "barmod")
+		if err != nil {
+			t.Fatalf("making builder: %v", err)
+		}
+		for _, f := range createFuncs(3) {
+			b.AddFunc(f)
+		}
+		return b
+	}
+	ews := &slicewriter.WriteSeeker{}
+	edigest, err := mkBuilder().Emit(ews)
+	if err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	var fbuf bytes.Buffer
+	fdigest, err := mkBuilder().Finish(&fbuf)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if edigest != fdigest {
+		t.Errorf("digest mismatch: Emit %x Finish %x", edigest, fdigest)
+	}
+	if !bytes.Equal(ews.BytesWritten(), fbuf.Bytes()) {
+		t.Errorf("Finish output differs from Emit output:\nEmit:   %x\nFinish: %x", ews.BytesWritten(), fbuf.Bytes())
+	}
+
+	// Make sure the result decodes.
+	dec, err := decodemeta.NewCoverageMetaDataDecoder(fbuf.Bytes(), false)
+	if err != nil {
+		t.Fatalf("NewCoverageMetaDataDecoder error: %v", err)
+	}
+	funcs := createFuncs(3)
+	if nf := dec.NumFuncs(); nf != uint32(len(funcs)) {
+		t.Fatalf("dec.NumFuncs(): got %d want %d", nf, len(funcs))
+	}
+	for i, want := range funcs {
+		var fn coverage.FuncDesc
+		if err := dec.ReadFunc(uint32(i), &fn); err != nil {
+			t.Fatalf("err reading function %d: %v", i, err)
+		}
+		if res := cmpFuncDesc(want, fn); res != "" {
+			t.Errorf("ReadFunc(%d): %s", i, res)
+		}
+	}
+}
+
+func TestMetaDataValidate(t *testing.T) {
+	b, err := encodemeta.NewCoverageMetaDataBuilder("my/pkg", "pkg", "m")
+	if err != nil {
+		t.Fatalf("making builder: %v", err)
+	}
+	b.AddFunc(coverage.FuncDesc{
+		Funcname: "f",
+		Srcfile:  "foo.go",
+		Units: []coverage.CoverableUnit{
+			{StLine: 1, StCol: 2, EnLine: 3, EnCol: 4, NxStmts: 5},
+		},
+	})
+	b.AddFunc(coverage.FuncDesc{Funcname: "g", Srcfile: "foo.go"})
+	drws := &slicewriter.WriteSeeker{}
+	b.Emit(drws)
+	good := drws.BytesWritten()
+
+	dec, err := decodemeta.NewCoverageMetaDataDecoder(good, false)
+	if err != nil {
+		t.Fatalf("NewCoverageMetaDataDecoder error: %v", err)
+	}
+	if err := dec.Validate(); err != nil {
+		t.Fatalf("Validate on well-formed meta-data: %v", err)
+	}
+
+	// Offsets of header fields and of the function offset table;
+	// see coverage.MetaSymbolHeader.
+	const (
+		offLength   = 0
+		offPkgName  = 4
+		offNumFiles = 36
+		offNumFuncs = 40
+		offFunc0    = coverage.CovMetaHeaderSize
+		offFunc1    = coverage.CovMetaHeaderSize + 4
+	)
+	func0 := binary.LittleEndian.Uint32(good[offFunc0:])
+	tests := []struct {
+		name    string
+		corrupt func(b []byte) []byte
+		want    string
+	}{
+		{"length", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[offLength:], uint32(len(b)+1))
+			return b
+		}, "exceeds payload size"},
+		{"truncated", func(b []byte) []byte {
+			return b[:len(b)-1]
+		}, "exceeds payload size"},
+		{"numfuncs", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[offNumFuncs:], 1<<20)
+			return b
+		}, "function offset table"},
+		{"numfiles", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[offNumFiles:], 99)
+			return b
+		}, "header string count"},
+		{"pkgname index", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[offPkgName:], 99)
+			return b
+		}, "package name string index"},
+		{"func offset into table", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[offFunc1:], offFunc0)
+			return b
+		}, "function 1: offset"},
+		{"func offset past end", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[offFunc1:], uint32(len(b)))
+			return b
+		}, "function 1: offset"},
+		{"func name index", func(b []byte) []byte {
+			// Function 0 starts with its unit count, then the
+			// string index of its name (both one byte here).
+			b[func0+1] = 0x7f
+			return b
+		}, "function 0: function name string index"},
+		{"func runs off end", func(b []byte) []byte {
+			// Give the last function (which has no units) a
+			// huge unit count.
+			func1 := binary.LittleEndian.Uint32(b[offFunc1:])
+			b[func1] = 0x7f
+			return b
+		}, "function 1: reading coverable unit"},
+	}
+	for _, tc := range tests {
+		payload := tc.corrupt(append([]byte(nil), good...))
+		dec, err := decodemeta.NewCoverageMetaDataDecoder(payload, false)
+		if err == nil {
+			err = dec.Validate()
+		}
+		if err == nil {
+			t.Errorf("%s: no error for corrupted meta-data", tc.name)
+		} else if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %q, want error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -4,6 +4,10 @@
 
 package uleb128
 
+// AppendUleb128 appends the unsigned LEB128 encoding of v to b and
+// returns the extended slice. It allocates only if b lacks the
+// capacity to hold the encoding, so callers on hot paths can pass in
+// a reused scratch buffer (e.g. "buf = AppendUleb128(buf[:0], v)").
 func AppendUleb128(b []byte, v uint) []byte {
 	for {
 		c := uint8(v & 0x7f)
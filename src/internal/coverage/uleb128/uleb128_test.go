@@ -0,0 +1,38 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uleb128
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestAppendUleb128(t *testing.T) {
+	for _, v := range []uint{0, 1, 127, 128, 300, 1<<32 - 1, ^uint(0)} {
+		got := AppendUleb128([]byte{0xaa}, v)
+		want := binary.AppendUvarint([]byte{0xaa}, uint64(v))
+		if !bytes.Equal(got, want) {
+			t.Errorf("AppendUleb128(%d) = %x, want %x", v, got, want)
+		}
+	}
+
+	buf := make([]byte, 0, binary.MaxVarintLen64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = AppendUleb128(buf[:0], math.MaxUint32)
+	})
+	if allocs != 0 {
+		t.Errorf("AppendUleb128 with scratch buffer: got %v allocs, want 0", allocs)
+	}
+}
+
+func BenchmarkAppendUleb128(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = AppendUleb128(buf[:0], uint(i)<<20)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -103,6 +104,8 @@ var (
 	capturedOsArgs map[string]string
 	// Flag used in tests to signal that coverage data already written.
 	covProfileAlreadyEmitted bool
+	// Sequence value used in the most recent counter data file name.
+	lastCounterFileSeq atomic.Int64
 )
 
 // fileType is used to select between counter-data files and
@@ -351,7 +354,7 @@ func (s *emitState) emitCounterDataToWriter(w io.Writer) error {
 func (s *emitState) openMetaFile(metaHash [16]byte, metaLen uint64) error {
 
 	// Open meta-outfile for reading to see if it exists.
-	fn := fmt.Sprintf("%s.%x", coverage.MetaFilePref, metaHash)
+	fn := fmt.Sprintf(coverage.MetaFileTempl, coverage.MetaFilePref, metaHash)
 	s.mfname = filepath.Join(s.outdir, fn)
 	fi, err := os.Stat(s.mfname)
 	if err != nil || fi.Size() != int64(metaLen) {
@@ -371,7 +374,7 @@ func (s *emitState) openMetaFile(metaHash [16]byte, metaLen uint64) error {
 // 's', returning an error if something went wrong.
 func (s *emitState) openCounterFile(metaHash [16]byte) error {
 	processID := os.Getpid()
-	fn := fmt.Sprintf(coverage.CounterFileTempl, coverage.CounterFilePref, metaHash, processID, time.Now().UnixNano())
+	fn := fmt.Sprintf(coverage.CounterFileTempl, coverage.CounterFilePref, metaHash, processID, nextCounterFileSeq())
 	s.cfname = filepath.Join(s.outdir, fn)
 	s.cftmp = filepath.Join(s.outdir, "tmp."+fn)
 	var err error
@@ -382,6 +385,24 @@ func (s *emitState) openCounterFile(metaHash [16]byte) error {
 	return nil
 }
 
+// nextCounterFileSeq returns the sequence value to use in the name of
+// a new counter data file. This is normally the current UnixNano
+// time, but is bumped if needed so that successive counter data files
+// written by this process (for example by repeated calls to
+// EmitCounterDataToDir for the same directory) never share a name.
+func nextCounterFileSeq() int64 {
+	for {
+		last := lastCounterFileSeq.Load()
+		seq := time.Now().UnixNano()
+		if seq <= last {
+			seq = last + 1
+		}
+		if lastCounterFileSeq.CompareAndSwap(last, seq) {
+			return seq
+		}
+	}
+}
+
 // openOutputFiles opens output files in preparation for emitting
 // coverage data. In the case of the meta-data file, openOutputFiles
 // may determine that we can reuse an existing meta-data file in the
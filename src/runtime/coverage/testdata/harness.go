@@ -26,7 +26,7 @@ func emitToWriter() {
 	if err := coverage.EmitMetaDataToWriter(&slwm); err != nil {
 		log.Fatalf("error: EmitMetaDataToWriter returns %v", err)
 	}
-	mf := filepath.Join(*outdirflag, "covmeta.0abcdef")
+	mf := filepath.Join(*outdirflag, "covmeta.0abcdef0abcdef0abcdef0abcdef0abc")
 	if err := ioutil.WriteFile(mf, slwm.BytesWritten(), 0666); err != nil {
 		log.Fatalf("error: writing %s: %v", mf, err)
 	}
@@ -34,7 +34,7 @@ func emitToWriter() {
 	if err := coverage.EmitCounterDataToWriter(&slwc); err != nil {
 		log.Fatalf("error: EmitCounterDataToWriter returns %v", err)
 	}
-	cf := filepath.Join(*outdirflag, "covcounters.0abcdef.99.77")
+	cf := filepath.Join(*outdirflag, "covcounters.0abcdef0abcdef0abcdef0abcdef0abc.99.77")
 	if err := ioutil.WriteFile(cf, slwc.BytesWritten(), 0666); err != nil {
 		log.Fatalf("error: writing %s: %v", cf, err)
 	}
@@ -25,6 +25,24 @@ func processCoverTestDir(dir string, cfile string, cm string, cpkg string) error
 	return processCoverTestDirInternal(dir, cfile, cm, cpkg, os.Stdout)
 }
 
+// snapshotCoverTestDir is called (via a linknamed reference) from
+// testmain code when "go test -cover" is in effect, in response to a
+// call to testing.WriteCoverageData. It writes the meta-data for the
+// test binary (if not already present) and the current values of the
+// coverage counters to 'dir'.
+func snapshotCoverTestDir(dir string) error {
+	ml := getCovMetaList()
+	if len(ml) == 0 {
+		// Test code but no instrumented functions; see the
+		// corresponding comment in processCoverTestDirInternal.
+		return nil
+	}
+	if err := emitMetaDataToDirectory(dir, ml); err != nil {
+		return err
+	}
+	return emitCounterDataToDirectory(dir)
+}
+
 // processCoverTestDirInternal is an io.Writer version of processCoverTestDir,
 // exposed for unit testing.
 func processCoverTestDirInternal(dir string, cfile string, cm string, cpkg string, w io.Writer) error {
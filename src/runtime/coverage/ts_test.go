@@ -5,6 +5,8 @@
 package coverage
 
 import (
+	"fmt"
+	"internal/coverage/pods"
 	"internal/goexperiment"
 	"os"
 	"path/filepath"
@@ -56,3 +58,86 @@ func TestTestSupport(t *testing.T) {
 			want1, want2)
 	}
 }
+
+// TestRepeatedEmit verifies that repeatedly emitting coverage data
+// to the same directory yields a single pod, with a distinct counter
+// data file for each emit.
+func TestRepeatedEmit(t *testing.T) {
+	if !goexperiment.CoverageRedesign {
+		return
+	}
+	ml := getCovMetaList()
+	if len(ml) == 0 {
+		return
+	}
+	dir := t.TempDir()
+	const nemits = 3
+	for i := 0; i < nemits; i++ {
+		if err := emitMetaDataToDirectory(dir, ml); err != nil {
+			t.Fatalf("emit %d: %v", i, err)
+		}
+		if err := emitCounterDataToDirectory(dir); err != nil {
+			t.Fatalf("emit %d: %v", i, err)
+		}
+	}
+	podlist, err := pods.CollectPods([]string{dir}, true)
+	if err != nil {
+		t.Fatalf("CollectPods: %v", err)
+	}
+	if len(podlist) != 1 {
+		t.Fatalf("got %d pods, want 1", len(podlist))
+	}
+	if got := len(podlist[0].CounterDataFiles); got != nemits {
+		t.Fatalf("got %d counter data files, want %d: %v",
+			got, nemits, podlist[0].CounterDataFiles)
+	}
+}
+
+// TestWriteCoverageData verifies that testing.WriteCoverageData,
+// called from two subtests with directories of their own, yields a
+// separate pod in each directory, with the same meta-data file name
+// and distinct counter data file names.
+func TestWriteCoverageData(t *testing.T) {
+	if !goexperiment.CoverageRedesign {
+		return
+	}
+	if testing.CoverMode() == "" || len(getCovMetaList()) == 0 {
+		return
+	}
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for k, dir := range dirs {
+		t.Run(fmt.Sprintf("case%d", k), func(t *testing.T) {
+			if err := testing.WriteCoverageData(dir); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	var got []pods.Pod
+	for _, dir := range dirs {
+		podlist, err := pods.CollectPods([]string{dir}, true)
+		if err != nil {
+			t.Fatalf("CollectPods: %v", err)
+		}
+		if len(podlist) != 1 || len(podlist[0].CounterDataFiles) != 1 {
+			t.Fatalf("%s: got pods %+v, want one pod with one counter data file", dir, podlist)
+		}
+		got = append(got, podlist[0])
+	}
+	if a, b := filepath.Base(got[0].MetaFile), filepath.Base(got[1].MetaFile); a != b {
+		t.Errorf("meta-data files %s and %s differ, want the same hash", a, b)
+	}
+	if a, b := filepath.Base(got[0].CounterDataFiles[0]), filepath.Base(got[1].CounterDataFiles[0]); a == b {
+		t.Errorf("both subtests wrote counter data file %s", a)
+	}
+}
+
+func TestNextCounterFileSeq(t *testing.T) {
+	prev := nextCounterFileSeq()
+	for i := 0; i < 1000; i++ {
+		seq := nextCounterFileSeq()
+		if seq <= prev {
+			t.Fatalf("sequence value %d not greater than previous value %d", seq, prev)
+		}
+		prev = seq
+	}
+}
@@ -7,16 +7,19 @@
 package testing
 
 import (
+	"errors"
 	"fmt"
 	"internal/goexperiment"
 	"os"
 )
 
-// cover2 variable stores the current coverage mode and a
-// tear-down function to be called at the end of the testing run.
+// cover2 variable stores the current coverage mode, a tear-down
+// function to be called at the end of the testing run, and a function
+// that writes out a snapshot of the coverage data to a directory.
 var cover2 struct {
 	mode     string
 	tearDown func(coverprofile string, gocoverdir string) (string, error)
+	snapshot func(dir string) error
 }
 
 // registerCover2 is invoked during "go test -cover" runs by the test harness
@@ -27,6 +30,31 @@ func registerCover2(mode string, tearDown func(coverprofile string, gocoverdir s
 	cover2.tearDown = tearDown
 }
 
+// registerCoverSnapshot is invoked during "go test -cover" runs by the
+// test harness code in _testmain.go; it is used to record the
+// 'snapshot' function called by WriteCoverageData.
+func registerCoverSnapshot(snapshot func(dir string) error) {
+	cover2.snapshot = snapshot
+}
+
+// WriteCoverageData writes the coverage data collected so far by the
+// test binary to the directory 'dir', in the same format that an
+// instrumented program writes to $GOCOVERDIR: a meta-data file (if
+// one for this binary is not already present in 'dir') and a counter
+// data file holding the current counter values. Each call produces a
+// new, uniquely named counter data file, so the function may be called
+// repeatedly, for example once per subtest with a directory of its
+// own. Counters are not reset by the call.
+//
+// WriteCoverageData returns an error if the test binary was not built
+// with "go test -cover", or if the data could not be written.
+func WriteCoverageData(dir string) error {
+	if !goexperiment.CoverageRedesign || cover2.snapshot == nil {
+		return errors.New("testing: coverage data not available (test not built with -cover?)")
+	}
+	return cover2.snapshot(dir)
+}
+
 // coverReport2 invokes a callback in _testmain.go that will
 // emit coverage data at the point where test execution is complete,
 // for "go test -cover" runs.